@@ -0,0 +1,136 @@
+package bench
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"bench/portal"
+)
+
+// AdminState は /state で返す負荷走行の状態
+type AdminState struct {
+	Snapshot
+	Phase  string `json:"phase"`
+	Paused bool   `json:"paused"`
+	Seed   int64  `json:"seed"`
+}
+
+// AdminErrors は /errors で返すエラーの集計. Messages は ?raw=1 のときだけ全件を入れる
+type AdminErrors struct {
+	Count      int                 `json:"count"`
+	Categories map[string]int      `json:"categories"`
+	Groups     []portal.ErrorGroup `json:"groups"`
+	Messages   []string            `json:"messages,omitempty"`
+}
+
+// AdminInvestor は /investors で返すユーザー
+type AdminInvestor struct {
+	BankID  string `json:"bank_id"`
+	Kind    string `json:"kind"`
+	Signin  bool   `json:"signin"`
+	Retired bool   `json:"retired"`
+	Credit  int64  `json:"credit"`
+}
+
+type AdminInvestors struct {
+	Users     int             `json:"users"` // 片付けたユーザーも含めた数
+	Active    int             `json:"active"`
+	Kinds     map[string]int  `json:"kinds"` // 退役していないユーザーの種類ごとの数
+	Investors []AdminInvestor `json:"investors"`
+}
+
+// AdminScore は /score で返すスコア
+type AdminScore struct {
+	Score      int64                `json:"score"`
+	AgentScore int64                `json:"agent_score"`
+	Total      int64                `json:"total"`
+	Final      int64                `json:"final"`
+	Breakdown  []portal.ScoreResult `json:"breakdown"`
+}
+
+// investorKind は config の investor.mix と同じ名前でユーザーの種類を返す
+func investorKind(sc Scenario) string {
+	switch sc.(type) {
+	case *marketMakerScenario:
+		return "market_maker"
+	case *scalperScenario:
+		return "scalper"
+	case *panicSellerScenario:
+		return "panic_seller"
+	case *chartWatcherScenario:
+		return "chart_watcher"
+	case *bruteForceScenario:
+		return "brute_force"
+	case *replayScenario:
+		return "replay"
+	default:
+		return "normal"
+	}
+}
+
+func (c *Manager) adminInvestors() AdminInvestors {
+	c.scenarioLock.Lock()
+	scenarios := make([]Scenario, len(c.scenarios))
+	copy(scenarios, c.scenarios)
+	r := AdminInvestors{Users: len(c.scenarios) + c.purged, Active: c.activeUsers()}
+	c.scenarioLock.Unlock()
+
+	r.Kinds = make(map[string]int, 8)
+	r.Investors = make([]AdminInvestor, 0, len(scenarios))
+	for _, sc := range scenarios {
+		inv := AdminInvestor{
+			BankID:  sc.BankID(),
+			Kind:    investorKind(sc),
+			Signin:  sc.IsSignin(),
+			Retired: sc.IsRetired(),
+			Credit:  sc.Credit(),
+		}
+		if !inv.Retired {
+			r.Kinds[inv.Kind]++
+		}
+		r.Investors = append(r.Investors, inv)
+	}
+	return r
+}
+
+// AdminHandler は /state, /errors, /investors, /score で負荷走行中の Manager の状態をJSONで返す
+// 実行中のベンチを手元から調べるためのもので、状態を変えることはしない
+func (c *Manager) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	write := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+	mux.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
+		write(w, AdminState{
+			Snapshot: c.Snapshot(),
+			Phase:    c.Phase(),
+			Paused:   c.Paused(),
+			Seed:     c.Seed(),
+		})
+	})
+	mux.HandleFunc("/errors", func(w http.ResponseWriter, r *http.Request) {
+		e := AdminErrors{
+			Count:      c.ErrorCount(),
+			Categories: c.ErrorCountByCategory(),
+			Groups:     c.ErrorGroups(),
+		}
+		if r.URL.Query().Get("raw") == "1" {
+			e.Messages = c.GetErrorsString()
+		}
+		write(w, e)
+	})
+	mux.HandleFunc("/investors", func(w http.ResponseWriter, r *http.Request) {
+		write(w, c.adminInvestors())
+	})
+	mux.HandleFunc("/score", func(w http.ResponseWriter, r *http.Request) {
+		write(w, AdminScore{
+			Score:      c.GetScore(),
+			AgentScore: c.AgentScore(),
+			Total:      c.TotalScore(),
+			Final:      c.FinalScore(),
+			Breakdown:  c.scoreboard.Breakdown(c.conf.Score),
+		})
+	})
+	return mux
+}
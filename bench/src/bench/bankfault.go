@@ -0,0 +1,88 @@
+package bench
+
+import (
+	"context"
+	"time"
+
+	"bench/isubank"
+
+	"github.com/pkg/errors"
+)
+
+// startBankFault は負荷走行の間だけ isubank の reserve, commit に障害を注入させる
+func (c *Manager) startBankFault(ctx context.Context) error {
+	bf := c.conf.BankFault
+	if !bf.Enabled() {
+		return nil
+	}
+	_, err := c.isubank.SetFault(ctx, isubank.Fault{
+		FailRate:    bf.FailRate,
+		TimeoutRate: bf.TimeoutRate,
+		Timeout:     bf.Timeout,
+	})
+	if err != nil {
+		return err
+	}
+	c.Slog("bankfault").Info("isubank fault injection", "fail_rate", bf.FailRate, "timeout_rate", bf.TimeoutRate, "timeout_ms", bf.Timeout)
+	return nil
+}
+
+// stopBankFault は障害の注入を止めて、注入した回数を事後テスト用に残す
+func (c *Manager) stopBankFault(ctx context.Context) {
+	if !c.conf.BankFault.Enabled() && c.conf.Chaos == nil {
+		return
+	}
+	f, err := c.isubank.SetFault(ctx, isubank.Fault{})
+	if err != nil {
+		// 止められなくても事後テストでは reserve, commit を呼ばないので続ける
+		c.Slog("bankfault").Warn("isubank fault injection stop failed", "error", err)
+		return
+	}
+	c.bankFault = f
+	c.Slog("bankfault").Info("isubank fault injected", "failed", f.Failed, "timed_out", f.TimedOut)
+}
+
+// testRollback は isubank がエラーを返した取引が巻き戻されているかを調べる
+// 巻き戻されていなければ成約があるのに銀行の入出金履歴がないユーザーがいるはず
+func (t *PostTester) testRollback(ctx context.Context, deadline time.Time) error {
+	tested := map[int64]bool{}
+	for _, tu := range t.tested {
+		tested[tu.UserID()] = true
+	}
+	users := make([]testUser, 0, BankFaultCheckUsers)
+	for _, tu := range t.users {
+		if len(users) >= BankFaultCheckUsers {
+			break
+		}
+		if tested[tu.UserID()] {
+			continue
+		}
+		for _, order := range tu.Orders() {
+			if order.Trade != nil {
+				users = append(users, tu)
+				break
+			}
+		}
+	}
+	if err := t.eachUser(users, func(user testUser) error {
+		for {
+			if err := user.FetchOrders(ctx); err != nil {
+				return errors.Wrapf(err, "注文情報の取得に失敗しました [user:%d]", user.UserID())
+			}
+			err := t.reconcileBank(ctx, user)
+			if err == nil {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return err
+			}
+			if err = pollWait(ctx, PollingInterval); err != nil {
+				return err
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	t.slog.Info("isubank rollback ok", "users", len(users), "failed", t.fault.Failed, "timed_out", t.fault.TimedOut)
+	return nil
+}
@@ -0,0 +1,104 @@
+package bench
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"bench/isubank"
+	"bench/isulog"
+)
+
+// chaosWindow は isubank か isulog を遅く/使えなくしていた期間
+type chaosWindow struct {
+	Target string // "isubank" or "isulog"
+	Mode   string // "slow" or "down"
+	Start  time.Time
+	End    time.Time
+}
+
+// runChaos は ctx が終わるまで Interval ごとに外部サービスのどれかに Window の間だけ障害を起こす
+// 負荷走行の最後の Recovery 秒は障害を起こさず、アプリが復旧できるようにする
+func (c *Manager) runChaos(ctx context.Context) {
+	cc := c.conf.Chaos
+	interval := time.Duration(cc.Interval) * time.Second
+	window := time.Duration(cc.Window) * time.Second
+	recovery := time.Duration(cc.Recovery) * time.Second
+	start := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval - window):
+		}
+		if c.Paused() {
+			continue
+		}
+		if time.Since(start)-c.pause.total()+window+recovery > c.conf.benchmarkTime() {
+			return
+		}
+		w := chaosWindow{
+			Target: []string{"isubank", "isulog"}[rand.Intn(2)],
+			Mode:   []string{"slow", "down"}[rand.Intn(2)],
+			Start:  time.Now(),
+		}
+		if err := c.setChaos(ctx, w.Target, w.Mode); err != nil {
+			c.Slog("chaos").Warn("chaos failed", "target", w.Target, "mode", w.Mode, "error", err)
+			continue
+		}
+		c.Slog("chaos").Info("chaos", "target", w.Target, "mode", w.Mode, "window", window)
+		select {
+		case <-ctx.Done():
+		case <-time.After(window):
+		}
+		// ctx が終わっていても必ず元に戻す
+		rctx, cancel := context.WithTimeout(context.Background(), TeardownLimit)
+		if err := c.setChaos(rctx, w.Target, ""); err != nil {
+			c.Slog("chaos").Warn("chaos restore failed", "target", w.Target, "error", err)
+		}
+		cancel()
+		w.End = time.Now()
+		c.chaosLock.Lock()
+		c.chaosWindows = append(c.chaosWindows, w)
+		c.chaosLock.Unlock()
+	}
+}
+
+// setChaos は target を mode の状態にする. mode が空なら元に戻す
+func (c *Manager) setChaos(ctx context.Context, target, mode string) error {
+	latency := c.conf.Chaos.Latency
+	switch target {
+	case "isubank":
+		// 戻すときは bank_fault で指定した障害の注入に戻す
+		bf := c.conf.BankFault
+		f := isubank.Fault{FailRate: bf.FailRate, TimeoutRate: bf.TimeoutRate, Timeout: bf.Timeout}
+		switch mode {
+		case "slow":
+			f.Latency = latency
+		case "down":
+			f.FailRate, f.TimeoutRate = 100, 0
+		}
+		_, err := c.isubank.SetFault(ctx, f)
+		return err
+	default:
+		f := isulog.Fault{}
+		switch mode {
+		case "slow":
+			f.Latency = latency
+		case "down":
+			f.FailRate = 100
+		}
+		_, err := c.isulog.SetFault(ctx, f)
+		return err
+	}
+}
+
+// chaosEnd は最後に障害を起こしていた期間の終わり. 一度も起こしていなければゼロ値
+func (c *Manager) chaosEnd() time.Time {
+	c.chaosLock.Lock()
+	defer c.chaosLock.Unlock()
+	if len(c.chaosWindows) == 0 {
+		return time.Time{}
+	}
+	return c.chaosWindows[len(c.chaosWindows)-1].End
+}
@@ -0,0 +1,84 @@
+package bench
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// checkNode は依存するチェックがすべて通ってから実行するチェック
+type checkNode struct {
+	name string
+	deps []string
+	f    func() error
+}
+
+// checkGraph は依存関係のあるチェックをまとめて、依存していないもの同士を並列に実行する
+type checkGraph struct {
+	suite checkSuite
+	nodes []checkNode
+}
+
+func (s checkSuite) graph() *checkGraph {
+	return &checkGraph{suite: s}
+}
+
+// add はチェック name を加える. deps には先に add したチェックの名前を渡す
+func (g *checkGraph) add(name string, deps []string, f func() error) {
+	g.nodes = append(g.nodes, checkNode{name: name, deps: deps, f: f})
+}
+
+// run は依存するチェックが通ったものから並列に実行し、チェックごとに結果をログに出す
+// 失敗したチェックに依存するチェックは実行せずに飛ばしたことを記録する
+// 失敗したチェックがあれば add した順で最初のもののエラーを返す
+func (g *checkGraph) run() error {
+	index := make(map[string]int, len(g.nodes))
+	for i, n := range g.nodes {
+		for _, d := range n.deps {
+			if _, ok := index[d]; !ok {
+				return errors.Errorf("check %s depends on unknown check %s", n.name, d)
+			}
+		}
+		index[n.name] = i
+	}
+	done := make([]chan struct{}, len(g.nodes))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	errs := make([]error, len(g.nodes))
+	passed := make([]bool, len(g.nodes))
+	var wg sync.WaitGroup
+	for i, n := range g.nodes {
+		wg.Add(1)
+		go func(i int, n checkNode) {
+			defer wg.Done()
+			defer close(done[i])
+			for _, d := range n.deps {
+				j := index[d]
+				<-done[j]
+				if !passed[j] {
+					g.suite.skip(n.name, d)
+					log.Printf("[INFO] %s %s: skipped (%s)", g.suite.name, n.name, d)
+					return
+				}
+			}
+			start := time.Now()
+			errs[i] = g.suite.run(n.name, n.f)
+			if errs[i] != nil {
+				log.Printf("[INFO] %s %s: failed (%.3fs) %s", g.suite.name, n.name, time.Since(start).Seconds(), errs[i])
+				return
+			}
+			passed[i] = true
+			log.Printf("[INFO] %s %s: ok (%.3fs)", g.suite.name, n.name, time.Since(start).Seconds())
+		}(i, n)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
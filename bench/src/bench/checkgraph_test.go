@@ -0,0 +1,102 @@
+package bench
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestCheckGraphSkip(t *testing.T) {
+	rec := &CheckRecorder{}
+	g := rec.suite("pretest").graph()
+
+	var mu sync.Mutex
+	ran := []string{}
+	check := func(name string, err error) func() error {
+		return func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, name)
+			return err
+		}
+	}
+	g.add("signup", nil, check("signup", nil))
+	g.add("signin", []string{"signup"}, check("signin", errors.New("signin failed")))
+	g.add("orders", []string{"signin"}, check("orders", nil))
+	g.add("trades", []string{"orders"}, check("trades", nil))
+	g.add("info", []string{"signup"}, check("info", nil))
+
+	if err := g.run(); err == nil || err.Error() != "signin failed" {
+		t.Errorf("unexpected error: got:%v expected:signin failed", err)
+	}
+	sort.Strings(ran)
+	if got, expected := strings.Join(ran, ","), "info,signin,signup"; got != expected {
+		t.Errorf("unexpected checks ran: got:%s expected:%s", got, expected)
+	}
+
+	skipped := map[string]string{}
+	for _, cr := range rec.results {
+		if cr.skipped != "" {
+			skipped[cr.name] = cr.skipped
+		}
+	}
+	for name, dep := range map[string]string{"orders": "signin", "trades": "orders"} {
+		if skipped[name] != dep {
+			t.Errorf("unexpected skip of %s: got:%q expected:%q", name, skipped[name], dep)
+		}
+	}
+	if len(skipped) != 2 {
+		t.Errorf("unexpected skipped checks: got:%v", skipped)
+	}
+}
+
+func TestCheckGraphUnknownDependency(t *testing.T) {
+	g := checkSuite{name: "pretest"}.graph()
+	ran := false
+	g.add("signup", nil, func() error {
+		ran = true
+		return nil
+	})
+	g.add("signin", []string{"register"}, func() error { return nil })
+
+	err := g.run()
+	if err == nil {
+		t.Fatal("unknown dependency was not an error")
+	}
+	if expected := "check signin depends on unknown check register"; err.Error() != expected {
+		t.Errorf("unexpected error: got:%s expected:%s", err, expected)
+	}
+	if ran {
+		t.Error("checks ran with an unknown dependency")
+	}
+
+	// 後から add したチェックにも依存できない
+	g = checkSuite{name: "pretest"}.graph()
+	g.add("signin", []string{"signup"}, func() error { return nil })
+	g.add("signup", nil, func() error { return nil })
+	if err := g.run(); err == nil {
+		t.Error("dependency on a later check was not an error")
+	}
+}
+
+func TestCheckGraphFirstError(t *testing.T) {
+	g := checkSuite{name: "posttest"}.graph()
+	second := make(chan struct{})
+	g.add("first", nil, func() error {
+		// 後に add したチェックが先に失敗しても、add した順で最初のエラーを返す
+		<-second
+		return errors.New("first failed")
+	})
+	g.add("second", nil, func() error {
+		defer close(second)
+		return errors.New("second failed")
+	})
+	g.add("third", nil, func() error { return nil })
+
+	if err := g.run(); err == nil || err.Error() != "first failed" {
+		t.Errorf("unexpected error: got:%v expected:first failed", err)
+	}
+}
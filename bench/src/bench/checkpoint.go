@@ -0,0 +1,163 @@
+package bench
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"bench/isubank"
+	"bench/isulog"
+	"github.com/pkg/errors"
+)
+
+// Checkpoint は中断した負荷走行を再開するための状態
+type Checkpoint struct {
+	SavedAt   time.Time           `json:"saved_at"`
+	BankAppID string              `json:"bank_app_id"`
+	LogAppID  string              `json:"log_app_id"`
+	Score     int64               `json:"score"`
+	Level     uint                `json:"level"`
+	Elapsed   int64               `json:"elapsed"` // 中断するまでに負荷走行を進めた時間(ms). 一時停止していた間は含めない
+	Errors    []string            `json:"errors"`
+	ErrorsBy  map[string]int      `json:"errors_by"`
+	Counts    map[ScoreType]int64 `json:"counts"`
+	Users     []CheckpointUser    `json:"users"`
+}
+
+type CheckpointUser struct {
+	BankID string `json:"bank_id"`
+	Name   string `json:"name"`
+	Pass   string `json:"pass"`
+	Isu    int64  `json:"isu"`
+}
+
+func (c *Manager) Checkpoint() *Checkpoint {
+	cp := &Checkpoint{
+		SavedAt:   time.Now(),
+		BankAppID: c.isubank.AppID(),
+		LogAppID:  c.isulog.AppID(),
+		Score:     c.GetScore(),
+		Level:     c.GetLevel(),
+		Elapsed:   int64(c.benchmarkElapsed() / time.Millisecond),
+		Errors:    c.GetErrorsString(),
+		ErrorsBy:  c.ErrorCountByCategory(),
+		Counts:    c.scoreboard.Counts(),
+	}
+	c.scenarioLock.Lock()
+	defer c.scenarioLock.Unlock()
+	for _, sc := range c.scenarios {
+		s, ok := sc.(*normalScenario)
+		if !ok || s.IsRetired() || !s.IsSignin() {
+			continue
+		}
+		cp.Users = append(cp.Users, CheckpointUser{
+			BankID: s.c.bankid,
+			Name:   s.c.name,
+			Pass:   s.c.pass,
+			Isu:    s.currentIsu,
+		})
+	}
+	return cp
+}
+
+func (c *Manager) SaveCheckpoint(path string) error {
+	w, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "checkpoint file create failed")
+	}
+	defer w.Close()
+	if err = json.NewEncoder(w).Encode(c.Checkpoint()); err != nil {
+		return errors.Wrap(err, "checkpoint file encode failed")
+	}
+	return nil
+}
+
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "checkpoint file open failed")
+	}
+	defer r.Close()
+	cp := &Checkpoint{}
+	if err = json.NewDecoder(r).Decode(cp); err != nil {
+		return nil, errors.Wrap(err, "checkpoint file decode failed")
+	}
+	return cp, nil
+}
+
+// Resume はcheckpointの状態から負荷走行を再開できるようにする
+// アプリ側のデータを引き継ぐため、再開時は /initialize を呼ばない
+func (c *Manager) Resume(cp *Checkpoint) error {
+	bank, err := isubank.NewIsubank(c.internalbank, cp.BankAppID)
+	if err != nil {
+		return err
+	}
+	isulog, err := isulog.NewIsulog(c.internallog, cp.LogAppID)
+	if err != nil {
+		return err
+	}
+	c.isubank = bank
+	c.isulog = isulog
+	c.score = cp.Score
+	c.setLevel(cp.Level)
+	for _, e := range cp.Errors {
+		c.errors = append(c.errors, errors.New(e))
+		c.errGroups.add(errors.New(e), cp.SavedAt)
+	}
+	for _, cat := range errorCategories {
+		c.errorsBy[cat] = cp.ErrorsBy[cat.String()]
+	}
+	for st, n := range cp.Counts {
+		c.scoreboard.count[st] = n
+	}
+	c.resumeUsers = cp.Users
+	c.resumedElapsed = time.Duration(cp.Elapsed) * time.Millisecond
+	c.resumed = true
+	return nil
+}
+
+// benchmarkElapsed は負荷走行を進めた時間. 一時停止していた間は含めず、再開したときは中断前の分も含める
+func (c *Manager) benchmarkElapsed() time.Duration {
+	c.phaseLock.Lock()
+	defer c.phaseLock.Unlock()
+	if c.benchStart.IsZero() {
+		return c.resumedElapsed
+	}
+	end := c.benchStop
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return c.resumedElapsed + end.Sub(c.benchStart) - c.pause.total()
+}
+
+// stopBenchmarkClock は負荷走行が終わった時刻を記録する. その後の事後テストなどは負荷走行の時間に含めない
+func (c *Manager) stopBenchmarkClock() {
+	c.phaseLock.Lock()
+	defer c.phaseLock.Unlock()
+	c.benchStop = time.Now()
+}
+
+// benchmarkLength は warm-up を含めた今回の負荷走行の時間. 再開したときは中断前に進めた分だけ短くする
+// 中断と再開を繰り返しても、続けて走らせたときより長く負荷をかけられないようにする
+func (c *Manager) benchmarkLength() time.Duration {
+	d := c.conf.warmUp() + c.conf.benchmarkTime() - c.resumedElapsed
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+func (c *Manager) Resumed() bool {
+	return c.resumed
+}
+
+func (c *Manager) nextResumeUser() (CheckpointUser, bool) {
+	c.scenarioLock.Lock()
+	defer c.scenarioLock.Unlock()
+	if len(c.resumeUsers) == 0 {
+		return CheckpointUser{}, false
+	}
+	u := c.resumeUsers[0]
+	c.resumeUsers = c.resumeUsers[1:]
+	return u, true
+}
@@ -0,0 +1,140 @@
+package bench
+
+import (
+	"encoding/xml"
+	"io"
+	"sync"
+	"time"
+)
+
+type checkResult struct {
+	suite   string
+	name    string
+	elapsed time.Duration
+	err     error
+	skipped string // 失敗して実行しなかった依存先のチェック
+}
+
+// CheckRecorder は PreTest, PostTest の各チェックの結果を記録する
+type CheckRecorder struct {
+	mu      sync.Mutex
+	results []checkResult
+}
+
+func (r *CheckRecorder) suite(name string) checkSuite {
+	return checkSuite{rec: r, name: name}
+}
+
+func (r *CheckRecorder) add(cr checkResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, cr)
+}
+
+// checkSuite は PreTest, PostTest ごとにチェックを記録する
+type checkSuite struct {
+	rec  *CheckRecorder
+	name string
+}
+
+// run は f をチェック name として実行して結果を記録する
+func (s checkSuite) run(name string, f func() error) error {
+	start := time.Now()
+	err := f()
+	if s.rec != nil {
+		s.rec.add(checkResult{suite: s.name, name: name, elapsed: time.Since(start), err: err})
+	}
+	return err
+}
+
+// skip はチェック name を依存先の dep が通らなかったので飛ばしたことを記録する
+func (s checkSuite) skip(name, dep string) {
+	if s.rec != nil {
+		s.rec.add(checkResult{suite: s.name, name: name, skipped: dep})
+	}
+}
+
+// wrap は errgroup に渡せるように run を包む
+func (s checkSuite) wrap(name string, f func() error) func() error {
+	return func() error {
+		return s.run(name, f)
+	}
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      float64         `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+// WriteJUnit は記録したチェックをJUnit XMLで書き出す
+// 依存先が失敗して飛ばしたチェックは skipped になる. 失敗したところで打ち切られたチェックは含まれない
+func (r *CheckRecorder) WriteJUnit(w io.Writer) error {
+	r.mu.Lock()
+	results := make([]checkResult, len(r.results))
+	copy(results, r.results)
+	r.mu.Unlock()
+
+	suites := []junitTestSuite{}
+	index := map[string]int{}
+	for _, cr := range results {
+		i, ok := index[cr.suite]
+		if !ok {
+			i = len(suites)
+			index[cr.suite] = i
+			suites = append(suites, junitTestSuite{Name: cr.suite})
+		}
+		s := &suites[i]
+		tc := junitTestCase{
+			Name:      cr.name,
+			ClassName: "bench." + cr.suite,
+			Time:      cr.elapsed.Seconds(),
+		}
+		if cr.err != nil {
+			tc.Failure = &junitFailure{Message: cr.err.Error(), Text: cr.err.Error()}
+			s.Failures++
+		}
+		if cr.skipped != "" {
+			tc.Skipped = &junitSkipped{Message: "depends on " + cr.skipped}
+			s.Skipped++
+		}
+		s.Tests++
+		s.Time += tc.Time
+		s.TestCases = append(s.TestCases, tc)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: suites}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -2,12 +2,15 @@ package bench
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
@@ -117,6 +120,12 @@ type CandlestickData struct {
 	Low   int64     `json:"low"`
 }
 
+// Campaign は /info で告知されるキャンペーン
+// 新しいIDのキャンペーンが告知されるとユーザーが増え、ボーナスが入る
+type Campaign struct {
+	ID int64 `json:"id"`
+}
+
 type InfoResponse struct {
 	Cursor          int64             `json:"cursor"`
 	TradedOrders    []Order           `json:"traded_orders"`
@@ -126,6 +135,7 @@ type InfoResponse struct {
 	ChartByMin      []CandlestickData `json:"chart_by_min"`
 	ChartByHour     []CandlestickData `json:"chart_by_hour"`
 	EnableShare     bool              `json:"enable_share"`
+	Campaign        *Campaign         `json:"campaign,omitempty"`
 }
 
 type OrderActionResponse struct {
@@ -140,9 +150,27 @@ type Client struct {
 	pass      string
 	name      string
 	cache     *urlcache.CacheStore
-	retired   bool
+	retired   int32 // 退役したら1. 同じユーザーの複数のリクエストから同時に退役させることがあるのでatomicに扱う
 	retireto  time.Duration
+	retire    *retirePolicy  // 負荷走行のユーザーはlevelで退役するまでの時間が変わる
+	latencies *latencyWindow // retire.policy が p95 のときの直近のレイテンシ
+	failures  int32          // 続けて失敗したリクエストの数
+	retirees  *retireStats
 	topLoaded int32
+	metrics   *Metrics
+	trades    *TradeWatcher
+	ids       *idRegistry
+	gate      *pauseGate
+	limiter   *rateLimiter
+	hook      TraceHook
+	har       *harRecorder
+
+	retryBudget   int32 // 残りのGETのリトライ回数
+	retryDelayMin time.Duration
+	retryDelayMax time.Duration
+	conf          ClientConfig
+	longPollOff   int32      // アプリが long polling に対応していなかった
+	rand          *rand.Rand // シナリオの判断やリトライの間隔. 負荷走行のユーザーは Random.User から作る
 }
 
 func NewClient(base, bankid, name, password string, timeout, retire time.Duration) (*Client, error) {
@@ -154,7 +182,7 @@ func NewClient(base, bankid, name, password string, timeout, retire time.Duratio
 	if err != nil {
 		return nil, errors.Wrapf(err, "cookiejar.New Failed.")
 	}
-	transport := &http.Transport{}
+	transport := newTransport(ClientConfig{})
 	hc := &http.Client{
 		Jar:       jar,
 		Transport: transport,
@@ -171,22 +199,194 @@ func NewClient(base, bankid, name, password string, timeout, retire time.Duratio
 		pass:     password,
 		cache:    urlcache.NewCacheStore(),
 		retireto: retire,
+		rand:     globalRand,
 	}, nil
 }
 
+func newTransport(cc ClientConfig) *http.Transport {
+	transport := &http.Transport{
+		MaxIdleConnsPerHost: cc.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     cc.MaxConnsPerHost,
+		IdleConnTimeout:     time.Duration(cc.IdleConnTimeout) * time.Second,
+		DisableKeepAlives:   cc.DisableKeepAlives,
+	}
+	if cc.tls != nil {
+		transport.TLSClientConfig = cc.tls.Clone()
+		// TLSClientConfigを設定すると自動ではHTTP/2を使わなくなる
+		transport.ForceAttemptHTTP2 = !cc.DisableHTTP2
+	}
+	if cc.DisableHTTP2 {
+		// 空のmapを設定するとALPNでh2を提案しなくなる
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+func (c *Client) applyConfig(cc ClientConfig) {
+	c.conf = cc
+	c.hc.Transport = newTransport(cc)
+	c.retryBudget = int32(cc.RetryBudget)
+	c.retryDelayMin = time.Duration(cc.RetryDelayMin) * time.Millisecond
+	c.retryDelayMax = time.Duration(cc.RetryDelayMax) * time.Millisecond
+}
+
+// retryDelay は n 回目のGETのリトライまで待つ時間. 使い切っていたらfalse
+// 同時に失敗したユーザーが一斉にリトライしないようにjitterを入れる
+func (c *Client) retryDelay(n int) (time.Duration, bool) {
+	if atomic.AddInt32(&c.retryBudget, -1) < 0 {
+		return 0, false
+	}
+	d := c.retryDelayMin
+	for i := 0; i < n && d < c.retryDelayMax; i++ {
+		d *= 2
+	}
+	if d > c.retryDelayMax {
+		d = c.retryDelayMax
+	}
+	return d/2 + time.Duration(c.rand.Int63n(int64(d/2)+1)), true
+}
+
+// retryGet はGETをリトライするなら待ってからtrueを返す
+func (c *Client) retryGet(ctx context.Context, method, path string, n int) bool {
+	if method != http.MethodGet {
+		return false
+	}
+	d, ok := c.retryDelay(n)
+	if !ok {
+		return false
+	}
+	c.metrics.observeRetry(method, path)
+	if ctx == nil {
+		time.Sleep(d)
+		return true
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// restartBrowser はブラウザを再起動したときのように、cookieだけを引き継いだ新しいClientを作る
+// コネクションもキャッシュも引き継がない
+func (c *Client) restartBrowser() *Client {
+	hc := *c.hc
+	hc.Transport = newTransport(c.conf)
+	nc := &Client{
+		base:     c.base,
+		hc:       &hc,
+		userID:   c.userID,
+		bankid:   c.bankid,
+		pass:     c.pass,
+		name:     c.name,
+		cache:    urlcache.NewCacheStore(),
+		retireto: c.retireto,
+		retire:   c.retire,
+		retirees: c.retirees,
+		metrics:  c.metrics,
+		gate:     c.gate,
+		limiter:  c.limiter,
+
+		latencies:   c.latencies,
+		longPollOff: atomic.LoadInt32(&c.longPollOff),
+	}
+	nc.applyConfig(c.conf)
+	return nc
+}
+
+func (c *Client) closeIdleConnections() {
+	if t, ok := c.hc.Transport.(*http.Transport); ok {
+		t.CloseIdleConnections()
+	}
+}
+
+// retireTimeout は退役するまでの時間. 負荷走行のユーザーは今のlevelで決まる
+func (c *Client) retireTimeout() time.Duration {
+	if c.retire != nil {
+		return c.retire.timeout()
+	}
+	return c.retireto
+}
+
+// retireBy は reason で退役させて、その理由を記録する. endpoint は退役するきっかけになったリクエスト
+func (c *Client) retireBy(reason, endpoint string) {
+	if !atomic.CompareAndSwapInt32(&c.retired, 0, 1) {
+		return
+	}
+	c.retirees.add(reason, endpoint)
+}
+
+// retireIfSlow は elapsed かかったリクエストで退役させるならそのエラーを返す. msg が空なら遅かったことをエラーにする
+// retire.policy が p95 なら1回遅かっただけでは退役させず、直近のリクエストの p95 が退役するまでの時間を超えたら退役させる
+func (c *Client) retireIfSlow(method, path string, elapsed time.Duration, longPoll bool, msg string) error {
+	limit := c.retireTimeout()
+	if c.latencies == nil {
+		if elapsed <= limit {
+			return nil
+		}
+		if msg == "" {
+			msg = fmt.Sprintf("this user give up browsing because response time is too long. [%.5f s]", elapsed.Seconds())
+		}
+	} else {
+		if longPoll {
+			// 待たせたリクエストは遅くて当然なので数えない
+			return nil
+		}
+		p95, ok := c.latencies.add(elapsed)
+		if !ok || p95 <= limit {
+			return nil
+		}
+		msg = fmt.Sprintf("this user give up browsing because p95 response time of recent requests is too long. [%.5f s]", p95.Seconds())
+	}
+	c.retireBy(RetireReasonTimeout, endpointName(method, path))
+	return &ErrElapsedTimeOverRetire{msg}
+}
+
+// observeFailure は続けて retire.max_errors 回リクエストに失敗したユーザーを退役させる
+// 中断したリクエストや遅くて退役したリクエストは数えない
+func (c *Client) observeFailure(method, path string, status int, err error) {
+	if c.retire == nil || c.retire.conf.MaxErrors < 1 {
+		return
+	}
+	if err == nil && status < 500 {
+		atomic.StoreInt32(&c.failures, 0)
+		return
+	}
+	if _, ok := errors.Cause(err).(*ErrElapsedTimeOverRetire); ok {
+		return
+	}
+	switch errors.Cause(err) {
+	case context.Canceled, context.DeadlineExceeded:
+		return
+	}
+	if atomic.AddInt32(&c.failures, 1) >= int32(c.retire.conf.MaxErrors) {
+		c.retireBy(RetireReasonErrors, endpointName(method, path))
+	}
+}
+
 func (c *Client) IsRetired() bool {
-	return c.retired
+	return atomic.LoadInt32(&c.retired) == 1
 }
 
 func (c *Client) UserID() int64 {
 	return c.userID
 }
 
-func (c *Client) doRequest(ctx context.Context, req *http.Request) (*ResponseWithElapsedTime, error) {
-	if c.retired {
+func (c *Client) doRequest(ctx context.Context, req *http.Request) (rwe *ResponseWithElapsedTime, rerr error) {
+	if c.IsRetired() {
 		return nil, ErrAlreadyRetired
 	}
+	if err := c.gate.wait(ctx); err != nil {
+		return nil, err
+	}
+	method, path := req.Method, req.URL.Path
+	longPoll := req.Header.Get("Prefer") != ""
 	req.Header.Set("User-Agent", UserAgent)
+	if req.Header.Get("Accept-Encoding") == "" {
+		// 自分で展開して圧縮前後のサイズを数える
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
 	var reqbody []byte
 	if req.Body != nil {
 		var err error
@@ -196,7 +396,38 @@ func (c *Client) doRequest(ctx context.Context, req *http.Request) (*ResponseWit
 		}
 	}
 	start := time.Now()
-	for {
+	ev := TraceEvent{BankID: c.bankid, Method: method, Path: path, Start: start, LongPoll: longPoll}
+	if c.hook != nil {
+		c.hook.OnStart(ev)
+	}
+	defer func() {
+		var status int
+		var proto string
+		if rwe != nil {
+			status = rwe.StatusCode
+			proto = rwe.Proto
+		}
+		// 待たせたリクエストは通常の GET /info のレイテンシに混ぜない
+		mpath := path
+		if longPoll {
+			mpath += longPollSuffix
+		}
+		c.metrics.observeRequest(method, mpath, proto, status, rerr != nil, time.Now().Sub(start))
+		c.metrics.observeOutcome(rerr)
+		c.observeFailure(method, path, status, rerr)
+		if c.hook != nil {
+			ev.End, ev.Status = time.Now(), status
+			if rerr != nil {
+				c.hook.OnError(ev, rerr)
+			} else {
+				c.hook.OnFinish(ev)
+			}
+		}
+	}()
+	for try := 0; ; try++ {
+		if err := c.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
 		if reqbody != nil {
 			req.Body = ioutil.NopCloser(bytes.NewBuffer(reqbody))
 		}
@@ -208,9 +439,10 @@ func (c *Client) doRequest(ctx context.Context, req *http.Request) (*ResponseWit
 			elapsedTime := time.Now().Sub(start)
 			if e, ok := err.(*url.Error); ok {
 				// log.Printf("[DEBUG] url.Error %#v", e)
-				if e.Timeout() && c.retireto <= elapsedTime {
-					c.retired = true
-					return nil, &ErrElapsedTimeOverRetire{e.Error()}
+				if e.Timeout() {
+					if err := c.retireIfSlow(method, path, elapsedTime, longPoll, e.Error()); err != nil {
+						return nil, err
+					}
 				}
 				switch e.Err {
 				case context.Canceled, context.DeadlineExceeded:
@@ -219,25 +451,48 @@ func (c *Client) doRequest(ctx context.Context, req *http.Request) (*ResponseWit
 				}
 			}
 			log.Printf("[WARN] err: %s, [%.5f] req.len:%d", err, elapsedTime.Seconds(), req.ContentLength)
-			if elapsedTime < c.retireto {
+			if method == http.MethodGet {
+				// GETは回数を決めてリトライする
+				if elapsedTime < c.retireTimeout() && c.retryGet(ctx, method, path, try) {
+					continue
+				}
+				return nil, err
+			}
+			if elapsedTime < c.retireTimeout() {
 				continue
 			}
 			return nil, err
 		}
 		elapsedTime := time.Now().Sub(start)
-		if c.retireto < elapsedTime {
+		if retireErr := c.retireIfSlow(method, path, elapsedTime, longPoll, ""); retireErr != nil {
 			if err = res.Body.Close(); err != nil {
 				log.Printf("[WARN] body close failed. %s", err)
 			}
-			c.retired = true
-			return nil, &ErrElapsedTimeOverRetire{
-				s: fmt.Sprintf("this user give up browsing because response time is too long. [%.5f s]", elapsedTime.Seconds()),
-			}
+			return nil, retireErr
+		}
+		if res.StatusCode < 500 {
+			c.decompress(method, res)
+		}
+		if c.har != nil {
+			c.har.wrap(req, reqbody, res, start, time.Now())
 		}
 		if res.StatusCode < 500 {
 			return &ResponseWithElapsedTime{res, elapsedTime, ""}, nil
 		}
+		if method == http.MethodGet {
+			retry := c.retryGet(ctx, method, path, try)
+			if !retry {
+				// リトライしきれなければ呼び出し元でstatusのエラーにする
+				return &ResponseWithElapsedTime{res, elapsedTime, ""}, nil
+			}
+			if _, err := io.Copy(ioutil.Discard, res.Body); err != nil {
+				log.Printf("[INFO] retry status code: %d, read body failed: %s", res.StatusCode, err)
+			}
+			res.Body.Close()
+			continue
+		}
 		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
 		if err != nil {
 			log.Printf("[INFO] retry status code: %d, read body failed: %s", res.StatusCode, err)
 		} else {
@@ -247,7 +502,90 @@ func (c *Client) doRequest(ctx context.Context, req *http.Request) (*ResponseWit
 	}
 }
 
+// decompress はgzipで圧縮されたレスポンスを読むときに展開するようにする
+// 展開した内容はこれまで通り呼び出し元で検証されるので、圧縮されていない場合と同じであることが確かめられる
+func (c *Client) decompress(method string, res *http.Response) {
+	if method == http.MethodHead || res.StatusCode == http.StatusNotModified {
+		return
+	}
+	if !strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		return
+	}
+	res.Body = &gzipBody{body: res.Body, raw: &countReader{r: res.Body}, metrics: c.metrics}
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Uncompressed = true
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+type gzipBody struct {
+	body    io.ReadCloser
+	raw     *countReader
+	zr      *gzip.Reader
+	n       int64
+	metrics *Metrics
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.zr == nil {
+		zr, err := gzip.NewReader(b.raw)
+		if err != nil {
+			return 0, errors.Wrap(err, "gzip decode failed")
+		}
+		b.zr = zr
+	}
+	n, err := b.zr.Read(p)
+	b.n += int64(n)
+	if err != nil && err != io.EOF {
+		err = errors.Wrap(err, "gzip decode failed")
+	}
+	return n, err
+}
+
+func (b *gzipBody) Close() error {
+	if b.zr != nil {
+		b.metrics.observeCompression(b.raw.n, b.n)
+	}
+	return b.body.Close()
+}
+
+func (c *Client) benchError(method, path string, res *ResponseWithElapsedTime, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch errors.Cause(err) {
+	case ErrAlreadyRetired, context.Canceled, context.DeadlineExceeded:
+		return err
+	}
+	be := &BenchError{
+		Method: method,
+		Path:   path,
+		BankID: c.bankid,
+		err:    err,
+	}
+	if res != nil {
+		be.StatusCode = res.StatusCode
+		be.Elapsed = res.ElapsedTime
+	}
+	return be
+}
+
 func (c *Client) get(ctx context.Context, path string, val url.Values) (*ResponseWithElapsedTime, error) {
+	return c.getWithHeader(ctx, path, val, nil)
+}
+
+func (c *Client) getWithHeader(ctx context.Context, path string, val url.Values, header http.Header) (*ResponseWithElapsedTime, error) {
 	u, err := c.base.Parse(path)
 	if err != nil {
 		return nil, errors.Wrap(err, "url parse failed")
@@ -261,6 +599,9 @@ func (c *Client) get(ctx context.Context, path string, val url.Values) (*Respons
 	if err != nil {
 		return nil, errors.Wrap(err, "new request failed")
 	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
 	if cache, found := c.cache.Get(us); found {
 		// no-storeを外しかつcache-controlをつければOK
 		// if cache.CanUseCache() {
@@ -321,13 +662,15 @@ func (c *Client) del(ctx context.Context, path string, val url.Values) (*Respons
 	return c.doRequest(ctx, req)
 }
 
-func (c *Client) Initialize(ctx context.Context, bankep, bankid, logep, logid string) error {
+func (c *Client) Initialize(ctx context.Context, bankep, bankid, logep, logid string) (err error) {
+	var res *ResponseWithElapsedTime
+	defer func() { err = c.benchError(http.MethodPost, "/initialize", res, err) }()
 	v := url.Values{}
 	v.Set("bank_endpoint", bankep)
 	v.Set("bank_appid", bankid)
 	v.Set("log_endpoint", logep)
 	v.Set("log_appid", logid)
-	res, err := c.post(ctx, "/initialize", v)
+	res, err = c.post(ctx, "/initialize", v)
 	if err != nil {
 		return errors.Wrap(err, "POST /initialize request failed")
 	}
@@ -342,12 +685,14 @@ func (c *Client) Initialize(ctx context.Context, bankep, bankid, logep, logid st
 	return errorWithStatus(errors.Errorf("POST /initialize failed."), res.StatusCode, string(b))
 }
 
-func (c *Client) Signup(ctx context.Context) error {
+func (c *Client) Signup(ctx context.Context) (err error) {
+	var res *ResponseWithElapsedTime
+	defer func() { err = c.benchError(http.MethodPost, "/signup", res, err) }()
 	v := url.Values{}
 	v.Set("name", c.name)
 	v.Set("bank_id", c.bankid)
 	v.Set("password", c.pass)
-	res, err := c.post(ctx, "/signup", v)
+	res, err = c.post(ctx, "/signup", v)
 	if err != nil {
 		return errors.Wrap(err, "POST /signup request failed")
 	}
@@ -362,11 +707,13 @@ func (c *Client) Signup(ctx context.Context) error {
 	return errorWithStatus(errors.Errorf("POST /signup failed."), res.StatusCode, string(b))
 }
 
-func (c *Client) Signin(ctx context.Context) error {
+func (c *Client) Signin(ctx context.Context) (err error) {
+	var res *ResponseWithElapsedTime
+	defer func() { err = c.benchError(http.MethodPost, "/signin", res, err) }()
 	v := url.Values{}
 	v.Set("bank_id", c.bankid)
 	v.Set("password", c.pass)
-	res, err := c.post(ctx, "/signin", v)
+	res, err = c.post(ctx, "/signin", v)
 	if err != nil {
 		return errors.Wrap(err, "POST /signin request failed")
 	}
@@ -383,7 +730,7 @@ func (c *Client) Signin(ctx context.Context) error {
 		return errors.Wrapf(err, "POST /signin body decode failed")
 	}
 	if r.Name != c.name {
-		return errors.Errorf("POST /signin returned different name [%s] my name is [%s]", r.Name, c.name)
+		return errors.Errorf("POST /signin returned different name [%q] my name is [%q]", r.Name, c.name)
 	}
 	if r.ID == 0 {
 		return errors.Errorf("POST /signin returned zero id")
@@ -392,8 +739,10 @@ func (c *Client) Signin(ctx context.Context) error {
 	return nil
 }
 
-func (c *Client) Signout(ctx context.Context) error {
-	res, err := c.post(ctx, "/signout", url.Values{})
+func (c *Client) Signout(ctx context.Context) (err error) {
+	var res *ResponseWithElapsedTime
+	defer func() { err = c.benchError(http.MethodPost, "/signout", res, err) }()
+	res, err = c.post(ctx, "/signout", url.Values{})
 	if err != nil {
 		return errors.Wrap(err, "POST /signout request failed")
 	}
@@ -411,8 +760,10 @@ func (c *Client) Signout(ctx context.Context) error {
 func (c *Client) Top(ctx context.Context) error {
 	loaded := atomic.AddInt32(&c.topLoaded, 1)
 	for _, sf := range StaticFiles {
-		err := func(sf *StaticFile) error {
-			res, err := c.get(ctx, sf.Path, url.Values{})
+		err := func(sf *StaticFile) (err error) {
+			var res *ResponseWithElapsedTime
+			defer func() { err = c.benchError(http.MethodGet, sf.Path, res, err) }()
+			res, err = c.get(ctx, sf.Path, url.Values{})
 			if err != nil {
 				return errors.Wrapf(err, "GET %s request failed", sf.Path)
 			}
@@ -442,12 +793,68 @@ func (c *Client) Top(ctx context.Context) error {
 	return nil
 }
 
-func (c *Client) Info(ctx context.Context, cursor int64) (*InfoResponse, error) {
+// Revalidate はキャッシュした静的ファイルを If-None-Match, If-Modified-Since を付けて取り直す
+// 変更されていないので304が返るはずで、正しく304を返せた数を返す
+func (c *Client) Revalidate(ctx context.Context) (int, error) {
+	var n int
+	for _, sf := range StaticFiles {
+		u, err := c.base.Parse(sf.Path)
+		if err != nil {
+			return n, errors.Wrap(err, "url parse failed")
+		}
+		cache, found := c.cache.Get(u.String())
+		if !found || (cache.Etag == "" && cache.LastModified == "") {
+			continue
+		}
+		err = func(sf *StaticFile) (err error) {
+			var res *ResponseWithElapsedTime
+			defer func() { err = c.benchError(http.MethodGet, sf.Path, res, err) }()
+			res, err = c.get(ctx, sf.Path, url.Values{})
+			if err != nil {
+				return errors.Wrapf(err, "GET %s request failed", sf.Path)
+			}
+			defer res.Body.Close()
+			b, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				return errors.Wrapf(err, "GET %s body read failed", sf.Path)
+			}
+			switch res.StatusCode {
+			case http.StatusNotModified:
+				if len(b) > 0 {
+					return errors.Errorf("GET %s 304なのにbodyが返されました", sf.Path)
+				}
+				n++
+				return nil
+			case http.StatusOK:
+				// 304を返さなくても内容が正しければエラーにはしない
+				if res.Hash != sf.Hash {
+					return errors.Errorf("GET %s content is modified.", sf.Path)
+				}
+				return nil
+			}
+			return errorWithStatus(errors.Errorf("GET %s failed.", sf.Path), res.StatusCode, string(b))
+		}(sf)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (c *Client) Info(ctx context.Context, cursor int64) (_ *InfoResponse, err error) {
 	path := "/info"
+	var res *ResponseWithElapsedTime
+	defer func() { err = c.benchError(http.MethodGet, path, res, err) }()
 	v := url.Values{}
 	v.Set("cursor", strconv.FormatInt(cursor, 10))
 	//log.Printf("[DEBUG] GET /info?cursor=%d [user:%d]", cursor, c.UserID())
-	res, err := c.get(ctx, path, v)
+	var header http.Header
+	longPoll := cursor > 0 && c.conf.LongPollWait > 0 && atomic.LoadInt32(&c.longPollOff) == 0
+	if longPoll {
+		header = http.Header{}
+		header.Set("Prefer", fmt.Sprintf("wait=%d", int64((c.conf.longPollWait()+time.Second-1)/time.Second)))
+	}
+	res, err = c.getWithHeader(ctx, path, v, header)
 	if err != nil {
 		return nil, errors.Wrapf(err, "GET %s request failed", path)
 	}
@@ -459,8 +866,21 @@ func (c *Client) Info(ctx context.Context, cursor int64) (*InfoResponse, error)
 		}
 		return nil, errorWithStatus(errors.Errorf("GET %s failed.", path), res.StatusCode, string(b))
 	}
+	if longPoll && !strings.Contains(res.Header.Get("Preference-Applied"), "wait") {
+		if atomic.CompareAndSwapInt32(&c.longPollOff, 0, 1) {
+			log.Printf("[INFO] long polling is not supported. fallback to polling [user:%d]", c.UserID())
+		}
+		longPoll = false
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GET %s body read failed", path)
+	}
+	if err := validateJSON(infoSchema, b); err != nil {
+		return nil, errors.Wrapf(err, "GET %s response is invalid", path)
+	}
 	r := &InfoResponse{}
-	if err := json.NewDecoder(res.Body).Decode(r); err != nil {
+	if err := json.Unmarshal(b, r); err != nil {
 		return nil, errors.Wrapf(err, "GET %s body decode failed", path)
 	}
 	// 古いのだけで最新がないのはあり得る
@@ -476,18 +896,58 @@ func (c *Client) Info(ctx context.Context, cursor int64) (*InfoResponse, error)
 		if err := c.testMyOrder(path, r.TradedOrders); err != nil {
 			return nil, err
 		}
+		if err := c.trades.check(path, r.TradedOrders); err != nil {
+			return nil, err
+		}
+		c.ids.observe(path, r.TradedOrders)
+		if longPoll {
+			c.observePush(cursor, r.TradedOrders)
+		}
 	}
 	return r, nil
 }
 
+// observePush は long polling で返された新しい成約が、成約してから届くまでの時間を記録する
+// created_at は秒単位なので最大1秒長く出る
+func (c *Client) observePush(cursor int64, orders []Order) {
+	now := time.Now()
+	for _, o := range orders {
+		if o.Trade == nil || o.Trade.ID <= cursor {
+			continue
+		}
+		if d := now.Sub(o.Trade.CreatedAt); d > 0 {
+			c.metrics.observePush(d)
+		}
+	}
+}
+
 func (c *Client) AddOrder(ctx context.Context, ordertype string, amount, price int64) (*Order, error) {
-	path := "/orders"
 	v := url.Values{}
 	v.Set("type", ordertype)
 	v.Set("amount", strconv.FormatInt(amount, 10))
 	v.Set("price", strconv.FormatInt(price, 10))
+	sent := time.Now()
+	r, err := c.addOrder(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+	o := &Order{
+		ID:     r.ID,
+		Amount: amount,
+		Price:  price,
+		Type:   ordertype,
+	}
+	c.ids.placed(c.UserID(), o, sent, time.Now())
+	return o, nil
+}
+
+// addOrder は v をそのまま送る. テストで不正な値を送るときにも使う
+func (c *Client) addOrder(ctx context.Context, v url.Values) (_ *OrderActionResponse, err error) {
+	path := "/orders"
+	var res *ResponseWithElapsedTime
+	defer func() { err = c.benchError(http.MethodPost, path, res, err) }()
 	//log.Printf("[DEBUG] POST /orders [user:%d]", c.UserID())
-	res, err := c.post(ctx, path, v)
+	res, err = c.post(ctx, path, v)
 	if err != nil {
 		return nil, errors.Wrapf(err, "POST %s request failed", path)
 	}
@@ -506,18 +966,14 @@ func (c *Client) AddOrder(ctx context.Context, ordertype string, amount, price i
 	if r.ID == 0 {
 		return nil, errors.Errorf("POST %s failed. id is not returned", path)
 	}
-
-	return &Order{
-		ID:     r.ID,
-		Amount: amount,
-		Price:  price,
-		Type:   ordertype,
-	}, nil
+	return r, nil
 }
 
-func (c *Client) GetOrders(ctx context.Context) ([]Order, error) {
+func (c *Client) GetOrders(ctx context.Context) (_ []Order, err error) {
 	path := "/orders"
-	res, err := c.get(ctx, path, url.Values{})
+	var res *ResponseWithElapsedTime
+	defer func() { err = c.benchError(http.MethodGet, path, res, err) }()
+	res, err = c.get(ctx, path, url.Values{})
 	if err != nil {
 		return nil, errors.Wrapf(err, "GET %s request failed", path)
 	}
@@ -536,13 +992,16 @@ func (c *Client) GetOrders(ctx context.Context) ([]Order, error) {
 	if err := c.testMyOrder(path, orders); err != nil {
 		return nil, err
 	}
+	c.ids.observe(path, orders)
 	return orders, nil
 }
 
-func (c *Client) DeleteOrders(ctx context.Context, id int64) error {
+func (c *Client) DeleteOrders(ctx context.Context, id int64) (err error) {
 	path := fmt.Sprintf("/order/%d", id)
+	var res *ResponseWithElapsedTime
+	defer func() { err = c.benchError(http.MethodDelete, path, res, err) }()
 	//log.Printf("[DEBUG] DELETE %s [user:%d]", path, c.UserID())
-	res, err := c.del(ctx, path, url.Values{})
+	res, err = c.del(ctx, path, url.Values{})
 	if err != nil {
 		return errors.Wrapf(err, "DELETE %s request failed", path)
 	}
@@ -568,16 +1027,16 @@ func (c *Client) testMyOrder(path string, orders []Order) error {
 	var tc time.Time
 	for _, order := range orders {
 		if order.UserID != c.userID {
-			return errors.Errorf("GET %s returned not my order [id:%d, user_id:%d]", path, order.ID, c.UserID)
+			return errors.Errorf("GET %s returned not my order [id:%d, user_id:%d]", path, order.ID, c.UserID())
 		}
 		if order.User == nil {
-			return errors.Errorf("GET %s returned not filled user [id:%d, user_id:%d]", path, order.ID, c.UserID)
+			return errors.Errorf("GET %s returned not filled user [id:%d, user_id:%d]", path, order.ID, c.UserID())
 		}
 		if order.User.Name != c.name {
-			return errors.Errorf("GET %s returned filled user.name is not my name [id:%d, user_id:%d]", path, order.ID, c.UserID)
+			return errors.Errorf("GET %s returned filled user.name is not my name [id:%d, user_id:%d, name:%q, expected:%q]", path, order.ID, c.UserID(), order.User.Name, c.name)
 		}
 		if order.TradeID != 0 && order.Trade == nil {
-			return errors.Errorf("GET %s returned not filled trade [id:%d, user_id:%d]", path, order.ID, c.UserID)
+			return errors.Errorf("GET %s returned not filled trade [id:%d, user_id:%d]", path, order.ID, c.UserID())
 		}
 		if order.CreatedAt.Before(tc) {
 			return errors.Errorf("GET %s sort order is must be created_at desc", path)
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"bench"
+)
+
+// nodeStatus は -remote, -worker で動き続けるプロセスが /healthz, /readyz で返す状態
+// 1つのプロセスは同時に1つのジョブしか実行しないので、実行中なら次のジョブは受けられない
+type nodeStatus struct {
+	OK    bool          `json:"ok"`
+	State string        `json:"state"` // idle か busy
+	JobID int           `json:"job_id,omitempty"`
+	Run   *bench.Health `json:"run,omitempty"` // 実行中のジョブの bank_id の作成などの状態
+}
+
+func slotStatus(slot *jobSlot) nodeStatus {
+	r := slot.current()
+	if r == nil || !r.running() {
+		return nodeStatus{State: "idle"}
+	}
+	return nodeStatus{State: "busy", JobID: r.job.ID, Run: r.mgr.Healthz()}
+}
+
+// handleNodeHealth は mux に /healthz と /readyz を加える
+// /healthz は実行中のジョブも含めてプロセスが正常か、/readyz は次のジョブを受けられる (idle) かを返し、そうでなければ 503 にする
+func handleNodeHealth(mux *http.ServeMux, slot *jobSlot) {
+	write := func(w http.ResponseWriter, st nodeStatus) {
+		w.Header().Set("Content-Type", "application/json")
+		if !st.OK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(st)
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		st := slotStatus(slot)
+		st.OK = st.Run == nil || st.Run.OK
+		write(w, st)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		st := slotStatus(slot)
+		st.OK = st.State == "idle"
+		write(w, st)
+	})
+}
@@ -5,32 +5,113 @@ import (
 	crand "crypto/rand"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"flag"
 	"io"
 	"log"
+	"log/slog"
 	"math/rand"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"bench"
+	"bench/portal"
 )
 
 var (
-	appep        = flag.String("appep", "https://localhost.isucon8.flying-chair.net", "app endpoint")
+	appep        = flag.String("appep", "https://localhost.isucon8.flying-chair.net", "app endpoint (comma separated to distribute users across servers)")
 	bankep       = flag.String("bankep", "https://compose.isucon8.flying-chair.net:5515", "isubank endpoint")
 	logep        = flag.String("logep", "https://compose.isucon8.flying-chair.net:5516", "isulog endpoint")
 	internalbank = flag.String("internalbank", "https://localhost.isucon8.flying-chair.net:5515", "isubank endpoint (for internal)")
 	internallog  = flag.String("internallog", "https://localhost.isucon8.flying-chair.net:5516", "isulog endpoint (for internal)")
 	jobid        = flag.String("jobid", "", "portal jobid")
 	logoutput    = flag.String("log", "", "output log path (default stderr)")
+	logformat    = flag.String("log-format", "", "log format text|json (default text)")
+	loglevel     = flag.String("log-level", "", "minimum log level debug|info|warn|error (default info)")
+	logbuffer    = flag.Int("log-buffer-size", 0, "max bytes of the log kept for the result, older lines are dropped (default 8MiB)")
+	logarchive   = flag.String("log-archive", "", "write every log line kept for the result to this path, including dropped ones (default disabled)")
 	result       = flag.String("result", "", "result json path (default stdout)")
 	teestdout    = flag.String("teestdout", "", "tee stdout")
 	stateout     = flag.String("stateout", "", "save state filename")
+	configfile   = flag.String("config", "", "config file path, toml (.toml) or json (default built-in values)")
+	listen       = flag.String("listen", "", "listen address for bench status endpoints such as /metrics, /stream, /healthz and /readyz, only /healthz and /readyz with -worker (default disabled)")
+	checkpoint   = flag.String("checkpoint", "", "save checkpoint filename on SIGINT/SIGTERM")
+	resume       = flag.String("resume", "", "resume from checkpoint filename")
+	agent        = flag.String("agent", "", "run as an agent of the coordinator bench at this URL (coordinator needs -listen)")
+	agentid      = flag.String("agentid", "", "agent id (default hostname)")
+	disablehttp2 = flag.Bool("disable-http2", false, "do not negotiate HTTP/2 with the app")
+	cafile       = flag.String("cafile", "", "CA bundle to verify the app certificate")
+	certfile     = flag.String("certfile", "", "client certificate file")
+	keyfile      = flag.String("keyfile", "", "client certificate key file")
+	insecure     = flag.Bool("insecure-skip-verify", false, "do not verify the app certificate")
+	maxidle      = flag.Int("max-idle-conns-per-host", 0, "max idle connections per host of each client (default net/http default)")
+	maxconns     = flag.Int("max-conns-per-host", 0, "max connections per host of each client (default unlimited)")
+	idletimeout  = flag.Duration("idle-conn-timeout", 0, "idle connection timeout of each client (default no timeout)")
+	nokeepalive  = flag.Bool("disable-keep-alives", false, "do not reuse connections to the app")
+	duration     = flag.Duration("duration", 0, "benchmark duration (default 60s)")
+	warmup       = flag.Duration("warm-up", 0, "do not count score and errors for this duration at the start of the benchmark, which is extended by it (default none)")
+	pretimeout   = flag.Duration("pretest-timeout", 0, "timeout of the pretest (default unlimited)")
+	posttimeout  = flag.Duration("posttest-timeout", 0, "timeout of the posttest, 0 in -config means unlimited (default 60s)")
+	postusers    = flag.Int("posttest-users", 0, "number of users whose credit and logs are verified by the posttest (default 3)")
+	postgrace    = flag.Duration("posttest-grace", 0, "wait after the benchmark before the posttest (default 50ms)")
+	inittimeout  = flag.Duration("init-timeout", envDuration("BENCH_INIT_TIMEOUT"), "timeout of initialize (default 30s or $BENCH_INIT_TIMEOUT)")
+	initlimit    = flag.Duration("init-limit", 0, "fail if initialize takes longer than this (default 10s)")
+	timeout      = flag.Duration("client-timeout", envDuration("BENCH_CLIENT_TIMEOUT"), "timeout of each request (default 15s or $BENCH_CLIENT_TIMEOUT)")
+	retire       = flag.Duration("retire-timeout", envDuration("BENCH_RETIRE_TIMEOUT"), "a user who waits longer than this retires, shortened as the level goes up by retire in -config (default 10s or $BENCH_RETIRE_TIMEOUT)")
+	longpoll     = flag.Duration("long-poll", 0, "hold GET /info open up to this duration if the app supports long polling (default disabled)")
+	maxrps       = flag.Int("max-rps", 0, "max requests per second sent to the app by the whole bench (default unlimited)")
+	tracefile    = flag.String("trace", "", "write every request of the users as json lines to this path (default disabled)")
+	harfile      = flag.String("har", "", "write sampled requests and responses of the users as HAR to this path (default disabled)")
+	harsample    = flag.Float64("har-sample", 0.01, "sampling rate of the HAR recording. error responses are always recorded")
+	logrequests  = flag.Float64("log-requests", 0, "log method, path, status and latency of this fraction of the requests (e.g. 0.01, default disabled)")
+	slowthresh   = flag.Duration("slow-threshold", 0, "show the slowest requests which took longer than this at the end (default 1s)")
+	recordfile   = flag.String("record-workload", "", "write the orders and cancels of the users as json to this path for -replay (default disabled)")
+	replayfile   = flag.String("replay", "", "replay the workload recorded by -record-workload instead of level up by score")
+	tradestream  = flag.String("trade-stream", "", "websocket path of the app trade notification (default disabled)")
+	bfpasswords  = flag.String("bruteforce-passwords", "", "password list file for brute force login (default password000-999)")
+	bfaccounts   = flag.String("bruteforce-accounts", "", "bank_id list file attacked by brute force login (default existing users)")
+	planfile     = flag.String("plan", "", "benchmark plan file path, toml (.toml) or json (default level up by score)")
+	profile      = flag.String("profile", "", "load profile ramp|spike|soak|step (default level up by score, ignored with -plan)")
+	dryrun       = flag.Bool("dry-run", false, "run initialize, pretest and each user action once without load, then print a report")
+	scoremodel   = flag.String("score-model", "", "score formula demerit|linear|raw (default demerit)")
+	seed         = flag.Int64("seed", 0, "random seed reported in the result. with the same seed the n-th user gets the same name, password and decisions (default random)")
+	reporthtml   = flag.String("report-html", "", "write html report to this path after the run (default disabled)")
+	junit        = flag.String("junit", "", "write pretest and posttest results as JUnit XML to this path (default disabled)")
+	submit       = flag.String("submit", "", "portal endpoint to POST the result json (default disabled)")
+	submitsecret = flag.String("submit-secret", os.Getenv("BENCH_SUBMIT_SECRET"), "HMAC secret to sign the submitted result (default $BENCH_SUBMIT_SECRET)")
+	signkey      = flag.String("sign-key", os.Getenv("BENCH_SIGN_KEY"), "HMAC key to sign the result json, checked by the verify subcommand (default $BENCH_SIGN_KEY)")
+	node         = flag.String("node", "", "bench node id sent with the result (default hostname)")
+	tui          = flag.Bool("tui", false, "show live status on stderr (logs are discarded unless -log is set)")
+	adminaddr    = flag.String("admin", "", "listen address for the admin endpoints /state, /errors, /investors and /score (default disabled)")
+	remote       = flag.String("remote", "", "listen address to accept jobs from the portal by StartRun, AbortRun, GetStatus and StreamLogs (HTTP+JSON) instead of running once, also serving /healthz and /readyz")
+	remotetoken  = flag.String("remote-token", os.Getenv("BENCH_REMOTE_TOKEN"), "bearer token required by the -remote endpoints (default $BENCH_REMOTE_TOKEN)")
+	controltoken = flag.String("control-token", os.Getenv("BENCH_CONTROL_TOKEN"), "bearer token required by /control/pause, /control/resume and /control/status on -listen, not served without it (default $BENCH_CONTROL_TOKEN)")
+	worker       = flag.String("worker", "", "portal URL to poll for jobs, run each job in this process and post the results back (default disabled)")
+	pprofaddr    = flag.String("pprof", "", "listen address for net/http/pprof of the bench itself (default disabled)")
+	runtimestats = flag.Duration("runtime-stats", 0, "log goroutine and heap stats of the bench at this interval (default disabled)")
+	bankfail     = flag.Int("bank-fail-rate", 0, "percentage of isubank reserve/commit that return an error during the benchmark (default disabled)")
+	banktimeout  = flag.Int("bank-timeout-rate", 0, "percentage of isubank reserve/commit that drop the connection during the benchmark (default disabled)")
+	assertp95    = flag.Duration("assert-p95", 0, "fail if the 95th percentile latency of all requests exceeds this (default disabled)")
+	asserterrors = percentFlag("assert-error-rate", "fail if the rate of failed requests exceeds this, e.g. 1% (default disabled)")
+	maxinvestors = flag.Int("max-investors", 0, "max number of users running at the same time (default unlimited)")
+	lang         = flag.String("lang", bench.LangJa, "language of the messages and the result ja|en")
+	chaos        = flag.Bool("chaos", false, "make isubank and isulog slow or unavailable for short windows during the benchmark")
 	logout       = os.Stderr
 	out          = os.Stdout
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		if err := runVerify(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	flag.Parse()
 	var err error
 	if *result != "" {
@@ -46,8 +127,38 @@ func main() {
 			log.Fatal(err)
 		}
 		defer logout.Close()
+	} else if *tui {
+		// 画面が崩れるのでログは捨てる
+		logout, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer logout.Close()
+	}
+	if err = bench.SetLang(*lang); err != nil {
+		log.Fatal(err)
+	}
+	if *logformat == bench.LogFormatJSON {
+		// log.Printf のログも JSON にする
+		h, err := bench.NewSlogHandler(logout, *logformat, *loglevel)
+		if err != nil {
+			log.Fatal(err)
+		}
+		slog.SetDefault(slog.New(h))
+	} else {
+		log.SetOutput(bench.TranslateWriter(logout))
+	}
+	if *remote != "" {
+		log.Fatal(serveRemote(*remote, *remotetoken))
+	}
+	if *worker != "" {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if err = runWorker(ctx, *worker, *listen); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
-	log.SetOutput(logout)
 	if err = run(); err != nil {
 		log.Fatal(err)
 	}
@@ -65,22 +176,397 @@ func run() error {
 	} else {
 		writer = logout
 	}
-	mgr, err := bench.NewManager(writer, *appep, *bankep, *logep, *internalbank, *internallog, *stateout)
+	conf, err := loadConfig()
 	if err != nil {
 		return err
 	}
-	defer mgr.Close()
-	msg := "ok"
-	bm := bench.NewRunner(mgr)
-	if err = bm.Run(context.Background()); err != nil {
-		msg = err.Error()
-		mgr.Logger().Printf(msg)
+	mgr, err := bench.NewManager(writer, *appep, *bankep, *logep, *internalbank, *internallog, *stateout, conf)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), bench.TeardownLimit)
+		defer cancel()
+		if err := mgr.Close(ctx); err != nil {
+			log.Printf("[WARN] %s", err)
+		}
+	}()
+	if *tracefile != "" {
+		mgr.EnableTrace()
+	}
+	if *harfile != "" {
+		mgr.EnableHAR(*harsample)
+	}
+	if *logrequests > 0 {
+		mgr.EnableRequestLog(*logrequests)
+	}
+	if *recordfile != "" {
+		mgr.EnableWorkloadRecord()
+	}
+	if *replayfile != "" {
+		wl, err := bench.LoadWorkload(*replayfile)
+		if err != nil {
+			return err
+		}
+		mgr.Replay(wl)
+	}
+	if *listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", mgr.MetricsHandler())
+		mux.Handle("/stream", mgr.StreamHandler())
+		mux.Handle("/agent/", mgr.AgentHandler())
+		// 止められると採点中の負荷走行が止まるので、tokenがなければ /control/ は出さない
+		if *controltoken != "" {
+			mux.Handle("/control/", authorize(*controltoken, mgr.ControlHandler().ServeHTTP))
+		} else {
+			log.Printf("[INFO] /control/ is disabled. set -control-token to pause and resume")
+		}
+		health := mgr.HealthHandler()
+		mux.Handle("/healthz", health)
+		mux.Handle("/readyz", health)
+		go func() {
+			if err := http.ListenAndServe(*listen, mux); err != nil {
+				log.Printf("[WARN] listen %s failed. %s", *listen, err)
+			}
+		}()
+	}
+	if *adminaddr != "" {
+		go func() {
+			if err := http.ListenAndServe(*adminaddr, mgr.AdminHandler()); err != nil {
+				log.Printf("[WARN] admin listen %s failed. %s", *adminaddr, err)
+			}
+		}()
+	}
+	if *resume != "" {
+		cp, err := bench.LoadCheckpoint(*resume)
+		if err != nil {
+			return err
+		}
+		if err = mgr.Resume(cp); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-sigchan; ok {
+			// 後片付けが終わらなくても2回目のシグナルで止められるように、元の動作に戻す
+			signal.Stop(sigchan)
+			cancel()
+		}
+	}()
+	defer signal.Stop(sigchan)
+	// SIGUSR1で一時停止, SIGUSR2で再開
+	pausechan := make(chan os.Signal, 1)
+	signal.Notify(pausechan, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range pausechan {
+			if sig == syscall.SIGUSR1 {
+				mgr.Pause()
+			} else {
+				mgr.Unpause()
+			}
+		}
+	}()
+	defer signal.Stop(pausechan)
+	if *tui {
+		go mgr.RunTUI(ctx, os.Stderr)
+	}
+	if *pprofaddr != "" {
+		go servePprof(*pprofaddr)
+	}
+	if *runtimestats > 0 {
+		go logRuntimeStats(ctx, *runtimestats)
+	}
+
+	if *agent != "" {
+		id := *agentid
+		if id == "" {
+			id, _ = os.Hostname()
+		}
+		return bench.NewAgent(mgr, id, *agent).Run(ctx)
+	}
+
+	if *dryrun {
+		report := bench.NewRunner(mgr).DryRun(ctx)
+		json.NewEncoder(out).Encode(report)
+		if !report.OK {
+			return errors.New("dry-run failed")
+		}
+		return nil
 	}
-	result := bm.Result()
+
+	// 失敗した理由は結果の message に入る
+	res, _ := mgr.Run(ctx)
+	// ctxはシグナルを受けたときだけcancelされる
+	if ctx.Err() != nil && *checkpoint != "" {
+		if err := mgr.SaveCheckpoint(*checkpoint); err != nil {
+			log.Printf("[WARN] save checkpoint failed. %s", err)
+		} else {
+			log.Printf("[INFO] checkpoint saved to %s", *checkpoint)
+		}
+	}
+	result := res.BenchResult
 	result.JobID = *jobid
 	result.IPAddrs = *appep
-	result.Message = msg
+	if *submit != "" {
+		// 署名した後に書き換えないように送る前に入れておく
+		result.BenchNode = nodeID()
+	}
+	if *signkey != "" {
+		if err := portal.SignResult(*signkey, &result); err != nil {
+			log.Printf("[WARN] sign result failed. %s", err)
+		}
+	}
 	json.NewEncoder(out).Encode(result)
+	if *reporthtml != "" {
+		if err := writeHTMLReport(*reporthtml, result, res.Timeline); err != nil {
+			log.Printf("[WARN] write html report failed. %s", err)
+		}
+	}
+	if *junit != "" {
+		if err := writeJUnit(*junit, res.Checks); err != nil {
+			log.Printf("[WARN] write junit failed. %s", err)
+		}
+	}
+	if *tracefile != "" {
+		if err := writeTrace(*tracefile, mgr); err != nil {
+			log.Printf("[WARN] write trace failed. %s", err)
+		}
+	}
+	if *harfile != "" {
+		if err := writeHAR(*harfile, mgr); err != nil {
+			log.Printf("[WARN] write har failed. %s", err)
+		}
+	}
+	if *recordfile != "" {
+		if err := writeWorkload(*recordfile, mgr); err != nil {
+			log.Printf("[WARN] write workload failed. %s", err)
+		}
+	}
+	if *submit != "" {
+		if err := portal.NewSubmitter(*submit, *submitsecret, result.BenchNode).Submit(context.Background(), result); err != nil {
+			log.Printf("[WARN] submit result failed. %s", err)
+		} else {
+			log.Printf("[INFO] result submitted to %s", *submit)
+		}
+	}
+	// 結果を出してから、スコアとは別に性能の条件を満たしていなければ失敗にする
+	return mgr.CheckSLA(bench.SLA{P95: *assertp95, ErrorRate: *asserterrors})
+}
+
+// loadConfig は -config の設定にフラグで指定した値を上書きする
+func loadConfig() (*bench.Config, error) {
+	conf, err := bench.LoadConfig(*configfile)
+	if err != nil {
+		return nil, err
+	}
+	if *seed != 0 {
+		conf.Seed = *seed
+	}
+	if *scoremodel != "" {
+		conf.ScoreModel = *scoremodel
+	}
+	if *disablehttp2 {
+		conf.Client.DisableHTTP2 = true
+	}
+	if *cafile != "" {
+		conf.Client.CAFile = *cafile
+	}
+	if *certfile != "" {
+		conf.Client.CertFile, conf.Client.KeyFile = *certfile, *keyfile
+	}
+	if *tradestream != "" {
+		conf.TradeStreamPath = *tradestream
+	}
+	if *bfpasswords != "" {
+		conf.Investor.BruteForcePasswordFile = *bfpasswords
+	}
+	if *bfaccounts != "" {
+		conf.Investor.BruteForceAccountFile = *bfaccounts
+	}
+	if *planfile != "" {
+		if conf.Plan, err = bench.LoadPlan(*planfile); err != nil {
+			return nil, err
+		}
+	}
+	if *profile != "" {
+		conf.Profile = *profile
+	}
+	if *insecure {
+		conf.Client.InsecureSkipVerify = true
+	}
+	if *maxidle > 0 {
+		conf.Client.MaxIdleConnsPerHost = *maxidle
+	}
+	if *maxconns > 0 {
+		conf.Client.MaxConnsPerHost = *maxconns
+	}
+	if *idletimeout > 0 {
+		conf.Client.IdleConnTimeout = int(*idletimeout / time.Second)
+	}
+	if *nokeepalive {
+		conf.Client.DisableKeepAlives = true
+	}
+	if *maxrps > 0 {
+		conf.Client.MaxRPS = *maxrps
+	}
+	if *duration > 0 {
+		conf.Duration = int64(*duration / time.Second)
+	}
+	if *warmup > 0 {
+		conf.WarmUp = int64(*warmup / time.Second)
+	}
+	if *pretimeout > 0 {
+		conf.PreTestTimeout = int64(*pretimeout / time.Second)
+	}
+	if *logformat != "" {
+		conf.LogFormat = *logformat
+	}
+	if *loglevel != "" {
+		conf.LogLevel = *loglevel
+	}
+	if *logbuffer > 0 {
+		conf.LogBufferSize = *logbuffer
+	}
+	if *logarchive != "" {
+		conf.LogArchive = *logarchive
+	}
+	if *slowthresh > 0 {
+		conf.SlowRequest = int64(*slowthresh / time.Millisecond)
+	}
+	if *posttimeout > 0 {
+		conf.PostTestTimeout = int64(*posttimeout / time.Second)
+	}
+	if *postusers > 0 {
+		conf.PostTestUsers = *postusers
+	}
+	if *postgrace > 0 {
+		conf.PostTestGrace = int64(*postgrace / time.Millisecond)
+	}
+	if *inittimeout > 0 {
+		conf.Client.InitTimeout = int64(*inittimeout / time.Millisecond)
+	}
+	if *initlimit > 0 {
+		conf.Client.InitLimit = int64(*initlimit / time.Millisecond)
+	}
+	if *timeout > 0 {
+		conf.Client.Timeout = int64(*timeout / time.Millisecond)
+	}
+	if *retire > 0 {
+		conf.Client.RetireTimeout = int64(*retire / time.Millisecond)
+	}
+	if *longpoll > 0 {
+		conf.Client.LongPollWait = int64(*longpoll / time.Millisecond)
+	}
+	if *maxinvestors > 0 {
+		conf.Investor.Max = *maxinvestors
+	}
+	if *bankfail > 0 {
+		conf.BankFault.FailRate = *bankfail
+	}
+	if *banktimeout > 0 {
+		conf.BankFault.TimeoutRate = *banktimeout
+	}
+	if *chaos && conf.Chaos == nil {
+		conf.Chaos = bench.DefaultChaosConfig()
+	}
+	// フラグで上書きした値も設定ファイルと同じ条件で確かめる
+	if err = conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func writeHTMLReport(path string, result portal.BenchResult, timeline []bench.Snapshot) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return bench.WriteHTMLReport(f, result, timeline)
+}
+
+func writeJUnit(path string, checks *bench.CheckRecorder) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return checks.WriteJUnit(f)
+}
+
+func writeTrace(path string, mgr *bench.Manager) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return mgr.WriteTrace(f)
+}
+
+func writeHAR(path string, mgr *bench.Manager) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return mgr.WriteHAR(f)
+}
+
+func writeWorkload(path string, mgr *bench.Manager) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return mgr.WriteWorkload(f)
+}
+
+// envDuration は環境変数を time.Duration として読む. 無いか読めなければ0
+func envDuration(name string) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// nodeID は結果に記録するベンチマーカーのノード名. -node が無ければホスト名
+func nodeID() string {
+	if *node != "" {
+		return *node
+	}
+	id, _ := os.Hostname()
+	return id
+}
+
+// percentValue は "1%" か "0.01" の形で割合を受け取る
+type percentValue float64
+
+func percentFlag(name, usage string) *float64 {
+	p := new(float64)
+	flag.Var((*percentValue)(p), name, usage)
+	return p
+}
+
+func (p *percentValue) String() string {
+	return strconv.FormatFloat(float64(*p)*100, 'g', -1, 64) + "%"
+}
+
+func (p *percentValue) Set(s string) error {
+	var scale float64 = 1
+	if strings.HasSuffix(s, "%") {
+		s, scale = strings.TrimSuffix(s, "%"), 100
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return errors.New("invalid percentage " + s)
+	}
+	*p = percentValue(v / scale)
 	return nil
 }
 
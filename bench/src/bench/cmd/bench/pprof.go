@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// servePprof はベンチ自身のプロファイルを取れるようにする
+// benchがボトルネックになっていないか調べる用
+func servePprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("[WARN] pprof listen %s failed. %s", addr, err)
+	}
+}
+
+// logRuntimeStats は interval ごとにgoroutine数とヒープの使用量をログに出す
+func logRuntimeStats(ctx context.Context, interval time.Duration) {
+	var ms runtime.MemStats
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		runtime.ReadMemStats(&ms)
+		log.Printf("[INFO] runtime goroutines:%d, heap:%dMB, heap objects:%d, gc:%d, gc pause:%s",
+			runtime.NumGoroutine(), ms.HeapAlloc>>20, ms.HeapObjects, ms.NumGC, time.Duration(ms.PauseTotalNs))
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"bench"
+	"bench/portal"
+	"github.com/pkg/errors"
+)
+
+// remoteServer は portal からベンチマークのジョブを受け取って実行する
+// SSH せずに複数のベンチマーカーへジョブを振り分けられるようにする
+// (coordinator と同じく gRPC の依存は入れたくないので、StartRun, AbortRun, GetStatus, StreamLogs を HTTP+JSON で受ける)
+// 負荷をかける先を指定できてしまうので、token を Authorization: Bearer で送ってきたものだけ受け付ける
+type remoteServer struct {
+	jobSlot
+}
+
+// jobSlot は実行中か最後に実行したジョブ. /healthz, /readyz はこれを見て idle か busy かを返す
+type jobSlot struct {
+	mu  sync.Mutex
+	run *remoteRun
+}
+
+func (s *jobSlot) current() *remoteRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.run
+}
+
+func (s *jobSlot) set(r *remoteRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.run = r
+}
+
+type remoteRun struct {
+	job    portal.Job
+	mgr    *bench.Manager
+	cancel context.CancelFunc
+	done   chan struct{} // 結果が出たらcloseされる
+	result *portal.BenchResult
+}
+
+// remoteStatus は GetStatus で返す状態. Result は終わったジョブのみ
+type remoteStatus struct {
+	Running  bool                `json:"running"`
+	JobID    int                 `json:"job_id,omitempty"`
+	Phase    string              `json:"phase"`
+	Snapshot *bench.Snapshot     `json:"snapshot,omitempty"`
+	Result   *portal.BenchResult `json:"result,omitempty"`
+}
+
+func serveRemote(addr, token string) error {
+	if token == "" {
+		return errors.New("-remote needs -remote-token or $BENCH_REMOTE_TOKEN")
+	}
+	s := &remoteServer{}
+	mux := http.NewServeMux()
+	mux.Handle("/StartRun", authorize(token, s.handleStartRun))
+	mux.Handle("/AbortRun", authorize(token, s.handleAbortRun))
+	mux.Handle("/GetStatus", authorize(token, s.handleGetStatus))
+	mux.Handle("/StreamLogs", authorize(token, s.handleStreamLogs))
+	// スケジューラーが問い合わせるので token はいらない. ジョブの中身は返さない
+	handleNodeHealth(mux, &s.jobSlot)
+	log.Printf("[INFO] remote control listen %s", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// authorize は Authorization: Bearer の token が一致したリクエストだけ h に渡す
+func authorize(token string, h http.HandlerFunc) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	})
+}
+
+func (s *remoteServer) status() remoteStatus {
+	r := s.current()
+	if r == nil {
+		return remoteStatus{Phase: bench.PhaseWaiting}
+	}
+	st := remoteStatus{JobID: r.job.ID, Phase: r.mgr.Phase()}
+	select {
+	case <-r.done:
+		st.Result = r.result
+	default:
+		st.Running = true
+		snapshot := r.mgr.Snapshot()
+		st.Snapshot = &snapshot
+	}
+	return st
+}
+
+func (s *remoteServer) writeStatus(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(s.status())
+}
+
+// start は job を実行し始める. 実行中のジョブがあればエラーにする
+func (s *remoteServer) start(job portal.Job) error {
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.run != nil {
+		select {
+		case <-s.run.done:
+		default:
+			return fmt.Errorf("job %d is running", s.run.job.ID)
+		}
+	}
+	r, ctx, err := newRemoteRun(context.Background(), job, conf)
+	if err != nil {
+		return err
+	}
+	s.run = r
+	go r.execute(ctx)
+	return nil
+}
+
+// newRemoteRun は job を実行する Manager を作る. 返した context は cancel で中断できる
+func newRemoteRun(ctx context.Context, job portal.Job, conf *bench.Config) (*remoteRun, context.Context, error) {
+	mgr, err := bench.NewManager(logout, job.TargetURL, job.BankURL, job.LogURL, job.InternalBankURL, job.InternalLogURL, *stateout, conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &remoteRun{job: job, mgr: mgr, cancel: cancel, done: make(chan struct{})}, ctx, nil
+}
+
+// running はまだ結果が出ていなければtrue
+func (r *remoteRun) running() bool {
+	select {
+	case <-r.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (r *remoteRun) execute(ctx context.Context) {
+	defer close(r.done)
+	defer r.cancel()
+	defer func() {
+		tctx, cancel := context.WithTimeout(context.Background(), bench.TeardownLimit)
+		defer cancel()
+		if err := r.mgr.Close(tctx); err != nil {
+			log.Printf("[WARN] %s", err)
+		}
+	}()
+	log.Printf("[INFO] start job %d (%s)", r.job.ID, r.job.TargetURL)
+	res, _ := r.mgr.Run(ctx)
+	result := res.BenchResult
+	result.JobID = strconv.Itoa(r.job.ID)
+	result.IPAddrs = r.job.TargetURL
+	result.BenchNode = nodeID()
+	if *signkey != "" {
+		if err := portal.SignResult(*signkey, &result); err != nil {
+			log.Printf("[WARN] sign result failed. %s", err)
+		}
+	}
+	r.result = &result
+	log.Printf("[INFO] finish job %d score:%d", r.job.ID, result.Score)
+}
+
+// handleStartRun は portal.Job を受け取ってベンチマークを始める
+// target_url がなければ target_ip から portal と同じ規則で各URLを決める
+func (s *remoteServer) handleStartRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var job portal.Job
+	if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+		http.Error(w, "invalid job: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if job.TargetURL == "" {
+		if err := job.Setup(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := s.start(job); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.writeStatus(w, http.StatusAccepted)
+}
+
+// handleAbortRun は実行中のジョブを中断する. 中断したジョブも結果は返す
+func (s *remoteServer) handleAbortRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	run := s.current()
+	if run == nil {
+		http.Error(w, "no job", http.StatusNotFound)
+		return
+	}
+	run.cancel()
+	// 結果が出るまで待ってから返す
+	select {
+	case <-run.done:
+	case <-r.Context().Done():
+		return
+	}
+	s.writeStatus(w, http.StatusOK)
+}
+
+func (s *remoteServer) handleGetStatus(w http.ResponseWriter, r *http.Request) {
+	s.writeStatus(w, http.StatusOK)
+}
+
+// handleStreamLogs は実行中か最後のジョブのログを1行ずつ送る. ジョブが終わるか切断されるまで続ける
+func (s *remoteServer) handleStreamLogs(w http.ResponseWriter, r *http.Request) {
+	run := s.current()
+	if run == nil {
+		http.Error(w, "no job", http.StatusNotFound)
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-run.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	flusher, _ := w.(http.Flusher)
+	run.mgr.FollowLogs(ctx, func(line string) error {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+}
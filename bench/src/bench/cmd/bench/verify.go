@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"bench/portal"
+)
+
+// runVerify は bench verify [-sign-key key] [result.json ...] で、結果のJSONの署名を確かめる
+// ファイルを指定しなければ標準入力を読む
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	key := fs.String("sign-key", os.Getenv("BENCH_SIGN_KEY"), "HMAC key the result json was signed with (default $BENCH_SIGN_KEY)")
+	fs.Parse(args)
+	if *key == "" {
+		return errors.New("-sign-key or $BENCH_SIGN_KEY is required")
+	}
+	if fs.NArg() == 0 {
+		return verifyResult(*key, "-", os.Stdin)
+	}
+	failed := false
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		if verifyResult(*key, path, f) != nil {
+			failed = true
+		}
+		f.Close()
+	}
+	if failed {
+		return errors.New("verify failed")
+	}
+	return nil
+}
+
+func verifyResult(key, name string, r io.Reader) error {
+	var result portal.BenchResult
+	err := json.NewDecoder(r).Decode(&result)
+	if err == nil {
+		err = portal.VerifyResult(key, &result)
+	}
+	if err != nil {
+		fmt.Printf("NG %s: %s\n", name, err)
+		return err
+	}
+	fmt.Printf("OK %s: job_id:%s, pass:%t, score:%d\n", name, result.JobID, result.Pass, result.Score)
+	return nil
+}
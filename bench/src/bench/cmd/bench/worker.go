@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"bench/portal"
+	"github.com/pkg/errors"
+)
+
+const (
+	workerJobMargin     = 30 * time.Second // 設定から見積もった実行時間に加えて1つのジョブにかけてよい時間
+	workerNoJobInterval = 5 * time.Second  // ジョブがなかったときに次に取りに行くまでの間隔
+	workerRetryInterval = 30 * time.Second // ジョブを取りに行けなかったときの間隔
+	workerPostRetry     = 3                // 結果を送る回数
+	workerPostInterval  = 10 * time.Second // 結果を送れなかったときに送り直すまでの間隔
+)
+
+var errNoJob = errors.New("no job")
+
+// jobQueue は portal の /bench/job からジョブを受け取り /bench/job/result に結果を返す
+// bench-worker と同じやりとりをするので portal 側はどちらのワーカーか気にしなくてよい
+type jobQueue struct {
+	endpoint string
+	hostname string
+}
+
+func (q *jobQueue) url(path string, query url.Values) string {
+	u := q.endpoint + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+func (q *jobQueue) get(ctx context.Context) (*portal.Job, error) {
+	req, err := http.NewRequest("GET", q.url("/bench/job", url.Values{"hostname": {q.hostname}}), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "http.NewRequest failed")
+	}
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "get job failed")
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNoContent {
+		return nil, errNoJob
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("get job failed. status code: %d", res.StatusCode)
+	}
+	job := &portal.Job{}
+	if err = json.NewDecoder(res.Body).Decode(job); err != nil {
+		return nil, errors.Wrap(err, "job decode failed")
+	}
+	if job.TargetURL == "" {
+		if err = job.Setup(); err != nil {
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+// post は結果のjsonとログを返す. result が nil ならジョブを実行できなかったことにする
+func (q *jobQueue) post(ctx context.Context, job *portal.Job, result *portal.BenchResult) error {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	query := url.Values{"job_id": {strconv.Itoa(job.ID)}}
+	if result != nil {
+		part, _ := writer.CreateFormFile("result", "result.json")
+		if err := json.NewEncoder(part).Encode(result); err != nil {
+			return errors.Wrap(err, "result encode failed")
+		}
+		part, _ = writer.CreateFormFile("log", "bench.log")
+		part.Write([]byte(strings.Join(result.Logs, "\n")))
+	} else {
+		query.Set("aborted", "yes")
+	}
+	writer.Close()
+
+	req, err := http.NewRequest("POST", q.url("/bench/job/result", query), body)
+	if err != nil {
+		return errors.Wrap(err, "http.NewRequest failed")
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "post result failed")
+	}
+	defer res.Body.Close()
+	b, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode >= 400 {
+		return errors.Errorf("post result failed. status code: %d, body: %s", res.StatusCode, string(b))
+	}
+	return nil
+}
+
+// runWorker は portal からジョブを取り、Initialize から PostTest までこのプロセスで実行して結果を返すことを繰り返す
+// ctx が終わると実行中のジョブを中断し、その結果を返してから終わる
+// listen を指定すると、次のジョブを受けられるかを /healthz, /readyz で返す
+func runWorker(ctx context.Context, endpoint, listen string) error {
+	q := &jobQueue{endpoint: strings.TrimSuffix(endpoint, "/"), hostname: nodeID()}
+	slot := &jobSlot{}
+	if listen != "" {
+		mux := http.NewServeMux()
+		handleNodeHealth(mux, slot)
+		go func() {
+			if err := http.ListenAndServe(listen, mux); err != nil {
+				log.Printf("[WARN] listen %s failed. %s", listen, err)
+			}
+		}()
+	}
+	log.Printf("[INFO] worker start. portal: %s, hostname: %s", q.endpoint, q.hostname)
+	for {
+		job, err := q.get(ctx)
+		if err != nil {
+			wait := workerRetryInterval
+			if err == errNoJob {
+				wait = workerNoJobInterval
+			} else {
+				log.Printf("[WARN] %s", err)
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(wait):
+			}
+			continue
+		}
+		result := runJob(ctx, job, slot)
+		for try := 0; try < workerPostRetry; try++ {
+			// 中断されていても結果は返す
+			if err = q.post(context.Background(), job, result); err == nil {
+				log.Printf("[INFO] job %d result posted", job.ID)
+				break
+			}
+			log.Printf("[WARN] %s, try: %d", err, try)
+			if try == workerPostRetry-1 {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				log.Printf("[WARN] job %d result not posted", job.ID)
+				return nil
+			case <-time.After(workerPostInterval):
+			}
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// runJob は job を実行して結果を返す. Manager を作れなければ nil
+func runJob(ctx context.Context, job *portal.Job, slot *jobSlot) *portal.BenchResult {
+	conf, err := loadConfig()
+	if err != nil {
+		log.Printf("[WARN] job %d load config failed. %s", job.ID, err)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, conf.RunLimit()+workerJobMargin)
+	defer cancel()
+	r, ctx, err := newRemoteRun(ctx, *job, conf)
+	if err != nil {
+		log.Printf("[WARN] job %d start failed. %s", job.ID, err)
+		return nil
+	}
+	slot.set(r)
+	r.execute(ctx)
+	return r.result
+}
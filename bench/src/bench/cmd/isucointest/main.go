@@ -32,11 +32,11 @@ func main() {
 
 func run() error {
 	ctx := context.Background()
-	mgr, err := bench.NewManager(os.Stderr, *appep, *bankep, *logep, *internalbank, *internallog, "")
+	mgr, err := bench.NewManager(os.Stderr, *appep, *bankep, *logep, *internalbank, *internallog, "", nil)
 	if err != nil {
 		return err
 	}
-	defer mgr.Close()
+	defer mgr.Close(ctx)
 	log.Printf("run initialize")
 	if err = mgr.Initialize(ctx); err != nil {
 		return errors.Wrap(err, "Initialize Failed")
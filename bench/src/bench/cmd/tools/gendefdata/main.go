@@ -263,7 +263,7 @@ func run(dir, starts, ends string) error {
 		return err
 	}
 
-	r, err := bench.NewRandom()
+	r, err := bench.NewRandom(0)
 	if err != nil {
 		return err
 	}
@@ -303,7 +303,7 @@ func run(dir, starts, ends string) error {
 	defer cancel()
 
 	for i := 0; i <= 50; i++ {
-		go func() {
+		go func(u bench.UserRand) {
 			for {
 				select {
 				case <-ctx.Done():
@@ -313,17 +313,17 @@ func run(dir, starts, ends string) error {
 					if cost < 4 {
 						cost = 4
 					}
-					pass := r.Password()
+					pass := u.Password()
 					ep, _ := bcrypt.GenerateFromPassword([]byte(pass), cost)
 					uchan <- User{
-						Name:     r.Name(),
+						Name:     u.Name(),
 						BankID:   r.ID(),
 						Password: pass,
 						pass:     string(ep),
 					}
 				}
 			}
-		}()
+		}(r.User(int64(i)))
 	}
 
 	for i := 1; i <= 100; i++ {
@@ -0,0 +1,662 @@
+package bench
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+)
+
+// Config はコンパイルし直さずに調整したいベンチマークのパラメータ
+// 指定しなかった項目は const.go の値のままになる
+type Config struct {
+	Seed   int64        `json:"seed"` // 0ならランダム
+	Score  ScoreConfig  `json:"score"`
+	Error  ErrorConfig  `json:"error"`
+	Client ClientConfig `json:"client"`
+
+	Duration        int64 `json:"duration"`         // 負荷走行の時間(秒)
+	PreTestTimeout  int64 `json:"pretest_timeout"`  // 事前テストのタイムアウト(秒). 0なら制限しない
+	PostTestGrace   int64 `json:"posttest_grace"`   // 負荷走行が終わってから事後テストを始めるまで待つ時間(ms)
+	PostTestTimeout int64 `json:"posttest_timeout"` // 事後テストのタイムアウト(秒). 0なら制限しない
+	PostTestUsers   int   `json:"posttest_users"`   // 事後テストで残高とログを確かめるユーザー数
+	PostTestWorkers int   `json:"posttest_workers"` // 事後テストで同時に確かめるユーザー数
+	WarmUp          int64 `json:"warm_up"`          // 負荷走行の最初にスコアとエラーを数えない時間(秒). 負荷走行はこの分延びる
+
+	Investor InvestorConfig `json:"investor"`
+
+	// アプリが成約をWebSocketで配信している場合のpath. 空なら検証しない
+	TradeStreamPath string `json:"trade_stream_path"`
+
+	// 負荷走行の進め方. nilなら score に応じてlevelupする
+	Plan *Plan `json:"plan"`
+	// Plan が無いときに使う ProfilePlan の名前
+	Profile string `json:"profile"`
+
+	// スコアの計算方法. RegisterScoreModel で登録した名前で、空なら DefaultScoreModel
+	ScoreModel string `json:"score_model"`
+
+	// Plan が無いときに score に応じてlevelupする条件
+	Level LevelConfig `json:"level"`
+	// 負荷走行のユーザーが退役する条件
+	Retire RetireConfig `json:"retire"`
+	// SNSシェアでユーザーが増える条件
+	Share ShareConfig `json:"share"`
+
+	// 事後テストでisulogへの反映の遅延を何秒まで許すか
+	LogAllowedDelay int64 `json:"log_allowed_delay"`
+
+	// これより時間がかかったリクエストを遅い順に最後に表示する(ms). 0なら記録しない
+	SlowRequest int64 `json:"slow_request"`
+
+	// ログの形式 (text, json) とレベル (debug, info, warn, error). 空なら text, info
+	LogFormat string `json:"log_format"`
+	LogLevel  string `json:"log_level"`
+	// 結果に含めるログを残しておく最大のバイト数. 古い行から捨てる
+	// LogArchive を指定すると捨てる行も含めて全部そのファイルに書く
+	LogBufferSize int    `json:"log_buffer_size"`
+	LogArchive    string `json:"log_archive"`
+
+	// 負荷走行中に isubank の reserve, commit に注入する障害
+	BankFault BankFaultConfig `json:"bank_fault"`
+	// 負荷走行中にisubank, isulogを一時的に遅く/使えなくする. nilなら何もしない
+	Chaos *ChaosConfig `json:"chaos"`
+}
+
+// BankFaultConfig は isubank に注入する障害の設定. 割合がどちらも0なら注入しない
+type BankFaultConfig struct {
+	FailRate    int   `json:"fail_rate"`    // エラーを返させる割合(%)
+	TimeoutRate int   `json:"timeout_rate"` // 応答せずに接続を切らせる割合(%)
+	Timeout     int64 `json:"timeout"`      // 接続を切るまでの時間(ms)
+}
+
+func (bf BankFaultConfig) Enabled() bool {
+	return bf.FailRate > 0 || bf.TimeoutRate > 0
+}
+
+// ChaosConfig は外部サービスに障害を起こす間隔と長さ
+type ChaosConfig struct {
+	Interval int64 `json:"interval"` // 秒
+	Window   int64 `json:"window"`   // 秒
+	Latency  int64 `json:"latency"`  // 遅くするときに追加する遅延(ms)
+	Recovery int64 `json:"recovery"` // 負荷走行の最後に障害を起こさない時間(秒)
+}
+
+func DefaultChaosConfig() *ChaosConfig {
+	return &ChaosConfig{
+		Interval: int64(ChaosInterval / time.Second),
+		Window:   int64(ChaosWindow / time.Second),
+		Latency:  int64(ChaosLatency / time.Millisecond),
+		Recovery: int64(ChaosRecovery / time.Second),
+	}
+}
+
+// ClientConfig は負荷走行とテストで使うClientのtransportの設定
+type ClientConfig struct {
+	// TLSのALPNでHTTP/2を使う. h2c (平文のHTTP/2) は x/net/http2 が必要なので対応していない
+	DisableHTTP2 bool `json:"disable_http2"`
+
+	CAFile             string `json:"ca_file"`   // アプリの証明書を検証するCA bundle (PEM)
+	CertFile           string `json:"cert_file"` // クライアント証明書 (PEM)
+	KeyFile            string `json:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+
+	// コネクションプールの設定. 0 なら net/http のデフォルト
+	MaxIdleConnsPerHost int  `json:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int  `json:"max_conns_per_host"`
+	IdleConnTimeout     int  `json:"idle_conn_timeout"` // 秒
+	DisableKeepAlives   bool `json:"disable_keep_alives"`
+
+	// GETのリトライ. RetryBudget はユーザーごとにリトライできる回数, 待ち時間はms
+	RetryBudget   int   `json:"retry_budget"`
+	RetryDelayMin int64 `json:"retry_delay_min"`
+	RetryDelayMax int64 `json:"retry_delay_max"`
+
+	// ベンチ全体で1秒あたりに送るリクエスト数の上限. 0 なら制限しない
+	MaxRPS int `json:"max_rps"`
+
+	// タイムアウト (ms). InitTimeout は Initialize, RetireTimeout はユーザーが退役するまでの時間
+	// InitLimit は Initialize にかけてよい時間で、これを超えると失格にする
+	InitTimeout   int64 `json:"init_timeout"`
+	InitLimit     int64 `json:"init_limit"`
+	Timeout       int64 `json:"timeout"`
+	RetireTimeout int64 `json:"retire_timeout"`
+
+	// GET /info をアプリに待たせる最長の時間 (ms). 0 なら long polling しない
+	// Prefer: wait を送り、Preference-Applied が返らなければそのユーザーはポーリングに戻る
+	LongPollWait int64 `json:"long_poll_wait"`
+
+	tls *tls.Config
+}
+
+func (cc ClientConfig) initTimeout() time.Duration {
+	return time.Duration(cc.InitTimeout) * time.Millisecond
+}
+
+func (cc ClientConfig) initLimit() time.Duration {
+	return time.Duration(cc.InitLimit) * time.Millisecond
+}
+
+func (cc ClientConfig) timeout() time.Duration {
+	return time.Duration(cc.Timeout) * time.Millisecond
+}
+
+func (cc ClientConfig) retireTimeout() time.Duration {
+	return time.Duration(cc.RetireTimeout) * time.Millisecond
+}
+
+func (cc ClientConfig) longPollWait() time.Duration {
+	return time.Duration(cc.LongPollWait) * time.Millisecond
+}
+
+// prepare はファイルを読んでTLSの設定を作る. 何も指定されていなければnilのまま
+func (cc *ClientConfig) prepare() error {
+	if cc.CAFile == "" && cc.CertFile == "" && !cc.InsecureSkipVerify {
+		return nil
+	}
+	conf := &tls.Config{InsecureSkipVerify: cc.InsecureSkipVerify}
+	if cc.CAFile != "" {
+		pem, err := ioutil.ReadFile(cc.CAFile)
+		if err != nil {
+			return errors.Wrap(err, "CA file read failed")
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return errors.Errorf("no certificate found in CA file %s", cc.CAFile)
+		}
+	}
+	if cc.CertFile != "" || cc.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cc.CertFile, cc.KeyFile)
+		if err != nil {
+			return errors.Wrap(err, "client certificate load failed")
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	cc.tls = conf
+	return nil
+}
+
+// InvestorConfig は負荷走行で使うユーザーの振る舞いの設定
+type InvestorConfig struct {
+	MarketMakerSpread int64 `json:"market_maker_spread"`
+	ScalperRate       int   `json:"scalper_rate"` // 1秒あたりの注文数
+	ScalperBurst      int   `json:"scalper_burst"`
+
+	PanicSellDropPercent int64 `json:"panic_sell_drop_percent"`
+	PanicSellWindow      int64 `json:"panic_sell_window"` // 秒
+
+	// 総当たりログインで試すパスワードと対象のbank_idのファイル (1行に1つ)
+	// 指定しなければ password000 ~ password999 を既存ユーザーに試す
+	BruteForcePasswordFile string `json:"brute_force_password_file"`
+	BruteForceAccountFile  string `json:"brute_force_account_file"`
+	BruteForceDelayMin     int64  `json:"brute_force_delay_min"` // ミリ秒
+	BruteForceDelayMax     int64  `json:"brute_force_delay_max"`
+
+	// この回数続けてログインに失敗したら403でロックしてよい (仕様では5回)
+	// require_lockout なら、その倍失敗してもロックされなければエラーにする
+	BruteForceLockoutAfter   int  `json:"brute_force_lockout_after"`
+	BruteForceRequireLockout bool `json:"brute_force_require_lockout"`
+
+	// 新規ユーザーの種類ごとの割合. キーは RegisterInvestor で登録した名前で、0にすると使わない
+	// 標準は normal だけ. market_maker, scalper, panic_seller, chart_watcher は設定ファイルで重みをつけると加わる
+	Mix map[string]int `json:"mix"`
+
+	// 同時に動かすユーザー数の上限. 0なら制限しない
+	Max int `json:"max"`
+
+	passwords []string
+	accounts  []string
+}
+
+func (ic *InvestorConfig) prepare() error {
+	var err error
+	if ic.BruteForcePasswordFile != "" {
+		if ic.passwords, err = readLines(ic.BruteForcePasswordFile); err != nil {
+			return errors.Wrap(err, "brute force password file read failed")
+		}
+	}
+	if ic.BruteForceAccountFile != "" {
+		if ic.accounts, err = readLines(ic.BruteForceAccountFile); err != nil {
+			return errors.Wrap(err, "brute force account file read failed")
+		}
+	}
+	return nil
+}
+
+// readLines は空行と # で始まる行を除いた行を返す
+func readLines(path string) ([]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{}
+	for _, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		lines = append(lines, l)
+	}
+	return lines, nil
+}
+
+// LevelConfig はlevelupに必要なスコアとエラーでlevelupを止める条件
+type LevelConfig struct {
+	// exponential なら base * growth^level, linear なら base + step * level のスコアでlevelupする
+	Curve  string  `json:"curve"`
+	Base   int64   `json:"base"`
+	Growth float64 `json:"growth"`
+	Step   int64   `json:"step"`
+	Max    uint    `json:"max"` // 0 なら上限なし
+
+	// total ならエラー数の合計が, per_level なら前のlevelupからのエラー数が
+	// freeze_errors を超えている間はlevelupしない. none ならエラーではlevelupを止めない
+	// freeze_errors が負なら error.allow_min を使う
+	Freeze       string `json:"freeze"`
+	FreezeErrors int    `json:"freeze_errors"`
+}
+
+// threshold は level から次のlevelに上がるのに必要なスコア
+func (lc LevelConfig) threshold(level uint) int64 {
+	if lc.Curve == "linear" {
+		return lc.Base + lc.Step*int64(level)
+	}
+	return int64(float64(lc.Base) * math.Pow(lc.Growth, float64(level)))
+}
+
+func (lc LevelConfig) validate() error {
+	switch lc.Curve {
+	case "exponential":
+		if lc.Growth < 1 {
+			return errors.Errorf("config level.growth must be greater than or equal to 1")
+		}
+	case "linear":
+		if lc.Step < 0 {
+			return errors.Errorf("config level.step must not be negative")
+		}
+	default:
+		return errors.Errorf("config level.curve must be exponential or linear")
+	}
+	if lc.Base < 1 {
+		return errors.Errorf("config level.base must be positive")
+	}
+	switch lc.Freeze {
+	case "total", "per_level", "none":
+	default:
+		return errors.Errorf("config level.freeze must be total, per_level or none")
+	}
+	return nil
+}
+
+// RetireConfig は負荷走行のユーザーが退役する条件. 退役するまでの時間は client.retire_timeout から
+// levelが1上がるごとに level_step(%) ずつ縮め、min_timeout (ms) より短くはしない
+// policy が request なら1回のリクエストがその時間を超えたら、
+// p95 なら直近 window 回のリクエストの p95 が超えたら退役する
+// max_errors 回続けてリクエストに失敗しても退役する. 0 なら失敗では退役しない
+type RetireConfig struct {
+	LevelStep  int    `json:"level_step"`
+	MinTimeout int64  `json:"min_timeout"`
+	Policy     string `json:"policy"`
+	Window     int    `json:"window"`
+	MaxErrors  int    `json:"max_errors"`
+}
+
+func (rc RetireConfig) validate(cc ClientConfig) error {
+	if rc.LevelStep < 0 || rc.LevelStep > 100 {
+		return errors.Errorf("config retire.level_step is out of range")
+	}
+	if rc.MinTimeout < 0 || rc.MaxErrors < 0 {
+		return errors.Errorf("config retire.min_timeout and retire.max_errors must not be negative")
+	}
+	if rc.LevelStep > 0 && rc.MinTimeout <= cc.LongPollWait {
+		return errors.Errorf("config retire.min_timeout must be longer than client.long_poll_wait")
+	}
+	switch rc.Policy {
+	case "request":
+	case "p95":
+		if rc.Window < 1 {
+			return errors.Errorf("config retire.window must be positive")
+		}
+	default:
+		return errors.Errorf("config retire.policy must be request or p95")
+	}
+	return nil
+}
+
+// ShareConfig はSNSシェアでユーザーが増える条件
+// アプリが enable_share を返したユーザーの注文が成約すると probability(%) の確率でシェアされて add_users 人増える
+// 前にユーザーが増えてから interval (ms) の間はシェアされても増やさない. 0 ならシェアのたびに増やす
+type ShareConfig struct {
+	AddUsers    int   `json:"add_users"`
+	Probability int   `json:"probability"`
+	Interval    int64 `json:"interval"`
+}
+
+func (sc ShareConfig) validate() error {
+	if sc.AddUsers < 0 || sc.Interval < 0 {
+		return errors.Errorf("config share.add_users and share.interval must not be negative")
+	}
+	if sc.Probability < 0 || sc.Probability > 100 {
+		return errors.Errorf("config share.probability is out of range")
+	}
+	return nil
+}
+
+type ScoreConfig struct {
+	Signup       int64 `json:"signup"`
+	Signin       int64 `json:"signin"`
+	PostOrders   int64 `json:"post_orders"`
+	GetOrders    int64 `json:"get_orders"`
+	DeleteOrders int64 `json:"delete_orders"`
+	TradeSuccess int64 `json:"trade_success"`
+	GetInfo      int64 `json:"get_info"`
+	GetTop       int64 `json:"get_top"`
+	TradePush    int64 `json:"trade_push"`
+	NotModified  int64 `json:"not_modified"`
+	Lockout      int64 `json:"lockout"`
+	Campaign     int64 `json:"campaign"`
+
+	// 半数以上のリクエストがHTTP/2で処理されたときにスコアを何%増やすか
+	HTTP2BonusPercent int64 `json:"http2_bonus_percent"`
+	// 半数以上のレスポンスがgzipで圧縮されていたときにスコアを何%増やすか
+	GzipBonusPercent int64 `json:"gzip_bonus_percent"`
+	// 半数以上のログがsend_bulkで送られていたときにスコアを何%増やすか
+	BulkLogBonusPercent int64 `json:"bulk_log_bonus_percent"`
+}
+
+func (sc ScoreConfig) Of(st ScoreType) int64 {
+	switch st {
+	case ScoreTypeGetTop:
+		return sc.GetTop
+	case ScoreTypeSignup:
+		return sc.Signup
+	case ScoreTypeSignin:
+		return sc.Signin
+	case ScoreTypeGetInfo:
+		return sc.GetInfo
+	case ScoreTypeGetOrders:
+		return sc.GetOrders
+	case ScoreTypePostOrders:
+		return sc.PostOrders
+	case ScoreTypeDeleteOrders:
+		return sc.DeleteOrders
+	case ScoreTypeTradeSuccess:
+		return sc.TradeSuccess
+	case ScoreTypeTradePush:
+		return sc.TradePush
+	case ScoreTypeNotModified:
+		return sc.NotModified
+	case ScoreTypeLockout:
+		return sc.Lockout
+	case ScoreTypeCampaign:
+		return sc.Campaign
+	default:
+		return st.Score()
+	}
+}
+
+type ErrorConfig struct {
+	AllowMin       int   `json:"allow_min"`       // levelによらずここまでは許容範囲というエラー数
+	AllowMax       int   `json:"allow_max"`       // levelによらずこれ以上は許さないというエラー数
+	LimitDivisor   int64 `json:"limit_divisor"`   // スコアをこの値で割ったものがエラー件数の上限になる
+	DemeritDivisor int64 `json:"demerit_divisor"` // エラー1件あたりの減点はスコアをこの値で割ったもの
+	Penalty        int64 `json:"penalty"`         // score_model が linear のときのエラー1件あたりの減点
+
+	// 種類ごとのエラー件数の上限. キーは ErrorCategory.String()
+	CategoryMax map[string]int `json:"category_max"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Duration:        int64(BenchMarkTime / time.Second),
+		PostTestGrace:   int64(PostTestGrace / time.Millisecond),
+		PostTestTimeout: int64(PostTestLimit / time.Second),
+		PostTestUsers:   PostTestUsers,
+		PostTestWorkers: PostTestWorkers,
+		Score: ScoreConfig{
+			Signup:       SignupScore,
+			Signin:       SigninScore,
+			PostOrders:   PostOrdersScore,
+			GetOrders:    GetOrdersScore,
+			DeleteOrders: DeleteOrdersScore,
+			TradeSuccess: TradeSuccessScore,
+			GetInfo:      GetInfoScore,
+			GetTop:       GetTopScore,
+			TradePush:    TradePushScore,
+			NotModified:  NotModifiedScore,
+			Lockout:      LockoutScore,
+			Campaign:     CampaignScore,
+
+			GzipBonusPercent: GzipBonusPercent,
+		},
+		LogAllowedDelay: int64(LogAllowedDelay / time.Second),
+		SlowRequest:     int64(SlowRequest / time.Millisecond),
+		LogBufferSize:   LogBufferSize,
+		Level: LevelConfig{
+			Curve:        "exponential",
+			Base:         LevelUpBaseScore,
+			Growth:       2,
+			Freeze:       "total",
+			FreezeErrors: -1,
+		},
+		Retire: RetireConfig{
+			LevelStep:  RetireLevelStep,
+			MinTimeout: int64(RetireMinTimeout / time.Millisecond),
+			Policy:     "request",
+			Window:     RetireWindow,
+			MaxErrors:  RetireMaxErrors,
+		},
+		Share: ShareConfig{
+			AddUsers:    AddUsersOnShare,
+			Probability: SharePercent,
+		},
+		BankFault: BankFaultConfig{
+			Timeout: int64(BankFaultTimeout / time.Millisecond),
+		},
+		Client: ClientConfig{
+			RetryBudget:   RetryBudget,
+			RetryDelayMin: int64(RetryInterval / time.Millisecond / 5),
+			RetryDelayMax: int64(RetryInterval * 4 / time.Millisecond),
+
+			InitTimeout:   int64(InitTimeout / time.Millisecond),
+			InitLimit:     int64(InitLimit / time.Millisecond),
+			Timeout:       int64(ClientTimeout / time.Millisecond),
+			RetireTimeout: int64(RetireTimeout / time.Millisecond),
+		},
+		Investor: InvestorConfig{
+			MarketMakerSpread: MarketMakerSpread,
+			ScalperRate:       ScalperRate,
+			ScalperBurst:      ScalperBurst,
+
+			PanicSellDropPercent: PanicSellDropPercent,
+			PanicSellWindow:      PanicSellWindow,
+
+			BruteForceDelayMin: int64(BruteForceDelay / time.Millisecond),
+			BruteForceDelayMax: int64(BruteForceDelay / time.Millisecond),
+
+			BruteForceLockoutAfter: BruteForceLockoutAfter,
+
+			Mix: map[string]int{"normal": 1},
+		},
+		Error: ErrorConfig{
+			AllowMin:       AllowErrorMin,
+			AllowMax:       AllowErrorMax,
+			LimitDivisor:   500,
+			DemeritDivisor: AllowErrorMax * 2,
+			Penalty:        ErrorPenalty,
+			CategoryMax: map[string]int{
+				ErrorCategoryValidation.String(): AllowErrorMax,
+				ErrorCategoryTimeout.String():    AllowErrorMax,
+				ErrorCategoryStatus.String():     AllowErrorMax,
+				ErrorCategoryBank.String():       AllowErrorMax,
+				ErrorCategorySecurity.String():   1,
+			},
+		},
+	}
+}
+
+// LoadConfig は設定ファイル (TOMLかJSON) を読み込む. pathが空ならデフォルト値を返す
+func LoadConfig(path string) (*Config, error) {
+	conf := DefaultConfig()
+	if path == "" {
+		return conf, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "config file open failed")
+	}
+	defer f.Close()
+	if err = decodeFile(f, conf); err != nil {
+		return nil, errors.Wrap(err, "config file decode failed")
+	}
+	if err = conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// decodeFile は拡張子が .toml ならTOML, それ以外はJSONとして読む
+// TOMLはいったんJSONに直してから読むので, キーはJSONと同じ名前で書く
+func decodeFile(f *os.File, v interface{}) error {
+	if !strings.EqualFold(filepath.Ext(f.Name()), ".toml") {
+		return json.NewDecoder(f).Decode(v)
+	}
+	m := map[string]interface{}{}
+	if _, err := toml.DecodeReader(f, &m); err != nil {
+		return err
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// benchmarkTime は負荷走行の時間
+func (c *Config) benchmarkTime() time.Duration {
+	return time.Duration(c.Duration) * time.Second
+}
+
+func (c *Config) warmUp() time.Duration {
+	return time.Duration(c.WarmUp) * time.Second
+}
+
+// RunLimit は Initialize から後始末まで1回の実行にかかる時間の上限
+// pretest_timeout, posttest_timeout が 0 (制限しない) のときは PreTestLimit, PostTestLimit で見積もる
+func (c *Config) RunLimit() time.Duration {
+	pre, post := PreTestLimit, PostTestLimit
+	if c.PreTestTimeout > 0 {
+		pre = time.Duration(c.PreTestTimeout) * time.Second
+	}
+	if c.PostTestTimeout > 0 {
+		post = time.Duration(c.PostTestTimeout) * time.Second
+	}
+	grace := time.Duration(c.PostTestGrace) * time.Millisecond
+	return c.Client.initTimeout() + pre + c.warmUp() + c.benchmarkTime() + grace + post + TeardownLimit
+}
+
+// Validate は設定の値が範囲に収まっているかを確かめる
+// 読み込んだ後にフラグなどで上書きしたときは、上書きし終わってからもう一度呼ぶ
+func (c *Config) Validate() error {
+	if c.Duration < 1 || c.PreTestTimeout < 0 || c.PostTestGrace < 0 || c.PostTestTimeout < 0 || c.WarmUp < 0 {
+		return errors.Errorf("config duration must be positive")
+	}
+	if c.PostTestUsers < 1 || c.PostTestWorkers < 1 {
+		return errors.Errorf("config posttest_users and posttest_workers must be positive")
+	}
+	if c.SlowRequest < 0 {
+		return errors.Errorf("config slow_request must not be negative")
+	}
+	if c.LogFormat != "" && c.LogFormat != LogFormatText && c.LogFormat != LogFormatJSON {
+		return errors.Errorf("config log_format must be text or json")
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		return errors.Wrap(err, "config log_level is invalid")
+	}
+	if c.LogBufferSize < 1 {
+		return errors.Errorf("config log_buffer_size must be positive")
+	}
+	if c.Error.AllowMin > c.Error.AllowMax {
+		return errors.Errorf("config error.allow_min must be less than error.allow_max")
+	}
+	if c.Investor.MarketMakerSpread < 1 {
+		return errors.Errorf("config investor.market_maker_spread must be positive")
+	}
+	if c.Investor.ScalperRate < 1 || c.Investor.ScalperBurst < 1 {
+		return errors.Errorf("config investor.scalper_* must be positive")
+	}
+	if c.Investor.PanicSellDropPercent < 1 || c.Investor.PanicSellDropPercent > 100 || c.Investor.PanicSellWindow < 1 {
+		return errors.Errorf("config investor.panic_sell_* is out of range")
+	}
+	if c.Investor.BruteForceDelayMin < 1 || c.Investor.BruteForceDelayMax < c.Investor.BruteForceDelayMin {
+		return errors.Errorf("config investor.brute_force_delay_max must be greater than brute_force_delay_min")
+	}
+	if c.Client.MaxIdleConnsPerHost < 0 || c.Client.MaxConnsPerHost < 0 || c.Client.IdleConnTimeout < 0 {
+		return errors.Errorf("config client.max_*_per_host and client.idle_conn_timeout must not be negative")
+	}
+	if c.Client.InitTimeout < 1 || c.Client.Timeout < 1 || c.Client.RetireTimeout < 1 {
+		return errors.Errorf("config client.*timeout must be positive")
+	}
+	if c.Client.InitLimit < 1 {
+		return errors.Errorf("config client.init_limit must be positive")
+	}
+	if c.Client.LongPollWait < 0 || c.Client.LongPollWait >= c.Client.Timeout || c.Client.LongPollWait >= c.Client.RetireTimeout {
+		return errors.Errorf("config client.long_poll_wait must be shorter than client.timeout and client.retire_timeout")
+	}
+	if c.Client.MaxRPS < 0 {
+		return errors.Errorf("config client.max_rps must not be negative")
+	}
+	if c.Client.RetryBudget < 0 || c.Client.RetryDelayMin < 1 || c.Client.RetryDelayMax < c.Client.RetryDelayMin {
+		return errors.Errorf("config client.retry_delay_max must be greater than client.retry_delay_min")
+	}
+	if bf := c.BankFault; bf.FailRate < 0 || bf.TimeoutRate < 0 || bf.FailRate+bf.TimeoutRate > 100 || bf.Timeout < 0 {
+		return errors.Errorf("config bank_fault.*_rate is out of range")
+	}
+	if cc := c.Chaos; cc != nil && (cc.Window < 1 || cc.Interval <= cc.Window || cc.Latency < 0 || cc.Recovery < 0) {
+		return errors.Errorf("config chaos.interval must be greater than chaos.window")
+	}
+	if c.LogAllowedDelay < 1 {
+		return errors.Errorf("config log_allowed_delay must be positive")
+	}
+	if err := c.Level.validate(); err != nil {
+		return err
+	}
+	if err := c.Retire.validate(c.Client); err != nil {
+		return err
+	}
+	if err := c.Share.validate(); err != nil {
+		return err
+	}
+	if c.Investor.BruteForceLockoutAfter < 1 {
+		return errors.Errorf("config investor.brute_force_lockout_after must be positive")
+	}
+	if c.Investor.Max < 0 {
+		return errors.Errorf("config investor.max must not be negative")
+	}
+	if err := validateInvestorMix(c.Investor.Mix); err != nil {
+		return err
+	}
+	if c.Plan != nil {
+		if err := c.Plan.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Error.LimitDivisor <= 0 || c.Error.DemeritDivisor <= 0 {
+		return errors.Errorf("config error.*_divisor must be positive")
+	}
+	if c.Error.Penalty < 0 {
+		return errors.Errorf("config error.penalty must not be negative")
+	}
+	if _, err := lookupScoreModel(c.ScoreModel); err != nil {
+		return errors.Wrap(err, "config score_model")
+	}
+	return nil
+}
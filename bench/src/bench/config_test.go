@@ -0,0 +1,79 @@
+package bench
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, name, body string) string {
+	dir, err := ioutil.TempDir("", "bench")
+	if err != nil {
+		t.Fatalf("TempDir failed: %s", err)
+	}
+	path := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %s", err)
+	}
+	return path
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	path := writeTempFile(t, "config.toml", `
+duration = 30
+posttest_users = 5
+
+[investor]
+mix = { normal = 2, scalper = 1 }
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	conf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %s", err)
+	}
+	if conf.Duration != 30 || conf.PostTestUsers != 5 {
+		t.Errorf("unexpected config: duration:%d posttest_users:%d", conf.Duration, conf.PostTestUsers)
+	}
+	if conf.Investor.Mix["normal"] != 2 || conf.Investor.Mix["scalper"] != 1 {
+		t.Errorf("unexpected investor.mix: %v", conf.Investor.Mix)
+	}
+	// 書いていない値はデフォルトのまま
+	if expected := DefaultConfig().PostTestWorkers; conf.PostTestWorkers != expected {
+		t.Errorf("unexpected posttest_workers: got:%d expected:%d", conf.PostTestWorkers, expected)
+	}
+}
+
+func TestLoadPlanTOML(t *testing.T) {
+	path := writeTempFile(t, "plan.toml", `
+[[phases]]
+name = "warmup"
+duration = 10
+add_users = 5
+
+[[phases]]
+name = "peak"
+duration = 30
+every = 2
+every_users = 3
+mix = { scalper = 1 }
+trigger = { score = 1000 }
+
+[[phases]]
+name = "cooldown"
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	plan, err := LoadPlan(path)
+	if err != nil {
+		t.Fatalf("LoadPlan failed: %s", err)
+	}
+	if len(plan.Phases) != 3 {
+		t.Fatalf("unexpected phases: %v", plan.Phases)
+	}
+	peak := plan.Phases[1]
+	if peak.Name != "peak" || peak.Every != 2 || peak.EveryUsers != 3 || peak.Mix["scalper"] != 1 || peak.Trigger.Score != 1000 {
+		t.Errorf("unexpected peak phase: %+v", peak)
+	}
+}
@@ -5,24 +5,84 @@ import "time"
 const (
 	// Timeouts
 	BenchMarkTime  = 60 * time.Second      // 負荷走行の時間
+	PostTestGrace  = 50 * time.Millisecond // 負荷走行が終わってから事後テストまで待つ時間
+	PostTestLimit  = 60 * time.Second      // 事後テストのタイムアウト
+	PreTestLimit   = 60 * time.Second      // pretest_timeout が 0 のときに実行にかかる時間を見積もるための事前テストの時間
+	TeardownLimit  = 10 * time.Second      // 障害注入の後始末やユーザーの片付けにかけてよい時間
 	TickerInterval = 20 * time.Millisecond // tickerのinterval
 
 	InitTimeout   = 30 * time.Second       // Initialize のタイムアウト
+	InitLimit     = 10 * time.Second       // Initialize にかけてよい時間 (レギュレーション)
 	ClientTimeout = 15 * time.Second       // HTTP clientのタイムアウト
 	RetireTimeout = 10 * time.Second       // clientが退役するタイムアウト時間
 	RetryInterval = 500 * time.Millisecond // 50x系でエラーになったときのretry間隔
+	RetryBudget   = 10                     // GETをリトライできる回数 (ユーザーごと)
 
 	TestTradeTimeout = 5 * time.Second  // testでのtradeは成立までの時間
 	LogAllowedDelay  = 10 * time.Second // logの遅延が許される時間
 
+	HealthCheckTimeout = 2 * time.Second // /readyz で isubank, isulog の応答を待つ時間
+
 	PollingInterval     = 1000 * time.Millisecond // clientのポーリング感覚
 	OrderUpdateInterval = 1500 * time.Millisecond // 注文間隔
 	BruteForceDelay     = 500 * time.Millisecond  // 総当たりログイン試行間隔
+	PurgeInterval       = 1 * time.Second         // 退役したユーザーを片付ける間隔
+	RetireMinTimeout    = 5 * time.Second         // levelが上がっても退役するまでの時間をこれより短くしない
+	IDFetchBackoffMin   = 100 * time.Millisecond  // bank_idの作成に失敗したときに待つ時間
+	IDFetchBackoffMax   = 5 * time.Second         // bank_idの作成に失敗し続けたときに待つ最大の時間
+	MarketMakerQuoteTTL = 5 * time.Second         // マーケットメイカーが注文を出し直すまでの時間
+	BankFaultTimeout    = 3 * time.Second         // 障害注入でisubankが応答せずに接続を切るまでの時間
+	ChaosInterval       = 15 * time.Second        // -chaos で外部サービスに障害を起こす間隔
+	ChaosWindow         = 5 * time.Second         // -chaos で障害を続ける時間
+	ChaosLatency        = 1 * time.Second         // -chaos で外部サービスを遅くするときの遅延
+	ChaosRecovery       = 15 * time.Second        // -chaos で負荷走行の最後に障害を起こさない時間
+	LogVerifyInterval   = 5 * time.Second         // 負荷走行中にisulogを確認する間隔
+	SlowRequest         = 1 * time.Second         // これより時間がかかったリクエストを最後に表示する
+	CampaignInterval    = 10 * time.Second        // キャンペーンを受け付ける最短の間隔
+	CampaignMax         = 3                       // 1回の負荷走行で受け付けるキャンペーンの数
+	AddUsersOnCampaign  = 20                      // キャンペーンによって増えるユーザー数
+
+	LevelUpBaseScore  = 100 // level 0 から上がるのに必要なスコア
+	AddUsersOnShare   = 3   // SNSシェアによって増えるユーザー数
+	SharePercent      = 100 // enable_share のユーザーが成約したときにSNSでシェアする割合 (%)
+	AddUsersOnNatural = 2   // 自然増で増えるユーザー数
+	DefaultWorkers    = 10  // 初期
+	BruteForceWorkers = 2   // ログインを試行してくるユーザー
+	PurgeBatchSize    = 200 // 一度に調べるユーザー数
+
+	IDPoolMin      = 10   // 予め用意しておくbank_idの最小数
+	IDPoolMax      = 1000 // 予め用意しておくbank_idの最大数
+	IDPoolHeadroom = 2    // 直近の使用数の何倍を用意しておくか
+	IDFetchWorkers = 4    // bank_idを用意するgoroutineの数
+
+	IDFetchBreakThreshold  = 10  // bank_idの作成にこれだけ続けて失敗したら負荷走行を中断する
+	SessionRestartEvery    = 20  // この回数注文するごとにブラウザを再起動してログインが残っているか確かめる
+	KeepAliveTestRequests  = 3   // keep-aliveのテストで続けて送るリクエスト数
+	BankFaultCheckUsers    = 10  // 障害を注入したときに入出金履歴を突き合わせるユーザー数
+	PostTestUsers          = 3   // 事後テストで残高とログを確かめるユーザー数
+	PostTestWorkers        = 8   // 事後テストで同時に確かめるユーザー数
+	LogVerifyUsers         = 3   // 負荷走行中に一度にisulogを確認するユーザー数
+	ExoticUserPercent      = 5   // 新規ユーザーのうち絵文字や最大長の名前, パスワードにする割合 (%)
+	UserNameMaxLength      = 128 // 名前の最大文字数 (user.name VARCHAR(128))
+	UserPasswordMaxBytes   = 72  // パスワードの最大バイト数 (bcrypt が扱える長さ)
+	BruteForceLockoutAfter = 5   // 同じbank_idに何回続けてログインに失敗したら403を返してよいか
+	SlowRequestTopN        = 20  // 最後に表示する遅いリクエストの数
+	RandomCancelPercent    = 10  // 未成約の注文があるときに、そのどれかをキャンセルする割合 (%)
+	RetireLevelStep        = 5   // levelが1上がるごとに退役するまでの時間を縮める割合 (%)
+	RetireWindow           = 20  // retire.policy が p95 のときに p95 をみる直近のリクエスト数
+	RetireMaxErrors        = 0   // 続けてこの回数リクエストに失敗したユーザーは退役する. 0 なら失敗では退役しない
+
+	LogBufferSize = 8 << 20 // 結果に含めるログを残しておく最大のバイト数
+
+	MarketMakerSpread = 3 // マーケットメイカーが直近価格からずらす幅
+	ScalperRate       = 5 // スキャルパーが1秒間に出す注文の数
+	ScalperBurst      = 10
+	ScalperMaxWaiting = 3 // スキャルパーが同時に出しておく注文の数
+
+	PanicSellDropPercent = 10 // この割合以上価格が下がると狼狽売りする
+	PanicSellWindow      = 10 // 価格の下落をみる期間 (秒)
 
-	AddUsersOnShare   = 3  // SNSシェアによって増えるユーザー数
-	AddUsersOnNatural = 2  // 自然増で増えるユーザー数
-	DefaultWorkers    = 10 // 初期
-	BruteForceWorkers = 2  // ログインを試行してくるユーザー
+	ChartWatchInterval = 200 * time.Millisecond // チャートを眺めるだけのユーザーが /info を叩く間隔
 
 	// Scores
 	SignupScore       = 3
@@ -34,8 +94,14 @@ const (
 	TradeSuccessScore = 10
 	GetInfoScore      = 1
 	GetTopScore       = 1
+	TradePushScore    = 1  // WebSocketで通知された成約が/infoと一致したとき
+	GzipBonusPercent  = 1  // 半数以上のレスポンスがgzipで圧縮されていたときのボーナス (%)
+	NotModifiedScore  = 1  // キャッシュした静的ファイルに304を返せたとき
+	LockoutScore      = 3  // 総当たりログインに403を返してロックできたとき
+	CampaignScore     = 50 // /info でキャンペーンを告知してユーザーを受け入れたとき
 
 	// error
 	AllowErrorMin = 20 // levelによらずここまでは許容範囲というエラー数
 	AllowErrorMax = 50 // levelによらずこれ以上は許さないというエラー数
+	ErrorPenalty  = 50 // score_model が linear のときのエラー1件あたりの減点
 )
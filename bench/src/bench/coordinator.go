@@ -0,0 +1,258 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// 複数台のベンチマーカーで負荷をかけるための coordinator / agent
+// coordinator は通常通り Initialize, PreTest, PostTest を行い、
+// agent は負荷走行のみを行って coordinator に結果を報告する
+// (gRPCの依存は入れたくないのでHTTP+JSONでやりとりする)
+
+const (
+	PhaseWaiting    = "waiting"
+	PhaseInitialize = "initialize"
+	PhasePreTest    = "pretest"
+	PhaseBenchmark  = "benchmark"
+	PhasePostTest   = "posttest"
+	PhaseDone       = "done"
+
+	AgentReportInterval = 1 * time.Second
+)
+
+var errAgentRejected = errors.New("coordinator rejected report. too many errors")
+
+type AgentStatus struct {
+	Phase     string        `json:"phase"`
+	Remaining time.Duration `json:"remaining"`
+}
+
+type AgentReport struct {
+	AgentID     string              `json:"agent_id"`
+	Score       int64               `json:"score"`
+	Counts      map[ScoreType]int64 `json:"counts"`
+	Errors      []string            `json:"errors"` // 前回の報告以降に発生したエラー
+	Users       int                 `json:"users"`
+	ActiveUsers int                 `json:"active_users"`
+	Done        bool                `json:"done"`
+}
+
+type agentState struct {
+	mu      sync.Mutex
+	reports map[string]*AgentReport
+}
+
+func (c *Manager) SetPhase(phase string) {
+	c.phaseLock.Lock()
+	defer c.phaseLock.Unlock()
+	c.phase = phase
+	if phase == PhaseBenchmark {
+		c.benchStart = time.Now()
+		c.benchEnd = c.benchStart.Add(c.benchmarkLength())
+	}
+}
+
+// benchmarkDeadline は負荷走行を終える時刻. 一時停止していた分だけ延びる
+// Runner と agent に伝える残り時間はどちらもこれから決める
+func (c *Manager) benchmarkDeadline() time.Time {
+	c.phaseLock.Lock()
+	defer c.phaseLock.Unlock()
+	return c.benchEnd.Add(c.pause.total())
+}
+
+func (c *Manager) Phase() string {
+	c.phaseLock.Lock()
+	defer c.phaseLock.Unlock()
+	return c.phase
+}
+
+func (c *Manager) agentStatus() AgentStatus {
+	c.phaseLock.Lock()
+	defer c.phaseLock.Unlock()
+	st := AgentStatus{Phase: c.phase}
+	if c.phase == PhaseBenchmark {
+		st.Remaining = c.benchEnd.Add(c.pause.total()).Sub(time.Now())
+	}
+	return st
+}
+
+// AgentScore はagentから報告されたスコアの合計
+func (c *Manager) AgentScore() int64 {
+	c.agents.mu.Lock()
+	defer c.agents.mu.Unlock()
+	var score int64
+	for _, r := range c.agents.reports {
+		score += r.Score
+	}
+	return score
+}
+
+func (c *Manager) receiveAgentReport(r *AgentReport) error {
+	c.agents.mu.Lock()
+	c.agents.reports[r.AgentID] = r
+	c.agents.mu.Unlock()
+	for _, e := range r.Errors {
+		c.Slog("coordinator").Warn("error(agent)", "agent", r.AgentID, "error", e)
+		if err := c.AppendError(errors.Errorf("%s (agent:%s)", e, r.AgentID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AgentHandler は coordinator として agent からの問い合わせと報告を受け付ける
+func (c *Manager) AgentHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/agent/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.agentStatus())
+	})
+	mux.HandleFunc("/agent/report", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		report := &AgentReport{}
+		if err := json.NewDecoder(r.Body).Decode(report); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := c.receiveAgentReport(report); err != nil {
+			// エラー超過は agent に止まってもらう
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// Agent は coordinator の負荷走行フェーズに合わせて負荷をかける
+type Agent struct {
+	mgr         *Manager
+	id          string
+	coordinator string
+	hc          *http.Client
+
+	mu         sync.Mutex
+	sentErrors int
+}
+
+func NewAgent(mgr *Manager, id, coordinator string) *Agent {
+	// 既存ユーザーは coordinator 側と取り合いになるので使わない
+	mgr.testusers = nil
+	return &Agent{
+		mgr:         mgr,
+		id:          id,
+		coordinator: strings.TrimSuffix(coordinator, "/"),
+		hc:          &http.Client{Timeout: mgr.conf.Client.timeout()},
+	}
+}
+
+func (a *Agent) status() (*AgentStatus, error) {
+	res, err := a.hc.Get(a.coordinator + "/agent/status")
+	if err != nil {
+		return nil, errors.Wrap(err, "coordinator status request failed")
+	}
+	defer res.Body.Close()
+	st := &AgentStatus{}
+	if err = json.NewDecoder(res.Body).Decode(st); err != nil {
+		return nil, errors.Wrap(err, "coordinator status decode failed")
+	}
+	return st, nil
+}
+
+func (a *Agent) report(done bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	m := a.mgr
+	errs := m.GetErrorsString()
+	r := &AgentReport{
+		AgentID:     a.id,
+		Score:       m.GetScore(),
+		Counts:      m.scoreboard.Counts(),
+		Errors:      errs[a.sentErrors:],
+		Users:       m.AllUsers(),
+		ActiveUsers: m.ActiveUsers(),
+		Done:        done,
+	}
+	body := &bytes.Buffer{}
+	if err := json.NewEncoder(body).Encode(r); err != nil {
+		return err
+	}
+	res, err := a.hc.Post(a.coordinator+"/agent/report", "application/json", body)
+	if err != nil {
+		return errors.Wrap(err, "coordinator report request failed")
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusConflict {
+		return errAgentRejected
+	}
+	if res.StatusCode >= 400 {
+		return errors.Errorf("coordinator report failed. status:%d", res.StatusCode)
+	}
+	a.sentErrors = len(errs)
+	return nil
+}
+
+func (a *Agent) Run(ctx context.Context) error {
+	m := a.mgr
+	var st *AgentStatus
+	for {
+		var err error
+		if st, err = a.status(); err != nil {
+			m.Logger().Printf("coordinatorに接続できません: %s", err)
+		} else if st.Phase == PhaseBenchmark && st.Remaining > 0 {
+			break
+		} else if st.Phase == PhaseDone {
+			return errors.Errorf("coordinator の負荷走行は終了しています")
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(AgentReportInterval):
+		}
+	}
+
+	m.Logger().Printf("# benchmark (agent:%s, remaining:%s)", a.id, st.Remaining)
+	cctx, cancel := context.WithTimeout(ctx, st.Remaining)
+	defer cancel()
+	go func() {
+		if err := m.RunIDFetcher(cctx); err != nil {
+			m.Logger().Printf("ベンチマークを中断します: %s", err)
+			cancel()
+		}
+	}()
+	go func() {
+		defer cancel()
+		for {
+			select {
+			case <-cctx.Done():
+				return
+			case <-time.After(AgentReportInterval):
+				if err := a.report(false); err != nil {
+					m.Logger().Printf("coordinatorへの報告に失敗しました: %s", err)
+					if err == errAgentRejected {
+						return
+					}
+				}
+			}
+		}
+	}()
+	err := m.ScenarioStart(cctx)
+	if err == context.DeadlineExceeded {
+		err = nil
+	}
+	if rerr := a.report(true); rerr != nil {
+		m.Logger().Printf("coordinatorへの報告に失敗しました: %s", rerr)
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package bench
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DryRunReport は -dry-run で負荷をかけずに一通りのリクエストを試した結果
+type DryRunReport struct {
+	OK    bool          `json:"ok"`
+	Steps []DryRunStep  `json:"steps"`
+	Time  time.Duration `json:"time"`
+}
+
+type DryRunStep struct {
+	Name    string  `json:"name"`
+	OK      bool    `json:"ok"`
+	Error   string  `json:"error,omitempty"`
+	Elapsed float64 `json:"elapsed"` // 秒
+}
+
+func (r *DryRunReport) step(name string, f func() error) bool {
+	start := time.Now()
+	err := f()
+	s := DryRunStep{Name: name, OK: err == nil, Elapsed: time.Since(start).Seconds()}
+	if err != nil {
+		s.Error = err.Error()
+		r.OK = false
+	}
+	r.Steps = append(r.Steps, s)
+	return err == nil
+}
+
+// DryRun は Initialize と PreTest を行ったあと、ユーザーの操作を1回ずつ試す
+// 負荷走行はしないのでスコアは出ない. 途中で失敗したらそこで終わる
+func (r *Runner) DryRun(ctx context.Context) *DryRunReport {
+	m := r.mgr
+	report := &DryRunReport{OK: true}
+	start := time.Now()
+	defer func() {
+		report.Time = time.Since(start)
+		for _, s := range report.Steps {
+			if s.OK {
+				m.Logger().Printf("dry-run %-12s: ok (%.3fs)", s.Name, s.Elapsed)
+			} else {
+				m.Logger().Printf("dry-run %-12s: NG %s", s.Name, s.Error)
+			}
+		}
+	}()
+
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		if err := m.RunIDFetcher(cctx); err != nil {
+			m.Logger().Printf("dry-run bank_id: NG %s", err)
+		}
+	}()
+
+	if !report.step("initialize", func() error { return m.Initialize(cctx) }) {
+		return report
+	}
+	if !report.step("pretest", func() error { return m.PreTest(cctx) }) {
+		return report
+	}
+
+	var cl *Client
+	if !report.step("new user", func() (err error) {
+		cl, err = m.NewUserClient(0, 1000)
+		return err
+	}) {
+		return report
+	}
+	var order *Order
+	steps := []struct {
+		name string
+		f    func() error
+	}{
+		{"top", func() error { return cl.Top(cctx) }},
+		{"info", func() error { _, err := cl.Info(cctx, 0); return err }},
+		{"signup", func() error { return cl.Signup(cctx) }},
+		{"signin", func() error { return cl.Signin(cctx) }},
+		{"orders", func() error { _, err := cl.GetOrders(cctx); return err }},
+		{"add order", func() (err error) {
+			// 成立しないような安い買い注文
+			order, err = cl.AddOrder(cctx, TradeTypeBuy, 1, 1)
+			return err
+		}},
+		{"delete order", func() error {
+			if order == nil {
+				return errors.New("no order")
+			}
+			return cl.DeleteOrders(cctx, order.ID)
+		}},
+		{"signout", func() error { return cl.Signout(cctx) }},
+	}
+	for _, s := range steps {
+		if !report.step(s.name, s.f) {
+			return report
+		}
+	}
+	if m.conf.TradeStreamPath != "" {
+		report.step("trade stream", func() error {
+			wctx, wcancel := context.WithTimeout(cctx, time.Second)
+			defer wcancel()
+			err := NewTradeWatcher(m.smchan).Watch(wctx, cl, m.conf.TradeStreamPath)
+			if errors.Cause(err) == context.DeadlineExceeded {
+				// つながったまま時間切れになったならOK
+				return nil
+			}
+			return err
+		})
+	}
+	return report
+}
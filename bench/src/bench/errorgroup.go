@@ -0,0 +1,83 @@
+package bench
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"bench/portal"
+)
+
+var (
+	// ユーザーごとに変わる値. status のコードは残す
+	errorUserPattern   = regexp.MustCompile(`(bank_id|user|user_id|agent):[^,\])\s]+`)
+	errorNumberPattern = regexp.MustCompile(`(status:)?[0-9]+(\.[0-9]+)?`)
+)
+
+// errorGroups は正規化したメッセージとエンドポイントが同じエラーをまとめて数える
+type errorGroups struct {
+	index  map[string]int
+	groups []*portal.ErrorGroup
+}
+
+func newErrorGroups() *errorGroups {
+	return &errorGroups{index: make(map[string]int, 20)}
+}
+
+func (g *errorGroups) add(e error, at time.Time) {
+	endpoint, msg := normalizeError(e)
+	key := endpoint + "\n" + msg
+	i, ok := g.index[key]
+	if !ok {
+		i = len(g.groups)
+		g.index[key] = i
+		g.groups = append(g.groups, &portal.ErrorGroup{Endpoint: endpoint, Message: msg, First: at})
+	}
+	eg := g.groups[i]
+	eg.Count++
+	eg.Last = at
+}
+
+// sorted は多い順 (同じ数なら先に起きた順) のコピーを返す
+func (g *errorGroups) sorted() []portal.ErrorGroup {
+	r := make([]portal.ErrorGroup, 0, len(g.groups))
+	for _, eg := range g.groups {
+		e := *eg
+		e.Message = Translate(e.Message)
+		r = append(r, e)
+	}
+	sort.SliceStable(r, func(i, j int) bool { return r[i].Count > r[j].Count })
+	return r
+}
+
+// normalizeError は ID や数値、経過時間を伏せたメッセージと、分かればエンドポイントを返す
+func normalizeError(e error) (string, string) {
+	var endpoint string
+	for err := e; err != nil; {
+		if be, ok := err.(*BenchError); ok {
+			endpoint = endpointName(be.Method, be.Path)
+			break
+		}
+		c, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = c.Cause()
+	}
+	msg := errorUserPattern.ReplaceAllString(e.Error(), "$1:*")
+	msg = errorNumberPattern.ReplaceAllStringFunc(msg, func(s string) string {
+		if strings.HasPrefix(s, "status:") {
+			return s
+		}
+		return "N"
+	})
+	return endpoint, msg
+}
+
+// ErrorGroups は負荷走行中のエラーを同じ内容ごとにまとめたもの
+func (c *Manager) ErrorGroups() []portal.ErrorGroup {
+	c.errorLock.Lock()
+	defer c.errorLock.Unlock()
+	return c.errGroups.sorted()
+}
@@ -0,0 +1,122 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type ErrorCategory int
+
+const (
+	ErrorCategoryValidation ErrorCategory = iota // レスポンスの内容がおかしい
+	ErrorCategoryTimeout                         // タイムアウト
+	ErrorCategoryStatus                          // HTTP status codeがおかしい
+	ErrorCategoryBank                            // 銀行残高との不整合
+	ErrorCategorySecurity                        // SQL injection などの脆弱性
+)
+
+var errorCategories = []ErrorCategory{
+	ErrorCategoryValidation,
+	ErrorCategoryTimeout,
+	ErrorCategoryStatus,
+	ErrorCategoryBank,
+	ErrorCategorySecurity,
+}
+
+func (ec ErrorCategory) String() string {
+	switch ec {
+	case ErrorCategoryValidation:
+		return "validation"
+	case ErrorCategoryTimeout:
+		return "timeout"
+	case ErrorCategoryStatus:
+		return "status"
+	case ErrorCategoryBank:
+		return "bank"
+	case ErrorCategorySecurity:
+		return "security"
+	default:
+		return fmt.Sprintf("unknown[%d]", ec)
+	}
+}
+
+// BenchError はエラーの原因となったリクエストの情報を持つ
+type BenchError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Elapsed    time.Duration
+	BankID     string
+	err        error
+}
+
+func (e *BenchError) Error() string {
+	s := fmt.Sprintf("%s (%s %s", e.err.Error(), e.Method, e.Path)
+	if e.StatusCode > 0 {
+		s += fmt.Sprintf(", status:%d", e.StatusCode)
+	}
+	if e.Elapsed > 0 {
+		s += fmt.Sprintf(", elapsed:%.3fs", e.Elapsed.Seconds())
+	}
+	if e.BankID != "" {
+		s += fmt.Sprintf(", bank_id:%s", e.BankID)
+	}
+	return s + ")"
+}
+
+// errors.Cause で元のエラーをたどれるようにする
+func (e *BenchError) Cause() error {
+	return e.err
+}
+
+type ErrBankInconsistency struct {
+	s string
+}
+
+func (e *ErrBankInconsistency) Error() string {
+	return e.s
+}
+
+func bankErrorf(format string, args ...interface{}) error {
+	return errors.WithStack(&ErrBankInconsistency{fmt.Sprintf(format, args...)})
+}
+
+// ErrVulnerability はアプリに脆弱性が見つかったことを表す
+// 1件でもあれば致命的なエラーとして扱う
+type ErrVulnerability struct {
+	s string
+}
+
+func (e *ErrVulnerability) Error() string {
+	return e.s
+}
+
+func vulnErrorf(format string, args ...interface{}) error {
+	return errors.WithStack(&ErrVulnerability{fmt.Sprintf(format, args...)})
+}
+
+func categorizeError(err error) ErrorCategory {
+	cause := errors.Cause(err)
+	if cause == context.DeadlineExceeded {
+		return ErrorCategoryTimeout
+	}
+	switch e := cause.(type) {
+	case *ErrElapsedTimeOverRetire:
+		return ErrorCategoryTimeout
+	case *ErrorWithStatus:
+		return ErrorCategoryStatus
+	case *ErrBankInconsistency:
+		return ErrorCategoryBank
+	case *ErrVulnerability:
+		return ErrorCategorySecurity
+	case *url.Error:
+		if e.Timeout() {
+			return ErrorCategoryTimeout
+		}
+	}
+	return ErrorCategoryValidation
+}
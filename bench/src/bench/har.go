@@ -0,0 +1,252 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	HARMaxEntries  = 10000     // 記録するリクエストの最大数
+	HARMaxBodySize = 64 * 1024 // 記録するbodyの最大サイズ
+)
+
+// HAR 1.2 (http://www.softwareishard.com/blog/har-12-spec/) のうち使う項目だけ
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"` // ms
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	Cookies     []harNameValue `json:"cookies"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	Cookies     []harNameValue `json:"cookies"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harRecorder はClientのリクエストとレスポンスを抜き出してHARとして記録する
+// エラーのレスポンス (4xx, 5xx) は抜き出す割合によらず記録する
+type harRecorder struct {
+	rate    float64
+	mu      sync.Mutex
+	entries []harEntry
+	dropped int
+}
+
+func newHARRecorder(rate float64) *harRecorder {
+	return &harRecorder{rate: rate}
+}
+
+func (h *harRecorder) sampled() bool {
+	return rand.Float64() < h.rate
+}
+
+// wrap は res.Body を読み終えて閉じたときに記録するようにする
+func (h *harRecorder) wrap(req *http.Request, reqbody []byte, res *http.Response, start, headerAt time.Time) {
+	if res.StatusCode < 400 && !h.sampled() {
+		return
+	}
+	e := harEntry{
+		StartedDateTime: start,
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: req.Proto,
+			Headers:     harHeaders(req.Header),
+			QueryString: []harNameValue{},
+			Cookies:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    len(reqbody),
+		},
+		Response: harResponse{
+			Status:      res.StatusCode,
+			StatusText:  http.StatusText(res.StatusCode),
+			HTTPVersion: res.Proto,
+			Headers:     harHeaders(res.Header),
+			Cookies:     []harNameValue{},
+			RedirectURL: res.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+	}
+	for k, vs := range req.URL.Query() {
+		for _, v := range vs {
+			e.Request.QueryString = append(e.Request.QueryString, harNameValue{k, v})
+		}
+	}
+	for _, c := range req.Cookies() {
+		e.Request.Cookies = append(e.Request.Cookies, harNameValue{c.Name, c.Value})
+	}
+	for _, c := range res.Cookies() {
+		e.Response.Cookies = append(e.Response.Cookies, harNameValue{c.Name, c.Value})
+	}
+	if len(reqbody) > 0 {
+		e.Request.PostData = &harPostData{MimeType: req.Header.Get("Content-Type"), Text: truncateBody(reqbody)}
+	}
+	res.Body = &harBody{ReadCloser: res.Body, done: func(body []byte, size int) {
+		end := time.Now()
+		e.Response.Content = harContent{Size: size, MimeType: res.Header.Get("Content-Type"), Text: string(body)}
+		e.Time = msec(end.Sub(start))
+		e.Timings = harTimings{Send: 0, Wait: msec(headerAt.Sub(start)), Receive: msec(end.Sub(headerAt))}
+		if size > len(body) {
+			e.Comment = "body truncated"
+		}
+		h.add(e)
+	}}
+}
+
+func (h *harRecorder) add(e harEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.entries) >= HARMaxEntries {
+		h.dropped++
+		return
+	}
+	h.entries = append(h.entries, e)
+}
+
+// WriteTo は記録したリクエストをHARのJSONで書き出す
+func (h *harRecorder) WriteTo(w io.Writer) (int64, error) {
+	h.mu.Lock()
+	entries := make([]harEntry, len(h.entries))
+	copy(entries, h.entries)
+	h.mu.Unlock()
+	cw := &countWriter{w: w}
+	err := json.NewEncoder(cw).Encode(struct {
+		Log harLog `json:"log"`
+	}{harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "isucon8-bench", Version: "1.0"},
+		Entries: entries,
+	}})
+	return cw.n, err
+}
+
+// harBody は読んだ内容を HARMaxBodySize まで残し、Closeしたときに done を呼ぶ
+type harBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	size int
+	done func(body []byte, size int)
+	once sync.Once
+}
+
+func (b *harBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.size += n
+		if rest := HARMaxBodySize - b.buf.Len(); rest > 0 {
+			if rest > n {
+				rest = n
+			}
+			b.buf.Write(p[:rest])
+		}
+	}
+	return n, err
+}
+
+func (b *harBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.done(b.buf.Bytes(), b.size) })
+	return err
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	headers := make([]harNameValue, 0, len(h))
+	for k, vs := range h {
+		for _, v := range vs {
+			headers = append(headers, harNameValue{k, v})
+		}
+	}
+	return headers
+}
+
+func truncateBody(b []byte) string {
+	if len(b) > HARMaxBodySize {
+		b = b[:HARMaxBodySize]
+	}
+	return string(b)
+}
+
+func msec(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// EnableHAR は以降に作るユーザーのリクエストを rate の割合で記録する
+func (c *Manager) EnableHAR(rate float64) {
+	c.har = newHARRecorder(rate)
+}
+
+// WriteHAR は記録したリクエストをHARで書き出す. EnableHAR していなければ何もしない
+func (c *Manager) WriteHAR(w io.Writer) error {
+	if c.har == nil {
+		return nil
+	}
+	_, err := c.har.WriteTo(w)
+	c.har.mu.Lock()
+	dropped := c.har.dropped
+	c.har.mu.Unlock()
+	if dropped > 0 {
+		c.Logger().Printf("har: %d requests were not recorded (max %d)", dropped, HARMaxEntries)
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// Health は /healthz, /readyz で返すベンチマーカーの状態
+type Health struct {
+	OK      bool     `json:"ok"`
+	Phase   string   `json:"phase"`
+	Reasons []string `json:"reasons,omitempty"`
+
+	IDFetchFailures int64  `json:"id_fetch_failures"` // bank_id の作成に失敗した回数
+	IDFetchStreak   int    `json:"id_fetch_streak"`   // 続けて失敗している回数
+	IDPoolError     string `json:"id_pool_error,omitempty"`
+	Isubank         string `json:"isubank,omitempty"` // /readyz のみ. 接続できれば ok
+	Isulog          string `json:"isulog,omitempty"`  //
+}
+
+// idFetcherHealth は bank_id を作れなくなっていれば理由を入れる
+func (c *Manager) idFetcherHealth(h *Health) {
+	h.IDFetchFailures = c.idfail.total()
+	h.IDFetchStreak = c.idfail.current()
+	if err := c.idpool.failure(); err != nil {
+		h.IDPoolError = err.Error()
+		h.Reasons = append(h.Reasons, "id pool: "+err.Error())
+	} else if h.IDFetchStreak >= IDFetchBreakThreshold {
+		h.Reasons = append(h.Reasons, "id fetcher: isubank で bank_id を作成できません")
+	}
+}
+
+// Healthz はプロセスが動いていて bank_id を作れるかを返す
+func (c *Manager) Healthz() *Health {
+	h := &Health{Phase: c.Phase()}
+	c.idFetcherHealth(h)
+	h.OK = len(h.Reasons) == 0
+	return h
+}
+
+// Readyz は新しい負荷走行を受け付けられるかを返す
+// 待機中で、bank_id を作れて、isubank と isulog に接続できればよい
+func (c *Manager) Readyz(ctx context.Context) *Health {
+	h := &Health{Phase: c.Phase()}
+	if h.Phase != PhaseWaiting {
+		h.Reasons = append(h.Reasons, "phase: "+h.Phase)
+	}
+	c.idFetcherHealth(h)
+
+	ctx, cancel := context.WithTimeout(ctx, HealthCheckTimeout)
+	defer cancel()
+	var bankErr, logErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		bankErr = c.isubank.Ping(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		logErr = c.isulog.Ping(ctx)
+	}()
+	wg.Wait()
+	h.Isubank, h.Isulog = "ok", "ok"
+	if bankErr != nil {
+		h.Isubank = bankErr.Error()
+		h.Reasons = append(h.Reasons, "isubank: "+h.Isubank)
+	}
+	if logErr != nil {
+		h.Isulog = logErr.Error()
+		h.Reasons = append(h.Reasons, "isulog: "+h.Isulog)
+	}
+	h.OK = len(h.Reasons) == 0
+	return h
+}
+
+// HealthHandler は /healthz, /readyz を返す. OK でなければ 503 にする
+// portal のスケジューラーはこれを見て、このノードにジョブを割り当てるかを決める
+func (c *Manager) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	writeHealth := func(w http.ResponseWriter, h *Health) {
+		w.Header().Set("Content-Type", "application/json")
+		if !h.OK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(h)
+	}
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, c.Healthz())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, c.Readyz(r.Context()))
+	})
+	return mux
+}
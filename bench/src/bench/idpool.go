@@ -0,0 +1,176 @@
+package bench
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// idPool は予め用意したbank_idを貯めておく
+// 直近で使われた数に合わせて貯めておく数を増減させるので、レベルアップで一度に大量のユーザーが増えても待たされない
+type idPool struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	ids    []string
+	target int // 貯めておきたい数
+	demand int // 前回調整してから取り出された数
+	want   chan struct{}
+	err    error // 補充できなくなった理由
+}
+
+func newIDPool() *idPool {
+	p := &idPool{
+		ids:    make([]string, 0, IDPoolMax),
+		target: IDPoolMin,
+		want:   make(chan struct{}, 1),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *idPool) notify() {
+	select {
+	case p.want <- struct{}{}:
+	default:
+	}
+}
+
+// get は1つ取り出す. 空なら補充されるまで待つ
+func (p *idPool) get() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.demand++
+	for len(p.ids) == 0 {
+		if p.err != nil {
+			return "", p.err
+		}
+		p.notify()
+		p.cond.Wait()
+	}
+	id := p.ids[0]
+	p.ids = p.ids[1:]
+	if len(p.ids) < p.target {
+		p.notify()
+	}
+	return id, nil
+}
+
+func (p *idPool) put(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ids = append(p.ids, id)
+	p.cond.Signal()
+}
+
+// fail はもう補充できないことを待っている呼び出し元に伝える
+func (p *idPool) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.cond.Broadcast()
+}
+
+// failure は補充できなくなっていればその理由を返す
+func (p *idPool) failure() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// reserve はこれから n 人増えることが分かっている時に、その分を先に貯め始める
+func (p *idPool) reserve(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t := len(p.ids) + n; t > p.target {
+		p.target = clampInt(t, IDPoolMin, IDPoolMax)
+	}
+	p.notify()
+}
+
+// adjust は直近の取り出し数から貯めておく数を決め直す
+func (p *idPool) adjust() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := clampInt(p.demand*IDPoolHeadroom, IDPoolMin, IDPoolMax)
+	if t < p.target {
+		// 減らすときはゆっくり
+		t = (p.target + t) / 2
+	}
+	p.target = t
+	p.demand = 0
+}
+
+// wait は補充が必要になるまで待つ
+func (p *idPool) wait(ctx context.Context) bool {
+	for {
+		p.mu.Lock()
+		short := len(p.ids) < p.target
+		p.mu.Unlock()
+		if short {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-p.want:
+		case <-time.After(TickerInterval):
+		}
+	}
+}
+
+// idFailures はbank_idの作成に失敗した回数を数える
+type idFailures struct {
+	mu     sync.Mutex
+	count  int64
+	streak int // 続けて失敗した回数
+}
+
+func (f *idFailures) fail() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.count++
+	f.streak++
+	return f.streak
+}
+
+func (f *idFailures) succeed() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.streak = 0
+}
+
+func (f *idFailures) total() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.count
+}
+
+func (f *idFailures) current() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.streak
+}
+
+// backoff は続けて n 回失敗したときに次に試すまで待つ時間
+func (f *idFailures) backoff(n int) time.Duration {
+	d := IDFetchBackoffMin
+	for i := 1; i < n && d < IDFetchBackoffMax; i++ {
+		d *= 2
+	}
+	if d > IDFetchBackoffMax {
+		d = IDFetchBackoffMax
+	}
+	return d
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,160 @@
+package bench
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const IDConflictMax = 10 // 記録しておく矛盾の最大数
+
+// idRegistry は負荷走行中にユーザーが見た注文と成約のIDを記録する
+// キャッシュやシャーディングでIDを使い回したり、別の注文を返したりしていないかを PostTest で確かめる
+type idRegistry struct {
+	mu        sync.Mutex
+	orders    map[int64]*seenOrder
+	trades    map[int64]Trade
+	conflicts []string
+	dropped   int
+}
+
+type seenOrder struct {
+	id       int64
+	userID   int64
+	typ      string
+	amount   int64
+	price    int64
+	sent     time.Time // POST /orders を送った時刻. 注文を出したユーザー以外から見ただけならゼロ
+	received time.Time // POST /orders のレスポンスを受け取った時刻
+
+	// 最後に GET /orders か /info で見たときの状態
+	seenAt    time.Time
+	createdAt time.Time
+	closedAt  *time.Time
+	tradeID   int64
+}
+
+func newIDRegistry() *idRegistry {
+	return &idRegistry{
+		orders: make(map[int64]*seenOrder, 10000),
+		trades: make(map[int64]Trade, 1000),
+	}
+}
+
+func (r *idRegistry) conflict(format string, args ...interface{}) {
+	if len(r.conflicts) >= IDConflictMax {
+		r.dropped++
+		return
+	}
+	r.conflicts = append(r.conflicts, fmt.Sprintf(format, args...))
+}
+
+// placed は POST /orders で返された注文のIDを記録する
+func (r *idRegistry) placed(userID int64, o *Order, sent, received time.Time) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.orders[o.ID]; ok {
+		if !s.sent.IsZero() || !s.same(userID, o) {
+			r.conflict("注文のIDが重複しています [order_id:%d, user_id:%d, other user_id:%d]", o.ID, userID, s.userID)
+			return
+		}
+		s.sent, s.received = sent, received
+		return
+	}
+	r.orders[o.ID] = &seenOrder{
+		id:       o.ID,
+		userID:   userID,
+		typ:      o.Type,
+		amount:   o.Amount,
+		price:    o.Price,
+		sent:     sent,
+		received: received,
+	}
+}
+
+// observe は GET /orders や /info で返された注文と成約が以前に見たものと矛盾しないか記録する
+func (r *idRegistry) observe(path string, orders []Order) {
+	if r == nil || len(orders) == 0 {
+		return
+	}
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range orders {
+		o := &orders[i]
+		s, ok := r.orders[o.ID]
+		if !ok {
+			s = &seenOrder{id: o.ID, userID: o.UserID, typ: o.Type, amount: o.Amount, price: o.Price}
+			r.orders[o.ID] = s
+		} else if !s.same(o.UserID, o) {
+			r.conflict("GET %s 同じIDで別の注文が返されました [order_id:%d, user_id:%d, other user_id:%d]", path, o.ID, o.UserID, s.userID)
+			continue
+		}
+		s.seenAt, s.createdAt, s.closedAt, s.tradeID = now, o.CreatedAt, nil, o.TradeID
+		if o.ClosedAt != nil {
+			closed := *o.ClosedAt
+			s.closedAt = &closed
+		}
+		if o.Trade == nil {
+			continue
+		}
+		t := *o.Trade
+		if st, ok := r.trades[t.ID]; !ok {
+			r.trades[t.ID] = t
+		} else if st.Amount != t.Amount || st.Price != t.Price || !st.CreatedAt.Equal(t.CreatedAt) {
+			r.conflict("GET %s 同じIDで別の成約が返されました [trade_id:%d, price:%d, amount:%d, other price:%d, other amount:%d]",
+				path, t.ID, t.Price, t.Amount, st.Price, st.Amount)
+		}
+	}
+}
+
+func (s *seenOrder) same(userID int64, o *Order) bool {
+	return s.userID == userID && s.typ == o.Type && s.amount == o.Amount && s.price == o.Price
+}
+
+// verify は記録した矛盾を返す
+// 矛盾がなければ、レスポンスを受け取った後に出した注文ほどIDが大きいことを確かめる
+func (r *idRegistry) verify() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.conflicts) > 0 {
+		msg := strings.Join(r.conflicts, ", ")
+		if r.dropped > 0 {
+			msg += fmt.Sprintf(" ほか%d件", r.dropped)
+		}
+		return errors.New(msg)
+	}
+	placed := make([]*seenOrder, 0, len(r.orders))
+	for _, s := range r.orders {
+		if !s.sent.IsZero() {
+			placed = append(placed, s)
+		}
+	}
+	bySent := make([]*seenOrder, len(placed))
+	copy(bySent, placed)
+	sort.Slice(bySent, func(i, j int) bool { return bySent[i].sent.Before(bySent[j].sent) })
+	sort.Slice(placed, func(i, j int) bool { return placed[i].received.Before(placed[j].received) })
+
+	// 注文を送る前にレスポンスを受け取っていた注文のうち、IDが最大のもの
+	var max *seenOrder
+	i := 0
+	for _, s := range bySent {
+		for ; i < len(placed) && placed[i].received.Before(s.sent); i++ {
+			if max == nil || max.id < placed[i].id {
+				max = placed[i]
+			}
+		}
+		if max != nil && s.id <= max.id {
+			return errors.Errorf("注文のIDが前に出した注文より小さくなっています [order_id:%d, user_id:%d, previous order_id:%d, previous user_id:%d]",
+				s.id, s.userID, max.id, max.userID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+package bench
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// marketMakerScenario は直近の成約価格を挟んで買いと売りの注文を出し続ける
+// 古くなった注文や価格から離れた注文はキャンセルして出し直すので、キャンセルが多く発生する
+type marketMakerScenario struct {
+	*normalScenario
+	spread int64
+}
+
+func NewMarketMakerScenario(c *Client, credit, isu, unit, spread int64) Scenario {
+	s := &marketMakerScenario{
+		normalScenario: newNormalScenario(c, credit, isu, unit, false),
+		spread:         spread,
+	}
+	s.trade = s.quote
+	return s
+}
+
+func (s *marketMakerScenario) midPrice() int64 {
+	if s.lowestSellPrice > 0 && s.highestBuyPrice > 0 {
+		return (s.lowestSellPrice + s.highestBuyPrice) / 2
+	}
+	return s.latestTradePrice
+}
+
+func (s *marketMakerScenario) quotePrice(ot string, mid int64) int64 {
+	if ot == TradeTypeBuy {
+		return mid - s.spread
+	}
+	return mid + s.spread
+}
+
+func (s *marketMakerScenario) quote(ctx context.Context) (ScoreType, error) {
+	s.ordersLock.Lock()
+	defer s.ordersLock.Unlock()
+	mid := s.midPrice()
+	if mid <= s.spread {
+		return 0, nil
+	}
+	var buying, selling bool
+	for _, o := range s.orders {
+		if o.ClosedAt != nil {
+			continue
+		}
+		diff := o.Price - s.quotePrice(o.Type, mid)
+		if diff < 0 {
+			diff = -diff
+		}
+		if time.Since(o.CreatedAt) > MarketMakerQuoteTTL || diff > s.spread {
+			return s.cancelOrder(ctx, o)
+		}
+		if o.Type == TradeTypeBuy {
+			buying = true
+		} else {
+			selling = true
+		}
+	}
+
+	if buying && selling {
+		return 0, nil
+	}
+	// 出ていない側の注文を出す
+	ot := TradeTypeBuy
+	if buying || (!selling && s.c.rand.Intn(2) == 0) {
+		ot = TradeTypeSell
+	}
+	amount := s.c.rand.Int63n(s.unitIsu) + 1
+	price := s.quotePrice(ot, mid)
+	if ot == TradeTypeBuy {
+		if credit := s.currentCredit - s.reservedCredit; credit < price*amount {
+			amount = credit / price
+		}
+	} else {
+		if isu := s.currentIsu - s.reservedIsu; isu < amount {
+			amount = isu
+		}
+	}
+	if amount < 1 {
+		return 0, nil
+	}
+	return s.placeOrder(ctx, ot, amount, price)
+}
+
+// tokenBucket は rate 個/秒 で補充され、最大 burst 個まで貯まる
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve は1つ取り出し、足りなければ補充されるまでの時間を返す
+// 待たずに前借りするので、複数のgoroutineから使うときは呼び出し側でロックする
+func (b *tokenBucket) reserve() time.Duration {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			return nil
+		}
+		wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// scalperScenario は少量の注文を間を置かずに出し続けて、マッチングの処理能力に負荷をかける
+// 注文の間隔は normalScenario の OrderUpdateInterval ではなく tokenBucket で決める
+type scalperScenario struct {
+	*normalScenario
+	bucket *tokenBucket
+}
+
+func NewScalperScenario(c *Client, credit, isu int64, rate, burst int) Scenario {
+	return &scalperScenario{
+		normalScenario: newNormalScenario(c, credit, isu, 1, false),
+		bucket:         newTokenBucket(rate, burst),
+	}
+}
+
+func (s *scalperScenario) Start(ctx context.Context, smchan chan ScoreMsg) error {
+	if err := s.enter(ctx, smchan); err != nil {
+		return err
+	}
+
+	go s.runScalp(ctx, smchan)
+
+	go s.runInfoLoop(ctx, smchan)
+
+	return nil
+}
+
+func (s *scalperScenario) runScalp(ctx context.Context, smchan chan ScoreMsg) {
+	for {
+		// runInfoLoop が詰まらないように読み捨てる
+		select {
+		case <-s.actionchan:
+		default:
+		}
+		if err := s.bucket.wait(ctx); err != nil {
+			handleContextErr(err)
+			return
+		}
+		if s.c.IsRetired() {
+			return
+		}
+		start := time.Now()
+		st, err := s.scalp(ctx)
+		if st == 0 {
+			// 出せる注文がないときは少し待つ
+			time.Sleep(PollingInterval)
+			continue
+		}
+		if st == ScoreTypePostOrders {
+			s.c.metrics.observeRequest(http.MethodPost, "/orders (scalper)", "", 0, err != nil, time.Since(start))
+		}
+		smchan <- ScoreMsg{st: st, err: err}
+		if err != nil {
+			if _, ok := errors.Cause(err).(*ErrElapsedTimeOverRetire); ok {
+				return
+			}
+		}
+	}
+}
+
+func (s *scalperScenario) scalp(ctx context.Context) (ScoreType, error) {
+	s.ordersLock.Lock()
+	defer s.ordersLock.Unlock()
+	var oldest *Order
+	waiting := 0
+	for _, o := range s.orders {
+		if o.ClosedAt == nil {
+			waiting++
+			if oldest == nil {
+				oldest = o
+			}
+		}
+	}
+	if waiting >= ScalperMaxWaiting {
+		return s.cancelOrder(ctx, oldest)
+	}
+	// 相手の最良気配にぶつけてすぐに約定させる
+	if s.c.rand.Intn(2) == 0 {
+		price := s.lowestSellPrice
+		if price == 0 {
+			price = s.latestTradePrice
+		}
+		if price > 0 && s.currentCredit-s.reservedCredit >= price {
+			return s.placeOrder(ctx, TradeTypeBuy, 1, price)
+		}
+	}
+	price := s.highestBuyPrice
+	if price == 0 {
+		price = s.latestTradePrice
+	}
+	if price > 0 && s.currentIsu-s.reservedIsu >= 1 {
+		return s.placeOrder(ctx, TradeTypeSell, 1, price)
+	}
+	return 0, nil
+}
+
+type pricePoint struct {
+	at    time.Time
+	price int64
+}
+
+// panicSellerScenario は普段は normalScenario と同じように取引するが、
+// 直近の成約価格が window の間に dropPercent 以上下がると持っている椅子をすべて成り行きで売る
+type panicSellerScenario struct {
+	*normalScenario
+	dropPercent int64
+	window      time.Duration
+	history     []pricePoint
+}
+
+func NewPanicSellerScenario(c *Client, credit, isu, unit, dropPercent int64, window time.Duration) Scenario {
+	s := &panicSellerScenario{
+		normalScenario: newNormalScenario(c, credit, isu, unit, false),
+		dropPercent:    dropPercent,
+		window:         window,
+	}
+	s.trade = s.tradeOrPanic
+	return s
+}
+
+// panicking は価格を記録して、window 内の高値から dropPercent 以上下がっているかを返す
+func (s *panicSellerScenario) panicking() bool {
+	now := time.Now()
+	price := s.latestTradePrice
+	if price <= 0 {
+		return false
+	}
+	s.history = append(s.history, pricePoint{now, price})
+	var high int64
+	i := 0
+	for j, p := range s.history {
+		if now.Sub(p.at) > s.window {
+			i = j + 1
+			continue
+		}
+		if high < p.price {
+			high = p.price
+		}
+	}
+	s.history = s.history[i:]
+	return price*100 <= high*(100-s.dropPercent)
+}
+
+func (s *panicSellerScenario) tradeOrPanic(ctx context.Context) (ScoreType, error) {
+	s.ordersLock.Lock()
+	if !s.panicking() || s.highestBuyPrice <= 0 {
+		s.ordersLock.Unlock()
+		return s.tryTrade(ctx)
+	}
+	defer s.ordersLock.Unlock()
+	// 注文中の椅子も売りたいので、まず売り注文をキャンセルする
+	for _, o := range s.orders {
+		if o.ClosedAt == nil && o.Type == TradeTypeSell {
+			return s.cancelOrder(ctx, o)
+		}
+	}
+	amount := s.currentIsu - s.reservedIsu
+	if amount < 1 {
+		return 0, nil
+	}
+	log.Printf("[INFO] panic sell [user:%d, price:%d, amount:%d]", s.c.UserID(), s.highestBuyPrice, amount)
+	return s.placeOrder(ctx, TradeTypeSell, amount, s.highestBuyPrice)
+}
+
+// chartWatcherScenario は取引せずに /info を見続けるだけのユーザー
+// cursor をいろいろ変えて叩くので、アプリ側のキャッシュが効いているかがスコアに出る
+type chartWatcherScenario struct {
+	*baseScenario
+	interval time.Duration
+}
+
+func NewChartWatcherScenario(c *Client, interval time.Duration) Scenario {
+	return &chartWatcherScenario{
+		baseScenario: &baseScenario{c},
+		interval:     interval,
+	}
+}
+
+func (s *chartWatcherScenario) Start(ctx context.Context, smchan chan ScoreMsg) error {
+	err := s.c.Top(ctx)
+	smchan <- ScoreMsg{st: ScoreTypeGetTop, err: err}
+	if err != nil {
+		return errors.Wrap(err, "トップページを表示できません")
+	}
+	go s.runWatch(ctx, smchan)
+	return nil
+}
+
+func (s *chartWatcherScenario) runWatch(ctx context.Context, smchan chan ScoreMsg) {
+	var latest int64
+	for {
+		select {
+		case <-ctx.Done():
+			handleContextErr(ctx.Err())
+			return
+		case <-time.After(s.interval):
+			if s.c.IsRetired() {
+				return
+			}
+			var cursor int64
+			switch s.c.rand.Intn(4) {
+			case 0:
+				// 初めて開いたとき
+				cursor = 0
+			case 1:
+				// しばらく放置していたとき
+				if latest > 1 {
+					cursor = s.c.rand.Int63n(latest-1) + 1
+				}
+			default:
+				cursor = latest
+			}
+			info, err := s.c.Info(ctx, cursor)
+			if err == nil {
+				err = testCharts(info)
+			}
+			smchan <- ScoreMsg{st: ScoreTypeGetInfo, err: err}
+			if err != nil {
+				if _, ok := errors.Cause(err).(*ErrElapsedTimeOverRetire); ok {
+					return
+				}
+				continue
+			}
+			if latest < info.Cursor {
+				latest = info.Cursor
+			}
+		}
+	}
+}
@@ -2,15 +2,21 @@ package isubank
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+// ErrBankIDExists は登録しようとした bank_id がもう使われていたこと
+var ErrBankIDExists = errors.New("bank_id already exists")
+
 type isubankResponse interface {
 	SetStatus(int)
 }
@@ -56,6 +62,9 @@ func (b *Isubank) NewBankID(bankid string) error {
 	if res.Success() {
 		return nil
 	}
+	if res.Error == ErrBankIDExists.Error() {
+		return ErrBankIDExists
+	}
 	return errors.Errorf("/register failed. %s", res.Error)
 }
 
@@ -70,12 +79,12 @@ func (b *Isubank) AddCredit(bankid string, price int64) error {
 	return errors.Errorf("failed add credit. bankid:%s, price:%d, err:%s", bankid, price, res.Error)
 }
 
-func (b *Isubank) GetCredit(bankid string) (int64, error) {
+func (b *Isubank) GetCredit(ctx context.Context, bankid string) (int64, error) {
 	u := new(url.URL)
 	*u = *b.endpoint
 	u.Path = path.Join(u.Path, "/credit")
 	u.RawQuery = url.Values{"bank_id": []string{bankid}}.Encode()
-	res, err := http.Get(u.String())
+	res, err := get(ctx, u.String())
 	if err != nil {
 		return 0, errors.Wrap(err, "isubank get_credit failed")
 	}
@@ -97,6 +106,120 @@ func (b *Isubank) GetCredit(bankid string) (int64, error) {
 	return 0, errors.Errorf("isubank getCredit failed. [status:%d, body:%s]", res.StatusCode, string(body))
 }
 
+// AddCreditNote は AddCredit で入金したときの履歴のnote
+const AddCreditNote = "by add credit API"
+
+type CreditHistory struct {
+	Amount    int64     `json:"amount"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetCreditHistory は確定済みの入出金履歴を古い順に返す
+func (b *Isubank) GetCreditHistory(ctx context.Context, bankid string) ([]CreditHistory, error) {
+	u := new(url.URL)
+	*u = *b.endpoint
+	u.Path = path.Join(u.Path, "/credit_history")
+	u.RawQuery = url.Values{"bank_id": []string{bankid}}.Encode()
+	res, err := get(ctx, u.String())
+	if err != nil {
+		return nil, errors.Wrap(err, "isubank get_credit_history failed")
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 200 {
+		var r struct {
+			History []CreditHistory `json:"history"`
+		}
+		if err = json.NewDecoder(res.Body).Decode(&r); err != nil {
+			return nil, errors.Wrap(err, "isubank get_credit_history decode failed")
+		}
+		return r.History, nil
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "isubank read body failed")
+	}
+	return nil, errors.Errorf("isubank getCreditHistory failed. [status:%d, body:%s]", res.StatusCode, string(body))
+}
+
+// Fault は reserve, commit に注入する障害の設定と、それまでに注入した回数
+type Fault struct {
+	FailRate    int   `json:"fail_rate"`
+	TimeoutRate int   `json:"timeout_rate"`
+	Timeout     int64 `json:"timeout"` // ms
+	Latency     int64 `json:"latency"` // ms
+
+	Failed   int64 `json:"failed"`
+	TimedOut int64 `json:"timed_out"`
+}
+
+// SetFault はこのappidのreserve, commitに障害を注入させる. 割合を0にすると止まる
+func (b *Isubank) SetFault(ctx context.Context, f Fault) (Fault, error) {
+	body := &bytes.Buffer{}
+	if err := json.NewEncoder(body).Encode(f); err != nil {
+		return Fault{}, errors.Wrap(err, "isubank json encode failed")
+	}
+	return b.fault(ctx, "POST", body)
+}
+
+// GetFault は障害の設定と注入した回数を返す
+func (b *Isubank) GetFault(ctx context.Context) (Fault, error) {
+	return b.fault(ctx, "GET", nil)
+}
+
+func (b *Isubank) fault(ctx context.Context, method string, body io.Reader) (Fault, error) {
+	u := new(url.URL)
+	*u = *b.endpoint
+	u.Path = path.Join(u.Path, "/faults")
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return Fault{}, errors.Wrap(err, "isubank new request failed")
+	}
+	req.Header.Set("Authorization", "Bearer "+b.appid)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return Fault{}, errors.Wrap(err, "isubank faults failed")
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 200 {
+		var f Fault
+		if err = json.NewDecoder(res.Body).Decode(&f); err != nil {
+			return Fault{}, errors.Wrap(err, "isubank faults decode failed")
+		}
+		return f, nil
+	}
+	rb, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return Fault{}, errors.Wrap(err, "isubank read body failed")
+	}
+	return Fault{}, errors.Errorf("isubank faults failed. [status:%d, body:%s]", res.StatusCode, string(rb))
+}
+
+// Ping はisubankに接続できるかを確かめる. レスポンスのstatusは問わない
+func (b *Isubank) Ping(ctx context.Context) error {
+	req, err := http.NewRequest("GET", b.endpoint.String(), nil)
+	if err != nil {
+		return errors.Wrap(err, "isubank new request failed")
+	}
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "isubank ping failed")
+	}
+	defer res.Body.Close()
+	_, err = io.Copy(ioutil.Discard, res.Body)
+	return err
+}
+
+// get は ctx が終わったら諦める http.Get
+func get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req.WithContext(ctx))
+}
+
 func (b *Isubank) request(p string, v map[string]interface{}, r isubankResponse) error {
 	u := new(url.URL)
 	*u = *b.endpoint
@@ -1,6 +1,8 @@
 package isulog
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"io/ioutil"
@@ -200,6 +202,11 @@ func NewIsulog(endpoint, appid string) (*Isulog, error) {
 	}, nil
 }
 
+// WithAppID は同じisulogを別のapp_idで参照する
+func (b *Isulog) WithAppID(appid string) *Isulog {
+	return &Isulog{endpoint: b.endpoint, appid: appid}
+}
+
 func (b *Isulog) AppID() string {
 	return b.appid
 }
@@ -223,27 +230,115 @@ func (b *Isulog) Initialize() error {
 
 }
 
-func (b *Isulog) GetUserLogs(userID int64) ([]*Log, error) {
+// Ping はisulogに接続できるかを確かめる. レスポンスのstatusは問わない
+func (b *Isulog) Ping(ctx context.Context) error {
+	req, err := http.NewRequest("GET", b.endpoint.String(), nil)
+	if err != nil {
+		return errors.Wrap(err, "isulog new request failed")
+	}
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "isulog ping failed")
+	}
+	defer res.Body.Close()
+	_, err = io.Copy(ioutil.Discard, res.Body)
+	return err
+}
+
+func (b *Isulog) GetUserLogs(ctx context.Context, userID int64) ([]*Log, error) {
 	v := url.Values{}
 	v.Set("app_id", b.AppID())
 	v.Set("user_id", strconv.FormatInt(userID, 10))
-	return b.getLogs(v)
+	return b.getLogs(ctx, v)
 }
 
-func (b *Isulog) GetTradeLogs(tradeID int64) ([]*Log, error) {
+func (b *Isulog) GetTradeLogs(ctx context.Context, tradeID int64) ([]*Log, error) {
 	v := url.Values{}
 	v.Set("app_id", b.AppID())
 	v.Set("trade_id", strconv.FormatInt(tradeID, 10))
-	return b.getLogs(v)
+	return b.getLogs(ctx, v)
+}
+
+// Fault は send, send_bulk に注入する障害の設定と、それまでに注入した回数
+type Fault struct {
+	FailRate int   `json:"fail_rate"`
+	Latency  int64 `json:"latency"` // ms
+
+	Failed int64 `json:"failed"`
+}
+
+// SetFault はこのappidのsend, send_bulkに障害を注入させる. すべて0にすると止まる
+func (b *Isulog) SetFault(ctx context.Context, f Fault) (Fault, error) {
+	u := new(url.URL)
+	*u = *b.endpoint
+	u.Path = path.Join(u.Path, "/faults")
+	body := &bytes.Buffer{}
+	if err := json.NewEncoder(body).Encode(f); err != nil {
+		return Fault{}, errors.Wrap(err, "isulog json encode failed")
+	}
+	req, err := http.NewRequest("POST", u.String(), body)
+	if err != nil {
+		return Fault{}, errors.Wrap(err, "isulog new request failed")
+	}
+	req.Header.Set("Authorization", "Bearer "+b.appid)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return Fault{}, errors.Wrap(err, "isulog POST /faults failed")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return Fault{}, errors.Errorf("isulog POST /faults failed. status code [%d]", res.StatusCode)
+	}
+	var r Fault
+	if err = json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return Fault{}, errors.Wrap(err, "isulog POST /faults decode json failed")
+	}
+	return r, nil
+}
+
+// Stats はアプリが send, send_bulk を呼んだ回数と send_bulk で送ったログの数
+type Stats struct {
+	Send     int64 `json:"send"`
+	SendBulk int64 `json:"send_bulk"`
+	BulkLogs int64 `json:"bulk_logs"`
 }
 
-func (b *Isulog) getLogs(v url.Values) ([]*Log, error) {
+func (b *Isulog) GetStats(ctx context.Context) (Stats, error) {
+	u := new(url.URL)
+	*u = *b.endpoint
+	u.Path = path.Join(u.Path, "/stats")
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return Stats{}, errors.Wrap(err, "isulog new request failed")
+	}
+	req.Header.Set("Authorization", "Bearer "+b.appid)
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return Stats{}, errors.Wrap(err, "isulog GET /stats failed")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return Stats{}, errors.Errorf("isulog GET /stats failed. status code [%d]", res.StatusCode)
+	}
+	var st Stats
+	if err = json.NewDecoder(res.Body).Decode(&st); err != nil {
+		return Stats{}, errors.Wrap(err, "isulog GET /stats decode json failed")
+	}
+	return st, nil
+}
+
+func (b *Isulog) getLogs(ctx context.Context, v url.Values) ([]*Log, error) {
 	u := new(url.URL)
 	*u = *b.endpoint
 	u.Path = path.Join(u.Path, "/logs")
 	u.RawQuery = v.Encode()
 
-	res, err := http.Get(u.String())
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "isulog new request failed")
+	}
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, errors.Wrap(err, "isulog GET /logs failed")
 	}
@@ -253,11 +348,24 @@ func (b *Isulog) getLogs(v url.Values) ([]*Log, error) {
 		return nil, errors.Wrap(err, "isulog GET /logs decode json failed")
 	}
 	if err = fetchLogDetails(r); err != nil {
-		return nil, err
+		return nil, &InvalidLogError{err}
 	}
 	return r, nil
 }
 
+// InvalidLogError はアプリが送ったログの形式が不正なときのエラー
+type InvalidLogError struct {
+	err error
+}
+
+func (e *InvalidLogError) Error() string {
+	return e.err.Error()
+}
+
+func (e *InvalidLogError) Cause() error {
+	return e.err
+}
+
 func fetchLogDetails(logs []*Log) error {
 	for _, l := range logs {
 		switch l.Tag {
@@ -0,0 +1,304 @@
+package bench
+
+import (
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	LangJa = "ja"
+	LangEn = "en"
+)
+
+// messagesEn はベンチが出すメッセージの英訳
+// キーは Printf や errors.Errorf に渡している書式そのままで、英訳でも動詞 (%d など) を同じ順に使う
+var messagesEn = map[string]string{
+	// manager, runner
+	"isubank でbank_idを%d回続けて作成できませんでした. isubank (%s) が動いているか確認してください": "failed to create bank_id on isubank %d times in a row. check that isubank (%s) is running",
+	"エラー件数が規定を超過しました. (%s)":                                           "too many errors. (%s)",
+	"エラー件数が規定を超過しました.":                                                "too many errors.",
+	"isuloggerの初期化に失敗しました。運営に連絡してください":                                "failed to initialize isulogger. please contact the organizers",
+	"POST /initialize (%d回目)":                                         "POST /initialize (#%d)",
+	"POST /initialize が制限時間 %s 以内に終わりませんでした [%d回目, elapsed:%.3fs]":    "POST /initialize did not finish within %s [#%d, elapsed:%.3fs]",
+	"POST /initialize 後の GET /info に失敗しました":                           "GET /info after POST /initialize failed",
+	"POST /initialize を2回呼ぶと状態が変わります [cursor:%d, %d]":                 "calling POST /initialize twice changes the state [cursor:%d, %d]",
+	"事後テスト後のデータ取得に失敗しました":                                             "failed to fetch data after the posttest",
+	"事後テスト後のセーブデータ作成に失敗しました":                                          "failed to create save data after the posttest",
+	"事後テスト後のセーブデータ保存に失敗しました":                                          "failed to store save data after the posttest",
+	"warm-up: スコアとエラーを %s の間数えません":                                    "warm-up: score and errors are not counted for %s",
+	"ベンチマークを終了します: %s":                                                "finishing the benchmark: %s",
+	"成約の通知を受け取ります (%s)":                                               "receiving trade notifications (%s)",
+	"成約の通知を受け取れません: %s":                                               "cannot receive trade notifications: %s",
+	"同時に動かすユーザー数が上限(%d)に達したので、これ以上増やしません":                             "the number of running users reached the limit (%d). no more users are added",
+	"アクティブユーザーが自然増加します":                                               "active users increase naturally",
+	"キャンペーン(id:%d)は受け付けませんでした":                                        "campaign (id:%d) was not accepted",
+	"キャンペーン(id:%d)のためアクティブユーザーが%d人増加しました":                             "active users increased by campaign (id:%d) [users:%d]",
+	"SNSでシェアされたためアクティブユーザーが%d人増加しました [user:%s, trade:%d]":             "active users increased by sharing on SNS [users:%d, user:%s, trade:%d]",
+	"ベンチマークを中断しました":                                                   "the benchmark was aborted",
+	"Initialize に失敗しました":                                              "Initialize failed",
+	"負荷走行前のテストに失敗しました":                                                "the pretest failed",
+	"isubankの障害注入の設定に失敗しました":                                          "failed to set up isubank fault injection",
+	"long polling で受け取った成約: %d件 [p50:%.3fs, p99:%.3fs]":               "trades received by long polling: %d [p50:%.3fs, p99:%.3fs]",
+	"負荷走行 に失敗しました":                                                    "the benchmark failed",
+	"負荷走行が中断されました":                                                    "the benchmark was interrupted",
+	"負荷走行後のテストに失敗しました":                                                "the posttest failed",
+	"%d人のユーザーで失敗しました":                                                 "failed for %d users",
+	"事後テストが時間内に終わりませんでした":                                             "the posttest did not finish in time",
+	"ユーザーの片付けが時間内に終わりませんでした":                                          "the users were not cleaned up in time",
+	"負荷走行を一時停止します":                                                    "pausing the benchmark",
+	"負荷走行を再開します":                                                      "resuming the benchmark",
+	"フェーズ %s を開始します":                                                  "starting phase %s",
+	"記録した負荷を再生します [users:%d]":                                         "replaying the recorded workload [users:%d]",
+	"%s 以上かかったリクエスト: %d件 (遅い順に%d件)":                                   "requests that took %s or longer: %d (slowest %d)",
+	"SLA: p95 %s (上限 %s)":                                             "SLA: p95 %s (limit %s)",
+	"SLA: error rate %.3f%% (上限 %.3f%%)":                              "SLA: error rate %.3f%% (limit %.3f%%)",
+	"SLAを満たしていません: %s":                                                "SLA is not satisfied: %s",
+
+	// coordinator
+	"coordinatorに接続できません: %s":    "cannot connect to the coordinator: %s",
+	"coordinator の負荷走行は終了しています":  "the benchmark of the coordinator has finished",
+	"ベンチマークを中断します: %s":           "aborting the benchmark: %s",
+	"coordinatorへの報告に失敗しました: %s": "failed to report to the coordinator: %s",
+
+	// scenario
+	"トップページを表示できません":                                     "cannot show the top page",
+	"アカウントを作成できませんでした":                                   "cannot create an account",
+	"ログインできませんでした":                                       "cannot sign in",
+	"注文履歴の取得に失敗しました":                                     "failed to fetch the order history",
+	"ブラウザを再起動したらログインが切れています [user:%d]":                   "signed out after restarting the browser [user:%d]",
+	"GET /orders 注文内容が反映されていません id:%d":                   "GET /orders the order is not reflected id:%d",
+	"GET /orders 売り注文が足りないか削除されています %d":                  "GET /orders sell orders are missing or deleted %d",
+	"GET /orders キャンセルした注文が成約しています [id:%d, trade_id:%d]": "GET /orders a cancelled order is traded [id:%d, trade_id:%d]",
+	"[INFO] 残高不足 [user:%d, price:%d, amount:%d]":         "[INFO] insufficient credit [user:%d, price:%d, amount:%d]",
+	"%d回続けて失敗したあとに不正ログインに成功しました。ロックされていません":              "unauthorized sign in succeeded after %d failures in a row. the account is not locked",
+	"不正ログインに成功しました":                                      "unauthorized sign in succeeded",
+	"POST /signin %d回の失敗でロックされました":                       "POST /signin the account was locked after %d failures",
+	"POST /signin %d回続けて失敗してもロックされません [bank_id:%s]":      "POST /signin the account is not locked after %d failures in a row [bank_id:%s]",
+	"注文情報の取得に失敗しました [user:%d]":                           "failed to fetch orders [user:%d]",
+	"GET %s 304なのにbodyが返されました":                           "GET %s returned a body with 304",
+	"ログインできません":                                          "cannot sign in",
+	"GET /infoを取得できません":                                  "cannot fetch GET /info",
+	"GET /ordersを取得できません":                                "cannot fetch GET /orders",
+
+	// 整合性のチェック
+	"GET %s 同じIDで別の成約が返されました [trade_id:%d, price:%d, amount:%d, other price:%d, other amount:%d]": "GET %s another trade was returned with the same id [trade_id:%d, price:%d, amount:%d, other price:%d, other amount:%d]",
+	" ほか%d件": " and %d more",
+	"注文のIDが前に出した注文より小さくなっています [order_id:%d, user_id:%d, previous order_id:%d, previous user_id:%d]":                           "the order id is smaller than an earlier order [order_id:%d, user_id:%d, previous order_id:%d, previous user_id:%d]",
+	"注文のIDが重複しています [order_id:%d, user_id:%d, other user_id:%d]":                                                               "duplicated order id [order_id:%d, user_id:%d, other user_id:%d]",
+	"GET %s 同じIDで別の注文が返されました [order_id:%d, user_id:%d, other user_id:%d]":                                                     "GET %s another order was returned with the same id [order_id:%d, user_id:%d, other user_id:%d]",
+	"GET /info chart_by_sec が最新の成約を反映していません [trade:%d, time:%s, latest:%s]":                                                   "GET /info chart_by_sec does not reflect the latest trade [trade:%d, time:%s, latest:%s]",
+	"売り注文と買い注文が交差したまま%s成約がありませんでした [sell order_id:%d, price:%d, user_id:%d, buy order_id:%d, price:%d, user_id:%d, since:%s]": "no trade for %s while sell and buy orders crossed [sell order_id:%d, price:%d, user_id:%d, buy order_id:%d, price:%d, user_id:%d, since:%s]",
+	"成約が価格優先・時間優先になっていません [trade_id:%d, order_id:%d, price:%d, skipped order_id:%d, skipped price:%d]":                        "the trade does not follow price-time priority [trade_id:%d, order_id:%d, price:%d, skipped order_id:%d, skipped price:%d]",
+	"GET %s 成約がWebSocketで通知されていません [trade_id:%d]":                                                                             "GET %s the trade was not notified by WebSocket [trade_id:%d]",
+	"GET %s WebSocketで通知された成約が一致しません [trade_id:%d, price:%d, amount:%d, pushed price:%d, pushed amount:%d]":                   "GET %s the trade notified by WebSocket does not match [trade_id:%d, price:%d, amount:%d, pushed price:%d, pushed amount:%d]",
+	"isulogに不正な形式のログがあります [user:%d]":                                                                                          "isulog has a malformed log [user:%d]",
+
+	// tester
+	"TLS証明書の検証に失敗しました":                                                 "failed to verify the TLS certificate",
+	"TLS証明書の有効期限が切れています [%s]":                                          "the TLS certificate has expired [%s]",
+	"GET %s コネクションが使い回されていません. keep-aliveが無効になっている可能性があります":            "GET %s connections are not reused. keep-alive may be disabled",
+	"GET %s 静的ファイルが取得できません":                                            "GET %s cannot fetch the static file",
+	"GET %s 静的ファイルが変更されています [size:%d, sha256:%s]":                      "GET %s the static file is modified [size:%d, sha256:%s]",
+	"%s 不正なリクエストが成功しました":                                               "%s an invalid request succeeded",
+	"%s 不正なリクエストに対するstatuscodeが正しくありません [%d]":                          "%s wrong status code for an invalid request [%d]",
+	"%s に失敗しました":                                                       "%s failed",
+	"%s SQL injection の可能性があります. 不正な入力でリクエストが成功しました":                   "%s possible SQL injection. a request with invalid input succeeded",
+	"%s 不正な入力でサーバーエラーになりました [%d]":                                      "%s invalid input caused a server error [%d]",
+	"DELETE %s SQL injection の可能性があります [%d]":                           "DELETE %s possible SQL injection [%d]",
+	"DELETE %s 不正な入力でサーバーエラーになりました [%d]":                               "DELETE %s invalid input caused a server error [%d]",
+	"GET /info?cursor=%s 不正な入力でサーバーエラーになりました [%d]":                     "GET /info?cursor=%s invalid input caused a server error [%d]",
+	"GET /orders XSS の可能性があります. 名前を含むレスポンスのContent-Typeが正しくありません [%s]": "GET /orders possible XSS. wrong Content-Type of the response containing the name [%s]",
+	"GET /info ゲストユーザーのtraded_ordersが設定されています":                         "GET /info traded_orders is set for a guest user",
+	"GET /info highest_buy_price と lowest_sell_price の関係が取引可能状態です":     "GET /info highest_buy_price and lowest_sell_price can be traded",
+	"GET /info chart_by_sec の件数が初期データよりも少なくなっています":                     "GET /info chart_by_sec has fewer items than the initial data",
+	"GET /info chart_by_min の件数が初期データよりも少なくなっています":                     "GET /info chart_by_min has fewer items than the initial data",
+	"GET /info chart_by_hour の件数が初期データよりも少なくなっています":                    "GET /info chart_by_hour has fewer items than the initial data",
+	"POST /signin 存在しないアカウントでログインに成功しました":                              "POST /signin signed in with an account that does not exist",
+	"POST /signin 失敗時のstatuscodeが正しくありません [%d]":                        "POST /signin wrong status code on failure [%d]",
+	"POST /signin に失敗しました":                                             "POST /signin failed",
+	"GET /info traded_ordersの件数が少ないです user:%d, got: %d, expected: %d":  "GET /info too few traded_orders user:%d, got: %d, expected: %d",
+	"GET /orders 件数があいません user:%d, got: %d, expected: %d":              "GET /orders wrong number of orders user:%d, got: %d, expected: %d",
+	"GET /orders trade が正しく設定されていない可能性があります":                           "GET /orders trade may not be set correctly",
+	"POST /signup 銀行に存在しないアカウントサインアップに成功しました。アカウントチェックを指定ない可能性があります":   "POST /signup signed up with an account that does not exist in the bank. the account may not be checked",
+	"POST /signup statuscodeが正しくありません [%d]":                            "POST /signup wrong status code [%d]",
+	"POST /signup に失敗しました":                                             "POST /signup failed",
+	"POST /signup 重複アカウントでのサインアップに成功しました":                              "POST /signup signed up with a duplicated account",
+	"POST /orders 銀行に残高が足りない買い注文に成功しました [order_id:%d]":                 "POST /orders a buy order without enough credit in the bank succeeded [order_id:%d]",
+	"POST /orders statuscodeが正しくありません [%d]":                            "POST /orders wrong status code [%d]",
+	"POST /orders に失敗しました":                                             "POST /orders failed",
+	"GET /orders 件数が正しくありません[got:%d, want:%d]":                         "GET /orders wrong number of orders [got:%d, want:%d]",
+	"GET /orders IDが正しくありません[got:%d, want:%d]":                         "GET /orders wrong ID [got:%d, want:%d]",
+	"GET /orders Priceが正しくありません[got:%d, want:%d]":                      "GET /orders wrong Price [got:%d, want:%d]",
+	"GET /orders Amountが正しくありません[got:%d, want:%d]":                     "GET /orders wrong Amount [got:%d, want:%d]",
+	"GET /orders Typeが正しくありません[got:%s, want:%s]":                       "GET /orders wrong Type [got:%s, want:%s]",
+	"POST /orders %sに失敗しました [amount:%d, price:%d]":                     "POST /orders %s failed [amount:%d, price:%d]",
+	"GET /orders %sが反映されていません got: %d, want: %d":                       "GET /orders %s is not reflected got: %d, want: %d",
+	"買い注文": "buy order",
+	"売り注文": "sell order",
+	"成立すべき取引が成立しませんでした(c1) [user:%d]":                                          "a trade that should be made was not made (c1) [user:%d]",
+	"成立すべき取引が成立しませんでした(c2)":                                                    "a trade that should be made was not made (c2)",
+	"GET /orders 件数があいません [got:%d, want:%d]":                                   "GET /orders wrong number of orders [got:%d, want:%d]",
+	"GET /orders 成立した注文のtradeが設定されていません":                                       "GET /orders trade is not set for a traded order",
+	"銀行残高があいません [%d]":                                                          "the bank credit does not match [%d]",
+	"[INFO] 残高チェック OK(c1)":                                                     "[INFO] credit check OK(c1)",
+	"[INFO] 残高チェック OK(c2)":                                                     "[INFO] credit check OK(c2)",
+	"ログが送信されていません(c1)":                                                         "logs are not sent (c1)",
+	"ログが送信されていません(c2)":                                                         "logs are not sent (c2)",
+	"log.signup のnameが正しくありません":                                                "log.signup has a wrong name",
+	"log.signup のbank_idが正しくありません":                                             "log.signup has a wrong bank_id",
+	"log.buy.errorが正しくありません":                                                   "log.buy.error is wrong",
+	"[INFO] ログチェック OK(c1)":                                                     "[INFO] log check OK(c1)",
+	"[INFO] ログチェック OK(c2)":                                                     "[INFO] log check OK(c2)",
+	"[INFO] 取引テストFinish":                                                       "[INFO] trade test finished",
+	"ユーザーが全滅しています":                                                             "all users have retired",
+	"取引に成功したユーザーが全滅しているか、一人もいません":                                              "all users who traded have retired, or no user traded",
+	"外部サービスの障害から復旧した後に成立した取引がありません":                                            "no trade was made after the external services recovered",
+	"ログが欠損しています [trade:%d]":                                                    "logs are missing [trade:%d]",
+	"[INFO] 取引ログチェックOK [trade:%d]":                                             "[INFO] trade log check OK [trade:%d]",
+	"処理がおそすぎてチェックの準備が整いませんでした[user:%d]":                                        "too slow to prepare the check [user:%d]",
+	"[DEBUG] 銀行残高があいません [user:%d,bank:%s,bankCredit:%d,benchCredit:%d]":        "[DEBUG] the bank credit does not match [user:%d,bank:%s,bankCredit:%d,benchCredit:%d]",
+	"銀行残高があいません[user:%d]":                                                      "the bank credit does not match [user:%d]",
+	"ISUBANK APIとの通信に失敗しました":                                                   "failed to communicate with the ISUBANK API",
+	"[INFO] 残高チェックOK (point1) [user:%d]":                                       "[INFO] credit check OK (point1) [user:%d]",
+	"[INFO] 残高チェックOK (point2) [user:%d]":                                       "[INFO] credit check OK (point2) [user:%d]",
+	"ログが欠損しています [user:%d, missing:%s]":                                         "logs are missing [user:%d, missing:%s]",
+	"ベンチが行っていない操作のログがあります [user:%d, logs:%s]":                                  "there are logs of operations the bench did not make [user:%d, logs:%s]",
+	"[INFO] ユーザーログチェックOK [user:%d]":                                            "[INFO] user log check OK [user:%d]",
+	"チャートの取得に失敗しました":                                                           "failed to fetch the chart",
+	"[INFO] チャートチェックOK [trades:%d]":                                            "[INFO] chart check OK [trades:%d]",
+	"GET %s cursor が戻っています [cursor:%d, expected:%d]":                           "GET %s cursor moved back [cursor:%d, expected:%d]",
+	"GET %s cursor 以前の成約が含まれています [order:%d]":                                   "GET %s contains a trade before the cursor [order:%d]",
+	"GET %s cursor より後の成約が含まれていません [order:%d, trade:%d]":                       "GET %s does not contain a trade after the cursor [order:%d, trade:%d]",
+	"[INFO] cursorチェックOK [cursors:%v]":                                         "[INFO] cursor check OK [cursors:%v]",
+	"GET %s chart_by_sec が cursor なしのときと一致しません [time:%s]":                      "GET %s chart_by_sec does not match the one without cursor [time:%s]",
+	"GET /info %s の時刻が単位で区切られていません [time:%s]":                                  "GET /info %s time is not truncated to the unit [time:%s]",
+	"GET /info %s が時刻順になっていません [time:%s]":                                      "GET /info %s is not sorted by time [time:%s]",
+	"GET /info %s の値が不正です [time:%s, open:%d, close:%d, high:%d, low:%d]":       "GET /info %s has invalid values [time:%s, open:%d, close:%d, high:%d, low:%d]",
+	"GET /info %s に成約が反映されていません [trade:%d, time:%s]":                           "GET /info %s does not reflect the trade [trade:%d, time:%s]",
+	"GET /info %s の集計が正しくありません [trade:%d, price:%d, time:%s, high:%d, low:%d]": "GET /info %s is aggregated wrongly [trade:%d, price:%d, time:%s, high:%d, low:%d]",
+	"[DEBUG] 入出金履歴の件数があいません [user:%d, bank:%s, trades:%d, history:%d]":         "[DEBUG] the number of bank history does not match [user:%d, bank:%s, trades:%d, history:%d]",
+	"銀行の入出金履歴が成約と一致しません[user:%d]":                                              "the bank history does not match the trades [user:%d]",
+	"[DEBUG] 入出金履歴の金額があいません [user:%d, bank:%s, trade:%d, history:%d]":          "[DEBUG] the amount of bank history does not match [user:%d, bank:%s, trade:%d, history:%d]",
+	"[INFO] 入出金履歴チェックOK [user:%d]":                                             "[INFO] bank history check OK [user:%d]",
+	"%s[%d件]": "%s[%d]",
+	"isubankのapp_idでisulogにログが送られています [user:%d]": "logs are sent to isulog with the app_id of isubank [user:%d]",
+	"%s ...他%d件": "%s ... and %d more",
+	"(古いログ %d 行を省略しました)": "(%d older log lines were dropped)",
+}
+
+// msgPattern は書式から作った、書式どおりに埋めた文字列にマッチする正規表現
+type msgPattern struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+var verbPattern = regexp.MustCompile(`%[-+# 0]*[0-9]*(\.[0-9]+)?[a-zA-Z%]`)
+
+// lang は SetLang で選んだ言語の訳. nil なら日本語のまま出す
+var lang []msgPattern
+
+// SetLang はベンチが出すメッセージの言語を ja か en にする. 負荷走行を始める前に呼ぶ
+func SetLang(l string) error {
+	switch l {
+	case "", LangJa:
+		lang = nil
+	case LangEn:
+		lang = compileMessages(messagesEn)
+	default:
+		return errors.Errorf("unknown lang %s", l)
+	}
+	return nil
+}
+
+func compileMessages(messages map[string]string) []msgPattern {
+	keys := make([]string, 0, len(messages))
+	for k := range messages {
+		keys = append(keys, k)
+	}
+	// 長い書式を先に当てて、"%s に失敗しました" のような短い書式が一部だけを訳さないようにする
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	r := make([]msgPattern, 0, len(keys))
+	for _, k := range keys {
+		r = append(r, compileMessage(k, messages[k]))
+	}
+	return r
+}
+
+func compileMessage(format, translated string) msgPattern {
+	re := &strings.Builder{}
+	rest := format
+	for _, loc := range verbPattern.FindAllStringIndex(format, -1) {
+		offset := len(format) - len(rest)
+		re.WriteString(regexp.QuoteMeta(rest[:loc[0]-offset]))
+		verb := rest[loc[0]-offset : loc[1]-offset]
+		rest = rest[loc[1]-offset:]
+		switch v := verb[len(verb)-1]; {
+		case v == '%':
+			re.WriteString("%")
+		case v == 'd':
+			re.WriteString(`(-?[0-9]+)`)
+		case v == 'f':
+			re.WriteString(`(-?[0-9.]+)`)
+		case rest == "":
+			re.WriteString(`(.*)`)
+		default:
+			re.WriteString(`(.*?)`)
+		}
+	}
+	re.WriteString(regexp.QuoteMeta(rest))
+
+	repl := &strings.Builder{}
+	n := 0
+	rest = translated
+	for _, loc := range verbPattern.FindAllStringIndex(translated, -1) {
+		offset := len(translated) - len(rest)
+		repl.WriteString(strings.Replace(rest[:loc[0]-offset], "$", "$$", -1))
+		verb := rest[loc[0]-offset : loc[1]-offset]
+		rest = rest[loc[1]-offset:]
+		if verb == "%%" {
+			repl.WriteString("%")
+			continue
+		}
+		n++
+		repl.WriteString("${" + strconv.Itoa(n) + "}")
+	}
+	repl.WriteString(strings.Replace(rest, "$", "$$", -1))
+	return msgPattern{re: regexp.MustCompile(re.String()), repl: repl.String()}
+}
+
+// Translate は s に含まれるベンチのメッセージを SetLang で選んだ言語にする
+func Translate(s string) string {
+	for _, p := range lang {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+type translateWriter struct {
+	out io.Writer
+}
+
+func (w *translateWriter) Write(p []byte) (int, error) {
+	if lang == nil {
+		return w.out.Write(p)
+	}
+	if _, err := io.WriteString(w.out, Translate(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// TranslateWriter は書き込まれたメッセージを SetLang で選んだ言語にして out に書く
+func TranslateWriter(out io.Writer) io.Writer {
+	return &translateWriter{out}
+}
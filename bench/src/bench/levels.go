@@ -0,0 +1,127 @@
+package bench
+
+import (
+	"sync"
+	"time"
+
+	"bench/portal"
+)
+
+// levelSnapshot はある時点での累計値
+type levelSnapshot struct {
+	at       time.Time
+	score    int64
+	errors   int
+	users    int
+	requests int64
+	elapsed  time.Duration
+}
+
+// levelHistory はlevelごとに増えたスコアやエラーを記録する
+type levelHistory struct {
+	mu      sync.Mutex
+	records []portal.LevelResult
+	level   uint
+	from    *levelSnapshot
+}
+
+// enter は level に上がったところで、それまでのlevelの記録を閉じる
+func (h *levelHistory) enter(level uint, now levelSnapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.close(now)
+	h.level = level
+	h.from = &now
+}
+
+// finish は負荷走行が終わったところで今のlevelの記録を閉じる
+func (h *levelHistory) finish(now levelSnapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.close(now)
+	h.from = nil
+}
+
+// Results は今のlevelも含めたlevelごとの記録
+func (h *levelHistory) Results(now levelSnapshot) []portal.LevelResult {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r := make([]portal.LevelResult, len(h.records), len(h.records)+1)
+	copy(r, h.records)
+	if h.from != nil {
+		r = append(r, h.result(now))
+	}
+	return r
+}
+
+func (h *levelHistory) close(now levelSnapshot) {
+	if h.from == nil {
+		return
+	}
+	h.records = append(h.records, h.result(now))
+}
+
+func (h *levelHistory) result(now levelSnapshot) portal.LevelResult {
+	from := h.from
+	lr := portal.LevelResult{
+		Level:    int(h.level),
+		Duration: now.at.Sub(from.at).Seconds(),
+		Score:    now.score - from.score,
+		Errors:   now.errors - from.errors,
+		Users:    now.users - from.users,
+		Requests: now.requests - from.requests,
+	}
+	if lr.Requests > 0 {
+		lr.AvgLatency = (now.elapsed - from.elapsed).Seconds() / float64(lr.Requests)
+	}
+	return lr
+}
+
+func (c *Manager) levelSnapshot() levelSnapshot {
+	requests, elapsed := c.metrics.totals()
+	return levelSnapshot{
+		at:       time.Now(),
+		score:    c.GetScore(),
+		errors:   c.ErrorCount(),
+		users:    c.AllUsers(),
+		requests: requests,
+		elapsed:  elapsed,
+	}
+}
+
+// levelUp はlevelを1つ上げて、上がる前のlevelの記録を残す
+func (c *Manager) levelUp() {
+	level := c.GetLevel() + 1
+	c.setLevel(level)
+	c.retire.setLevel(level)
+	c.levelErrors = c.ErrorCount()
+	c.levels.enter(level, c.levelSnapshot())
+}
+
+// canLevelUp は score で次のlevelに上がれるか. エラーが多い間はlevelを止める
+func (c *Manager) canLevelUp(score int64) bool {
+	lc := c.conf.Level
+	level := c.GetLevel()
+	if lc.Max > 0 && level >= lc.Max {
+		return false
+	}
+	if score < lc.threshold(level) {
+		return false
+	}
+	limit := lc.FreezeErrors
+	if limit < 0 {
+		limit = c.conf.Error.AllowMin
+	}
+	switch lc.Freeze {
+	case "total":
+		return c.ErrorCount() <= limit
+	case "per_level":
+		return c.ErrorCount()-c.levelErrors <= limit
+	}
+	return true
+}
+
+// LevelResults はlevelごとの記録
+func (c *Manager) LevelResults() []portal.LevelResult {
+	return c.levels.Results(c.levelSnapshot())
+}
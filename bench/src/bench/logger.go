@@ -1,10 +1,16 @@
 package bench
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 type timeWriter struct {
@@ -25,5 +31,155 @@ func (w *timeWriter) Write(p []byte) (n int, err error) {
 }
 
 func NewLogger(out io.Writer) *log.Logger {
-	return log.New(&timeWriter{out, time.Now()}, "", log.LstdFlags|log.Lmicroseconds)
+	return log.New(&timeWriter{TranslateWriter(out), time.Now()}, "", log.LstdFlags|log.Lmicroseconds)
+}
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// parseLogLevel は debug, info, warn, error をslogのレベルにする. 空なら info
+func parseLogLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, errors.Errorf("unknown log level %s", s)
+	}
+	return l, nil
+}
+
+// textHandler はslogのレコードを NewLogger と同じ "[経過秒] 日時 メッセージ" の形式で書く
+// component 以外の項目は key=value でメッセージの後ろに付ける
+type textHandler struct {
+	mu    *sync.Mutex
+	out   io.Writer
+	start time.Time
+	level slog.Leveler
+	attrs []slog.Attr
+}
+
+func newTextHandler(out io.Writer, level slog.Leveler) *textHandler {
+	return &textHandler{mu: &sync.Mutex{}, out: out, start: time.Now(), level: level}
+}
+
+func (h *textHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "[%.5f] %s %s", r.Time.Sub(h.start).Seconds(), r.Time.Format("2006/01/02 15:04:05.000000"), r.Message)
+	write := func(a slog.Attr) bool {
+		if a.Key != "component" {
+			fmt.Fprintf(b, " %s=%v", a.Key, a.Value)
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		write(a)
+	}
+	r.Attrs(write)
+	b.WriteString("\n")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.out, b.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	nh := *h
+	nh.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &nh
+}
+
+// group はメッセージの後ろに付けるだけなので区別しない
+func (h *textHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// benchHandler は "[WARN] ..." のような接頭辞からレベルを決め、メッセージを SetLang の言語にして handlers に渡す
+// *log.Logger の Printf から来たレコードもレベルで分けられるようにする
+type benchHandler struct {
+	handlers []slog.Handler
+}
+
+var logLevelPrefixes = []struct {
+	prefix string
+	level  slog.Level
+}{
+	{"[DEBUG]", slog.LevelDebug},
+	{"[INFO]", slog.LevelInfo},
+	{"[WARN]", slog.LevelWarn},
+	{"[ERROR]", slog.LevelError},
+}
+
+func (h *benchHandler) Enabled(context.Context, slog.Level) bool {
+	// 接頭辞でレベルが変わるので Handle で選ぶ
+	return true
+}
+
+func (h *benchHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level == slog.LevelInfo {
+		for _, p := range logLevelPrefixes {
+			if strings.HasPrefix(r.Message, p.prefix) {
+				r.Level = p.level
+				break
+			}
+		}
+	}
+	r.Message = Translate(r.Message)
+	var rerr error
+	for _, hh := range h.handlers {
+		if !hh.Enabled(ctx, r.Level) {
+			continue
+		}
+		if err := hh.Handle(ctx, r.Clone()); err != nil {
+			rerr = err
+		}
+	}
+	return rerr
+}
+
+func (h *benchHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	nh := &benchHandler{handlers: make([]slog.Handler, len(h.handlers))}
+	for i, hh := range h.handlers {
+		nh.handlers[i] = hh.WithAttrs(attrs)
+	}
+	return nh
+}
+
+func (h *benchHandler) WithGroup(name string) slog.Handler {
+	nh := &benchHandler{handlers: make([]slog.Handler, len(h.handlers))}
+	for i, hh := range h.handlers {
+		nh.handlers[i] = hh.WithGroup(name)
+	}
+	return nh
+}
+
+// NewSlogHandler は Manager と同じように、接頭辞でレベルを決めて訳したメッセージを format の形式で out に書く
+func NewSlogHandler(out io.Writer, format, level string) (slog.Handler, error) {
+	l, err := parseLogLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	h, err := newSlogHandler(out, format, l)
+	if err != nil {
+		return nil, err
+	}
+	return &benchHandler{handlers: []slog.Handler{h}}, nil
+}
+
+// newSlogHandler は format の形式で level 以上を out に書く handler を作る
+func newSlogHandler(out io.Writer, format string, level slog.Level) (slog.Handler, error) {
+	switch format {
+	case "", LogFormatText:
+		return newTextHandler(out, level), nil
+	case LogFormatJSON:
+		return slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level}), nil
+	default:
+		return nil, errors.Errorf("unknown log format %s", format)
+	}
 }
@@ -0,0 +1,105 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// logRing は最後に書かれた size バイト分のログの行だけを残す
+// 長時間の負荷走行で投資家が大量にログを出してもベンチのメモリを使い切らないように、古い行から捨てる
+type logRing struct {
+	mu      sync.Mutex
+	size    int
+	lines   []string // lines[head:] が残っている行
+	head    int
+	bytes   int
+	dropped int
+	subs    map[chan string]struct{}
+}
+
+func newLogRing(size int) *logRing {
+	return &logRing{size: size, lines: make([]string, 0, 1024)}
+}
+
+func (r *logRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		r.lines = append(r.lines, line)
+		r.bytes += len(line) + 1
+		for ch := range r.subs {
+			select {
+			case ch <- line:
+			default:
+			}
+		}
+	}
+	for r.bytes > r.size && r.head < len(r.lines) {
+		r.bytes -= len(r.lines[r.head]) + 1
+		r.lines[r.head] = ""
+		r.head++
+		r.dropped++
+	}
+	// 捨てた行の分が溜まったら詰め直す
+	if r.head > 1024 && r.head > len(r.lines)/2 {
+		r.lines = append(make([]string, 0, cap(r.lines)), r.lines[r.head:]...)
+		r.head = 0
+	}
+	return len(p), nil
+}
+
+// Lines は残っている行を返す. 捨てた行があれば最初にその数を入れる
+func (r *logRing) Lines() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.snapshot()
+}
+
+func (r *logRing) snapshot() []string {
+	lines := make([]string, 0, len(r.lines)-r.head+1)
+	if r.dropped > 0 {
+		lines = append(lines, Translate(fmt.Sprintf("(古いログ %d 行を省略しました)", r.dropped)))
+	}
+	return append(lines, r.lines[r.head:]...)
+}
+
+// subscribe は残っている行と、以降に書かれた行を受け取るchannelを返す
+// 読むのが遅くてchannelが詰まっている間に書かれた行は送らない
+func (r *logRing) subscribe() ([]string, chan string, func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lines := r.snapshot()
+	if r.subs == nil {
+		r.subs = map[chan string]struct{}{}
+	}
+	ch := make(chan string, 1024)
+	r.subs[ch] = struct{}{}
+	return lines, ch, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.subs, ch)
+	}
+}
+
+// FollowLogs は結果に残るログを今までの分から順に fn に渡し、ctx が終わるまで新しい行を渡し続ける
+func (c *Manager) FollowLogs(ctx context.Context, fn func(line string) error) error {
+	lines, ch, cancel := c.logs.subscribe()
+	defer cancel()
+	for _, line := range lines {
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case line := <-ch:
+			if err := fn(line); err != nil {
+				return err
+			}
+		}
+	}
+}
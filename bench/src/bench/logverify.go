@@ -0,0 +1,129 @@
+package bench
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+
+	"bench/isulog"
+	"bench/portal"
+
+	"github.com/pkg/errors"
+)
+
+type orderSnapshotter interface {
+	UserID() int64
+	Ignore() bool
+	snapshotOrders() []*Order
+}
+
+// runLogVerifier は負荷走行中に LogVerifyInterval ごとにユーザーを選んでisulogを確認する
+// 事後テストを待たずにログの欠損や不正な形式のログをエラーにする
+func (c *Manager) runLogVerifier(ctx context.Context) {
+	delay := time.Duration(c.conf.LogAllowedDelay) * time.Second
+	reported := map[int64]map[string]bool{}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(LogVerifyInterval):
+		}
+		for _, u := range c.pickLogVerifyUsers(LogVerifyUsers) {
+			if ctx.Err() != nil {
+				return
+			}
+			logs, err := c.isulog.GetUserLogs(ctx, u.UserID())
+			if err != nil {
+				if _, ok := err.(*isulog.InvalidLogError); ok {
+					c.appendLogError(errors.Wrapf(err, "isulogに不正な形式のログがあります [user:%d]", u.UserID()))
+				} else {
+					log.Printf("[WARN] isulog get user logs failed. err: %s", err)
+				}
+				continue
+			}
+			// 反映の遅延を許す時間が過ぎたものだけを調べる
+			orders := settledOrders(u.snapshotOrders(), time.Now().Add(-delay))
+			if len(orders) == 0 {
+				continue
+			}
+			seen := reported[u.UserID()]
+			if seen == nil {
+				seen = map[string]bool{}
+				reported[u.UserID()] = seen
+			}
+			missing := []string{}
+			for _, k := range missingLogs(orders, logs) {
+				if !seen[k] {
+					seen[k] = true
+					missing = append(missing, k)
+				}
+			}
+			if len(missing) > 0 {
+				c.appendLogError(errors.Errorf("ログが欠損しています [user:%d, missing:%s]", u.UserID(), summarizeMissing(missing)))
+			}
+		}
+	}
+}
+
+// fetchLogUsage は負荷走行の間にアプリがsend, send_bulkをどれだけ使ったかをisulogに問い合わせる
+func (c *Manager) fetchLogUsage(ctx context.Context) {
+	st, err := c.isulog.GetStats(ctx)
+	if err != nil {
+		log.Printf("[WARN] isulog get stats failed. err: %s", err)
+		return
+	}
+	u := portal.LogUsage{Send: st.Send, SendBulk: st.SendBulk, BulkLogs: st.BulkLogs}
+	if n := st.Send + st.BulkLogs; n > 0 {
+		u.BulkRatio = float64(st.BulkLogs) / float64(n)
+	}
+	c.logUsage = u
+	c.Logger().Printf("isulog: send %d, send_bulk %d (%d logs), bulk ratio %.1f%%", u.Send, u.SendBulk, u.BulkLogs, u.BulkRatio*100)
+}
+
+func (c *Manager) appendLogError(err error) {
+	c.Logger().Printf("%s", err)
+	if e := c.AppendError(err); e != nil {
+		log.Printf("[WARN] %s", e)
+	}
+}
+
+// pickLogVerifyUsers はログインしている退役していないユーザーから n 人を選ぶ
+func (c *Manager) pickLogVerifyUsers(n int) []orderSnapshotter {
+	c.scenarioLock.Lock()
+	defer c.scenarioLock.Unlock()
+	users := []orderSnapshotter{}
+	for _, i := range rand.Perm(len(c.scenarios)) {
+		if len(users) >= n {
+			break
+		}
+		sc := c.scenarios[i]
+		if sc.IsRetired() || !sc.IsSignin() {
+			continue
+		}
+		if u, ok := sc.(orderSnapshotter); ok && u.UserID() > 0 && !u.Ignore() {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// settledOrders は before より前の操作だけが反映された注文を返す
+// それより後に成約やキャンセルされた注文はまだ注文中として扱う
+func settledOrders(orders []*Order, before time.Time) []*Order {
+	settled := make([]*Order, 0, len(orders))
+	for _, o := range orders {
+		if !o.CreatedAt.Before(before) {
+			continue
+		}
+		closed := o.ClosedAt
+		if closed == nil && o.Trade != nil {
+			closed = &o.Trade.CreatedAt
+		}
+		if closed != nil && !closed.Before(before) {
+			o.ClosedAt, o.TradeID = nil, 0
+		}
+		settled = append(settled, o)
+	}
+	return settled
+}
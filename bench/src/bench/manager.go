@@ -1,58 +1,146 @@
 package bench
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
 	"io"
 	"log"
+	"log/slog"
 	"math/rand"
 	"os"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"bench/isubank"
 	"bench/isulog"
+	"bench/portal"
 	"github.com/pkg/errors"
 )
 
 type Manager struct {
-	logger    *log.Logger
-	appep     string
+	logger    *log.Logger  // slogger に Printf で書く. component は bench
+	reqLogger *log.Logger  // logs に残さないログ
+	slogger   *slog.Logger // logs と out に書く構造化ログ
+	appep     string       // 初期化とテストに使うエンドポイント (appeps の最初)
+	appeps    []string
+	appepNext uint32
 	bankep    string
 	logep     string
 	rand      *Random
 	isubank   *isubank.Isubank
 	isulog    *isulog.Isulog
-	idlist    chan string
+	idpool    *idPool
+	idfail    idFailures
 	scenarios []Scenario
 	score     int64
 	errors    []error
-	logs      *bytes.Buffer
+	errorsBy  map[ErrorCategory]int
+	errGroups *errorGroups
+	logs      *logRing
+	conf      *Config
+	metrics   *Metrics
+	smchan    chan ScoreMsg
+	trades    *TradeWatcher
+	ids       *idRegistry
+	market    *Market
+
+	campaigns    map[int64]bool // 告知されたキャンペーン
+	lastCampaign time.Time      // 最後にキャンペーンを受け付けた時刻
 
 	errorLock    sync.Mutex
 	scenarioLock sync.Mutex
-	level        uint
+	level        uint32 // 計測中にも読まれるので atomic で読み書きする
+	levelErrors  int    // 最後にlevelupしたときのエラー数
+	warmUpEnd    int64  // UnixNano. 負荷走行が始まるときに決まる
 	overError    bool
 
 	scounter   int32
 	scoreboard *ScoreBoard
 	testusers  []TestUser
 	statefile  string
+
+	internalbank  string
+	internallog   string
+	resumeUsers   []CheckpointUser
+	bruteAccounts []string
+	resumed       bool
+
+	resumedElapsed time.Duration // 中断するまでに負荷走行を進めた時間
+
+	planPhase int32
+	pause     *pauseGate
+	limiter   *rateLimiter
+	activity  *activityLog
+	har       *harRecorder
+	reqlog    *requestLog
+	slow      *slowRequests
+	workload  *workloadRecorder
+	replay    *Workload
+	levels    levelHistory
+	retire    *retirePolicy
+	retirees  *retireStats
+	shares    shareLog
+
+	timelineLock sync.Mutex
+	timeline     []Snapshot
+	checks       *CheckRecorder
+	bankFault    isubank.Fault
+	chaosLock    sync.Mutex
+	chaosWindows []chaosWindow
+	logUsage     portal.LogUsage
+	scoreModel   ScoreModel
+
+	phaseLock  sync.Mutex
+	phase      string
+	benchStart time.Time
+	benchEnd   time.Time // 一時停止する前の負荷走行を終える時刻
+	benchStop  time.Time
+	agents     agentState
+
+	scenarioByBankID map[string]Scenario
+	scenarioByName   map[string]Scenario
+	purged           int // scenarios から取り除いた退役済みユーザーの数
+	purgeCursor      int
+	starting         int  // 開始中でまだ scenarios に入っていないユーザー数
+	capped           bool // investor.max に達したことを表示したか
 }
 
-func NewManager(out io.Writer, appep, bankep, logep, internalbank, internallog string, statefile string) (*Manager, error) {
-	rnd, err := NewRandom()
+func NewManager(out io.Writer, appep, bankep, logep, internalbank, internallog string, statefile string, conf *Config) (*Manager, error) {
+	if conf == nil {
+		conf = DefaultConfig()
+	}
+	appeps := splitEndpoints(appep)
+	if len(appeps) == 0 {
+		return nil, errors.New("app endpoint is empty")
+	}
+	if err := conf.Client.prepare(); err != nil {
+		return nil, err
+	}
+	if err := conf.Investor.prepare(); err != nil {
+		return nil, err
+	}
+	model, err := lookupScoreModel(conf.ScoreModel)
 	if err != nil {
 		return nil, err
 	}
-	bank, err := isubank.NewIsubank(internalbank, rnd.ID())
+	if conf.Plan == nil && conf.Profile != "" {
+		plan, err := ProfilePlan(conf.Profile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Plan = plan
+	}
+	rnd, err := NewRandom(conf.Seed)
 	if err != nil {
 		return nil, err
 	}
-	isulog, err := isulog.NewIsulog(internallog, rnd.ID())
+	bank, err := isubank.NewIsubank(internalbank, rnd.AppID())
+	if err != nil {
+		return nil, err
+	}
+	isulog, err := isulog.NewIsulog(internallog, rnd.AppID())
 	if err != nil {
 		return nil, err
 	}
@@ -65,46 +153,162 @@ func NewManager(out io.Writer, appep, bankep, logep, internalbank, internallog s
 		j := rand.Intn(i + 1)
 		_testusers[i], _testusers[j] = _testusers[j], _testusers[i]
 	}
-	logs := &bytes.Buffer{}
+	logs := newLogRing(conf.LogBufferSize)
+	var capture io.Writer = logs
+	if conf.LogArchive != "" {
+		f, err := os.Create(conf.LogArchive)
+		if err != nil {
+			return nil, errors.Wrap(err, "log archive create failed")
+		}
+		capture = io.MultiWriter(logs, f)
+	}
+	level, err := parseLogLevel(conf.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	outHandler, err := newSlogHandler(out, conf.LogFormat, level)
+	if err != nil {
+		return nil, err
+	}
+	// GetLogs で返すログは -log-format, -log-level によらず今までの形式で全部残す
+	slogger := slog.New(&benchHandler{handlers: []slog.Handler{outHandler, newTextHandler(capture, slog.LevelDebug)}})
+	reqSlogger := slog.New(&benchHandler{handlers: []slog.Handler{outHandler}}).With("component", "request")
+	smchan := make(chan ScoreMsg, 2000)
+	var slow *slowRequests
+	if conf.SlowRequest > 0 {
+		slow = newSlowRequests(time.Duration(conf.SlowRequest) * time.Millisecond)
+	}
+	var trades *TradeWatcher
+	if conf.TradeStreamPath != "" {
+		trades = NewTradeWatcher(smchan)
+	}
 	return &Manager{
-		logger:     NewLogger(io.MultiWriter(out, logs)),
-		appep:      appep,
+		logger:     slog.NewLogLogger(slogger.With("component", "bench").Handler(), slog.LevelInfo),
+		reqLogger:  slog.NewLogLogger(reqSlogger.Handler(), slog.LevelInfo),
+		slogger:    slogger,
+		appep:      appeps[0],
+		appeps:     appeps,
 		bankep:     bankep,
 		logep:      logep,
 		rand:       rnd,
 		isubank:    bank,
 		isulog:     isulog,
-		idlist:     make(chan string, 10),
-		errors:     make([]error, 0, AllowErrorMax+10),
+		idpool:     newIDPool(),
+		errors:     make([]error, 0, conf.Error.AllowMax+10),
+		errorsBy:   make(map[ErrorCategory]int, len(errorCategories)),
+		errGroups:  newErrorGroups(),
 		logs:       logs,
+		conf:       conf,
+		metrics:    NewMetrics(),
+		smchan:     smchan,
+		trades:     trades,
+		ids:        newIDRegistry(),
+		market:     NewMarket(),
+		campaigns:  make(map[int64]bool, CampaignMax),
 		scenarios:  make([]Scenario, 0, 2000),
 		scoreboard: scoreboard,
 		testusers:  _testusers,
 		statefile:  statefile,
+
+		internalbank:  internalbank,
+		internallog:   internallog,
+		bruteAccounts: conf.Investor.accounts,
+
+		pause:  &pauseGate{},
+		checks: &CheckRecorder{},
+		phase:  PhaseWaiting,
+		agents: agentState{reports: make(map[string]*AgentReport)},
+
+		scenarioByBankID: make(map[string]Scenario, 2000),
+		scenarioByName:   make(map[string]Scenario, 2000),
+		limiter:          newRateLimiter(conf.Client.MaxRPS),
+		retire:           newRetirePolicy(conf.Retire, conf.Client.retireTimeout()),
+		retirees:         newRetireStats(),
+		slow:             slow,
+
+		scoreModel: model,
 	}, nil
 }
 
-func (c *Manager) Close() {
+// Close は残っているユーザーのコネクションを閉じる
+// ctx が終わればそこで諦めるので、アプリが応答しなくてもベンチマーカーは止まらない
+func (c *Manager) Close(ctx context.Context) error {
+	c.scenarioLock.Lock()
+	scenarios := make([]Scenario, len(c.scenarios))
+	copy(scenarios, c.scenarios)
+	c.scenarioLock.Unlock()
+	for _, s := range scenarios {
+		if err := ctx.Err(); err != nil {
+			return errors.Wrap(err, "ユーザーの片付けが時間内に終わりませんでした")
+		}
+		if cs, ok := s.(interface{ Client() *Client }); ok {
+			cs.Client().closeIdleConnections()
+		}
+	}
+	return nil
 }
 
 // benchに影響を与えないようにidは予め用意しておく
-func (c *Manager) RunIDFetcher(ctx context.Context) {
+// 貯めておく数は直近の使われ方に合わせて増減する
+// isubankが失敗し続けた場合はエラーを返す
+func (c *Manager) RunIDFetcher(ctx context.Context) error {
+	broken := make(chan error, IDFetchWorkers)
+	for i := 0; i < IDFetchWorkers; i++ {
+		go func() {
+			if err := c.fetchIDs(ctx); err != nil {
+				broken <- err
+			}
+		}()
+	}
 	for {
 		select {
 		case <-ctx.Done():
-			return
-		default:
-			id := c.rand.ID()
-			if err := c.isubank.NewBankID(id); err != nil {
-				log.Printf("new bankid failed. %s", err)
+			return nil
+		case err := <-broken:
+			c.idpool.fail(err)
+			return err
+		case <-time.After(TickerInterval):
+			c.idpool.adjust()
+		}
+	}
+}
+
+func (c *Manager) fetchIDs(ctx context.Context) error {
+	for c.idpool.wait(ctx) {
+		id := c.rand.ID()
+		err := c.isubank.NewBankID(id)
+		if err == isubank.ErrBankIDExists {
+			// 同じseedで前に実行したときに作っていた. 失敗とは数えずに次のを作る
+			log.Printf("[DEBUG] bankid %s already exists", id)
+			continue
+		}
+		if err != nil {
+			n := c.idfail.fail()
+			if n >= IDFetchBreakThreshold {
+				return errors.Wrapf(err, "isubank でbank_idを%d回続けて作成できませんでした. isubank (%s) が動いているか確認してください", n, c.internalbank)
+			}
+			wait := c.idfail.backoff(n)
+			log.Printf("new bankid failed. retry after %s. %s", wait, err)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(wait):
 			}
-			c.idlist <- id
+			continue
 		}
+		c.idfail.succeed()
+		c.idpool.put(id)
 	}
+	return nil
 }
 
-func (c *Manager) FetchNewID() string {
-	return <-c.idlist
+func (c *Manager) FetchNewID() (string, error) {
+	return c.idpool.get()
+}
+
+// IDFetchFailures はbank_idの作成に失敗した回数
+func (c *Manager) IDFetchFailures() int64 {
+	return c.idfail.total()
 }
 
 func (c *Manager) AddScore(score int64) {
@@ -119,17 +323,29 @@ func (c *Manager) AppendError(e error) error {
 	if e == nil {
 		return nil
 	}
+	if c.warmingUp() {
+		c.Logger().Printf("warm-up error (not counted): %s", e)
+		return nil
+	}
 	c.errorLock.Lock()
 	defer c.errorLock.Unlock()
 
 	c.errors = append(c.errors, e)
 	ec := len(c.errors)
+	c.errGroups.add(e, time.Now())
+
+	cat := categorizeError(e)
+	c.errorsBy[cat]++
+	if max, ok := c.conf.Error.CategoryMax[cat.String()]; ok && max <= c.errorsBy[cat] {
+		c.overError = true
+		return errors.Errorf("エラー件数が規定を超過しました. (%s)", cat)
+	}
 
-	errorLimit := c.GetScore() / 500
-	if errorLimit < AllowErrorMin {
-		errorLimit = AllowErrorMin
-	} else if errorLimit > AllowErrorMax {
-		errorLimit = AllowErrorMax
+	errorLimit := (c.GetScore() + c.AgentScore()) / c.conf.Error.LimitDivisor
+	if min := int64(c.conf.Error.AllowMin); errorLimit < min {
+		errorLimit = min
+	} else if max := int64(c.conf.Error.AllowMax); errorLimit > max {
+		errorLimit = max
 	}
 	if errorLimit <= int64(ec) {
 		c.overError = true
@@ -144,24 +360,28 @@ func (c *Manager) ErrorCount() int {
 	return len(c.errors)
 }
 
+func (c *Manager) ErrorCountByCategory() map[string]int {
+	c.errorLock.Lock()
+	defer c.errorLock.Unlock()
+	r := make(map[string]int, len(c.errorsBy))
+	for cat, n := range c.errorsBy {
+		r[cat.String()] = n
+	}
+	return r
+}
+
 func (c *Manager) GetErrorsString() []string {
+	c.errorLock.Lock()
+	defer c.errorLock.Unlock()
 	r := make([]string, 0, len(c.errors))
 	for _, e := range c.errors {
-		r = append(r, e.Error())
+		r = append(r, Translate(e.Error()))
 	}
 	return r
 }
 
 func (c *Manager) GetLogs() ([]string, error) {
-	scan := bufio.NewScanner(c.logs)
-	r := []string{}
-	for scan.Scan() {
-		r = append(r, scan.Text())
-	}
-	if err := scan.Err(); err != nil {
-		return nil, err
-	}
-	return r, nil
+	return c.logs.Lines(), nil
 }
 
 func (c *Manager) FinalScore() int64 {
@@ -172,22 +392,46 @@ func (c *Manager) FinalScore() int64 {
 }
 
 func (c *Manager) TotalScore() int64 {
-	score := c.GetScore()
-	demerit := score / (AllowErrorMax * 2)
+	score := c.scoreModel.Total(c.GetScore()+c.AgentScore(), c.ErrorCount(), c.conf.Error)
 
-	// エラーが多いと最大スコアが半分になる
-	return score - demerit*int64(c.ErrorCount())
+	if bonus := c.conf.Score.HTTP2BonusPercent; bonus > 0 && c.metrics.HTTP2Ratio() >= 0.5 {
+		score += score * bonus / 100
+	}
+	if bonus := c.conf.Score.GzipBonusPercent; bonus > 0 && c.metrics.GzipRatio() >= 0.5 {
+		score += score * bonus / 100
+	}
+	if bonus := c.conf.Score.BulkLogBonusPercent; bonus > 0 && c.logUsage.BulkRatio >= 0.5 {
+		score += score * bonus / 100
+	}
+	return score
+}
+
+func (c *Manager) Seed() int64 {
+	return c.rand.Seed()
 }
 
 func (c *Manager) GetLevel() uint {
-	return c.level
+	return uint(atomic.LoadUint32(&c.level))
+}
+
+func (c *Manager) setLevel(level uint) {
+	atomic.StoreUint32(&c.level, uint32(level))
 }
 
 func (c *Manager) AllUsers() int {
-	return len(c.scenarios)
+	c.scenarioLock.Lock()
+	defer c.scenarioLock.Unlock()
+	return len(c.scenarios) + c.purged
 }
 
 func (c *Manager) ActiveUsers() int {
+	c.scenarioLock.Lock()
+	defer c.scenarioLock.Unlock()
+	return c.activeUsers()
+}
+
+// activeUsers は scenarioLock を取った状態で呼ぶ
+func (c *Manager) activeUsers() int {
 	n := 0
 	for _, sc := range c.scenarios {
 		if !sc.IsRetired() {
@@ -201,33 +445,81 @@ func (c *Manager) Logger() *log.Logger {
 	return c.logger
 }
 
+// Slog は component の項目を付けた構造化ログ. 出力先は Logger と同じ
+func (c *Manager) Slog(component string) *slog.Logger {
+	return c.slogger.With("component", component)
+}
+
 func (c *Manager) Initialize(ctx context.Context) error {
 	if err := c.isulog.Initialize(); err != nil {
 		return errors.Wrap(err, "isuloggerの初期化に失敗しました。運営に連絡してください")
 	}
 
-	guest, err := NewClient(c.appep, "", "", "", InitTimeout, InitTimeout)
+	guest, err := NewClient(c.appep, "", "", "", c.conf.Client.initTimeout(), c.conf.Client.initTimeout())
 	if err != nil {
 		return err
 	}
-	if err := guest.Initialize(ctx, c.bankep, c.isubank.AppID(), c.logep, c.isulog.AppID()); err != nil {
-		return err
+	guest.applyConfig(c.conf.Client)
+	// 2回呼んでも同じ状態になること
+	var first *InfoResponse
+	for i := 1; i <= 2; i++ {
+		start := time.Now()
+		if err := guest.Initialize(ctx, c.bankep, c.isubank.AppID(), c.logep, c.isulog.AppID()); err != nil {
+			return errors.Wrapf(err, "POST /initialize (%d回目)", i)
+		}
+		elapsed := time.Since(start)
+		c.Logger().Printf("initialize (%d): %.3fs", i, elapsed.Seconds())
+		if limit := c.conf.Client.initLimit(); elapsed > limit {
+			return errors.Errorf("POST /initialize が制限時間 %s 以内に終わりませんでした [%d回目, elapsed:%.3fs]", limit, i, elapsed.Seconds())
+		}
+		info, err := guest.Info(ctx, 0)
+		if err != nil {
+			return errors.Wrap(err, "POST /initialize 後の GET /info に失敗しました")
+		}
+		if first == nil {
+			first = info
+		} else if !sameInitialState(first, info) {
+			return errors.Errorf("POST /initialize を2回呼ぶと状態が変わります [cursor:%d, %d]", first.Cursor, info.Cursor)
+		}
 	}
 	return nil
 }
 
+// sameInitialState は Initialize の直後の /info が同じかどうか
+func sameInitialState(a, b *InfoResponse) bool {
+	if a.Cursor != b.Cursor || a.LowestSellPrice != b.LowestSellPrice || a.HighestBuyPrice != b.HighestBuyPrice {
+		return false
+	}
+	if len(a.ChartByHour) != len(b.ChartByHour) {
+		return false
+	}
+	for i, ac := range a.ChartByHour {
+		bc := b.ChartByHour[i]
+		if !ac.Time.Equal(bc.Time) || ac.Open != bc.Open || ac.Close != bc.Close || ac.High != bc.High || ac.Low != bc.Low {
+			return false
+		}
+	}
+	return true
+}
+
 func (c *Manager) PreTest(ctx context.Context) error {
 	t := &PreTester{
 		appep:   c.appep,
+		conf:    c.conf.Client,
 		isubank: c.isubank,
 		isulog:  c.isulog,
+		checks:  c.checks.suite("PreTest"),
 	}
 	return t.Run(ctx)
 }
 
 func (c *Manager) PostTest(ctx context.Context) error {
-	testUsers := make([]testUser, 0, len(c.scenarios))
-	for _, sc := range c.scenarios {
+	c.scenarioLock.Lock()
+	scenarios := make([]Scenario, len(c.scenarios))
+	copy(scenarios, c.scenarios)
+	c.scenarioLock.Unlock()
+	testUsers := make([]testUser, 0, len(scenarios))
+	for _, sc := range scenarios {
 		if !sc.IsRetired() && sc.IsSignin() {
 			if tu, ok := sc.(testUser); ok {
 				testUsers = append(testUsers, tu)
@@ -235,10 +527,19 @@ func (c *Manager) PostTest(ctx context.Context) error {
 		}
 	}
 	t := &PostTester{
-		appep:   c.appep,
-		isubank: c.isubank,
-		isulog:  c.isulog,
-		users:   testUsers,
+		appep:    c.appep,
+		logDelay: time.Duration(c.conf.LogAllowedDelay) * time.Second,
+		isubank:  c.isubank,
+		isulog:   c.isulog,
+		users:    testUsers,
+		checks:   c.checks.suite("PostTest"),
+		fault:    c.bankFault,
+		chaosEnd: c.chaosEnd(),
+		ids:      c.ids,
+		slog:     c.Slog("bankfault"),
+
+		userCount: c.conf.PostTestUsers,
+		workers:   c.conf.PostTestWorkers,
 	}
 	if err := t.Run(ctx); err != nil {
 		return err
@@ -247,7 +548,7 @@ func (c *Manager) PostTest(ctx context.Context) error {
 	if c.statefile != "" {
 		u := t.tested[0]
 		state := FinalState{
-			BaseURL: c.appep,
+			BaseURL: u.Client().base.String(),
 			BankID:  u.BankID(),
 			Name:    u.Client().name,
 			Pass:    u.Client().pass,
@@ -279,8 +580,19 @@ func (c *Manager) PostTest(ctx context.Context) error {
 	return nil
 }
 
+// warmingUp は負荷走行の最初のスコアとエラーを数えない間か
+func (c *Manager) warmingUp() bool {
+	end := atomic.LoadInt64(&c.warmUpEnd)
+	return end > 0 && time.Now().UnixNano() < end
+}
+
 func (c *Manager) ScenarioStart(ctx context.Context) error {
-	smchan := make(chan ScoreMsg, 2000)
+	// 再開したときは中断前に済ませた分の warm-up はしない
+	if w := c.conf.warmUp() - c.resumedElapsed; w > 0 {
+		atomic.StoreInt64(&c.warmUpEnd, time.Now().Add(w).UnixNano())
+		c.Logger().Printf("warm-up: スコアとエラーを %s の間数えません", w)
+	}
+	smchan := c.smchan
 	cctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	var err error
@@ -292,16 +604,132 @@ func (c *Manager) ScenarioStart(ctx context.Context) error {
 		}
 	}()
 
-	go c.tickScenario(cctx, smchan)
+	if c.workload != nil {
+		c.workload.begin()
+	}
+	level := c.GetLevel()
+	c.retire.setLevel(level)
+	c.levels.enter(level, c.levelSnapshot())
+	defer func() { c.levels.finish(c.levelSnapshot()) }()
+	if c.replay != nil {
+		go c.runReplay(cctx, smchan)
+	} else if c.conf.Plan != nil {
+		// 最初のフェーズの levelUp がいつも開始時の level の後になるように, ここで始めてから残りを進める
+		c.startPlanPhase(cctx, smchan, 0)
+		go c.runPlan(cctx, smchan)
+	} else {
+		go c.tickScenario(cctx, smchan)
+	}
+	go c.runPurge(cctx)
+	go c.recordTimeline(cctx)
+	go c.runLogVerifier(cctx)
+	if c.conf.Chaos != nil {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			c.runChaos(cctx)
+		}()
+		// 外部サービスを元に戻してから負荷走行を終える
+		defer func() {
+			cancel()
+			<-done
+		}()
+	}
+	if c.trades != nil {
+		go c.watchTrades(cctx)
+	}
 
-	if err := c.startScenarios(cctx, smchan, DefaultWorkers); err != nil {
-		return nil
+	if c.replay == nil {
+		workers := DefaultWorkers
+		if n := len(c.resumeUsers); n > workers {
+			workers = n
+		}
+		if err := c.startScenarios(cctx, smchan, workers); err != nil {
+			return nil
+		}
 	}
 	<-cctx.Done()
 	handleContextErr(cctx.Err())
 	return err
 }
 
+// splitEndpoints はカンマ区切りのエンドポイントを分ける
+func splitEndpoints(s string) []string {
+	var r []string
+	for _, ep := range strings.Split(s, ",") {
+		if ep = strings.TrimSpace(ep); ep != "" {
+			r = append(r, ep)
+		}
+	}
+	return r
+}
+
+// nextAppEndpoint はユーザーごとに順番にエンドポイントを割り当てる
+func (c *Manager) nextAppEndpoint() string {
+	n := atomic.AddUint32(&c.appepNext, 1) - 1
+	return c.appeps[n%uint32(len(c.appeps))]
+}
+
+// 負荷走行用のclientはmetricsを記録する
+// newUserClient は n 人目に追加する既存のユーザーのClientを作る. シナリオの判断は n から決まる乱数から引く
+func (c *Manager) newUserClient(n int32, bankid, name, password string) (*Client, error) {
+	cl, err := c.newClient(bankid, name, password)
+	if err != nil {
+		return nil, err
+	}
+	cl.rand = c.rand.User(int64(n)).Rand
+	return cl, nil
+}
+
+func (c *Manager) newClient(bankid, name, password string) (*Client, error) {
+	cl, err := NewClient(c.nextAppEndpoint(), bankid, name, password, c.conf.Client.timeout(), c.conf.Client.retireTimeout())
+	if err != nil {
+		return nil, err
+	}
+	cl.applyConfig(c.conf.Client)
+	cl.metrics = c.metrics
+	cl.trades = c.trades
+	cl.ids = c.ids
+	cl.gate = c.pause
+	cl.limiter = c.limiter
+	cl.retire = c.retire
+	cl.retirees = c.retirees
+	if c.retire.p95() {
+		cl.latencies = newLatencyWindow(c.conf.Retire.Window)
+	}
+	var hooks traceHooks
+	if c.activity != nil {
+		hooks = append(hooks, c.activity)
+	}
+	if c.reqlog != nil {
+		hooks = append(hooks, c.reqlog)
+	}
+	if c.slow != nil {
+		hooks = append(hooks, c.slow)
+	}
+	if len(hooks) > 0 {
+		cl.hook = hooks
+	}
+	cl.har = c.har
+	return cl, nil
+}
+
+// 成約の通知はログインしていなくても受け取れる想定
+func (c *Manager) watchTrades(ctx context.Context) {
+	guest, err := c.newClient("", "", "")
+	if err != nil {
+		log.Printf("[WARN] trade stream client create failed. %s", err)
+		return
+	}
+	c.Logger().Printf("成約の通知を受け取ります (%s)", c.conf.TradeStreamPath)
+	err = c.trades.Watch(ctx, guest, c.conf.TradeStreamPath)
+	switch errors.Cause(err) {
+	case context.DeadlineExceeded, context.Canceled:
+	default:
+		c.Logger().Printf("成約の通知を受け取れません: %s", err)
+	}
+}
+
 func (c *Manager) nextTestUser(cost int) TestUser {
 	if len(c.testusers) == 0 {
 		return TestUser{}
@@ -315,29 +743,179 @@ func (c *Manager) nextTestUser(cost int) TestUser {
 	return c.nextTestUser(cost - 1)
 }
 
-func (c *Manager) newScenario() (Scenario, error) {
+// joinMarket は取引するユーザーに共有の価格を使わせる
+func (c *Manager) joinMarket(s Scenario) {
+	if ms, ok := s.(interface{ joinMarket(*Market) }); ok {
+		ms.joinMarket(c.market)
+	}
+}
+
+func (c *Manager) addScenario(s Scenario) {
+	c.scenarioLock.Lock()
+	defer c.scenarioLock.Unlock()
+	c.scenarios = append(c.scenarios, s)
+	if id := s.BankID(); id != "" {
+		c.scenarioByBankID[id] = s
+	}
+	if cs, ok := s.(interface{ Client() *Client }); ok && cs.Client().name != "" {
+		c.scenarioByName[cs.Client().name] = s
+	}
+}
+
+// admitScenarios は investor.max を超えないように開始できるユーザー数を返す
+// 返した数だけ開始中として数えるので、開始し終わったら doneStarting を呼ぶ
+func (c *Manager) admitScenarios(num int) int {
+	c.scenarioLock.Lock()
+	defer c.scenarioLock.Unlock()
+	if max := c.conf.Investor.Max; max > 0 {
+		if rest := max - c.activeUsers() - c.starting; rest < num {
+			if rest < 0 {
+				rest = 0
+			}
+			num = rest
+			if !c.capped {
+				c.capped = true
+				c.Logger().Printf("同時に動かすユーザー数が上限(%d)に達したので、これ以上増やしません", max)
+			}
+		}
+	}
+	c.starting += num
+	return num
+}
+
+func (c *Manager) doneStarting() {
+	c.scenarioLock.Lock()
+	defer c.scenarioLock.Unlock()
+	c.starting--
+}
+
+// purgeRetired は scenarios を前回の続きから最大 n 件だけ調べて、退役したユーザーを取り除く
+// 一度に全体を作り直すとその間 addScenario が待たされるので少しずつやる
+func (c *Manager) purgeRetired(n int) []Scenario {
+	c.scenarioLock.Lock()
+	defer c.scenarioLock.Unlock()
+	var retired []Scenario
+	for ; n > 0 && len(c.scenarios) > 0; n-- {
+		if c.purgeCursor >= len(c.scenarios) {
+			c.purgeCursor = 0
+		}
+		i := c.purgeCursor
+		s := c.scenarios[i]
+		if !s.IsRetired() {
+			c.purgeCursor++
+			continue
+		}
+		// 順番は気にしないので最後の要素と入れ替えて縮める
+		last := len(c.scenarios) - 1
+		c.scenarios[i] = c.scenarios[last]
+		c.scenarios[last] = nil
+		c.scenarios = c.scenarios[:last]
+		c.purged++
+		retired = append(retired, s)
+	}
+	return retired
+}
+
+func (c *Manager) runPurge(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(PurgeInterval):
+			// 退役したユーザーのコネクションはロックの外で閉じる
+			for _, s := range c.purgeRetired(PurgeBatchSize) {
+				if cs, ok := s.(interface{ Client() *Client }); ok {
+					cs.Client().closeIdleConnections()
+				}
+			}
+		}
+	}
+}
+
+// Checks は PreTest, PostTest の各チェックの結果
+func (c *Manager) Checks() *CheckRecorder {
+	return c.checks
+}
+
+// FindScenario はbank_idでユーザーを探す
+func (c *Manager) FindScenario(bankid string) (Scenario, bool) {
+	c.scenarioLock.Lock()
+	defer c.scenarioLock.Unlock()
+	s, ok := c.scenarioByBankID[bankid]
+	return s, ok
+}
+
+// FindScenarioByName は名前でユーザーを探す. 同じ名前のユーザーがいれば後から追加された方を返す
+func (c *Manager) FindScenarioByName(name string) (Scenario, bool) {
+	c.scenarioLock.Lock()
+	defer c.scenarioLock.Unlock()
+	s, ok := c.scenarioByName[name]
+	return s, ok
+}
+
+func (c *Manager) newBruteForceScenario(cl *Client) Scenario {
+	ic := c.conf.Investor
+	return NewBruteForceScenario(cl, ic.passwords,
+		time.Duration(ic.BruteForceDelayMin)*time.Millisecond,
+		time.Duration(ic.BruteForceDelayMax)*time.Millisecond,
+		ic.BruteForceLockoutAfter, ic.BruteForceRequireLockout)
+}
+
+// 総当たりの対象はアカウントファイルがあればそちらを先に使う
+func (c *Manager) nextBruteForceAccount() string {
+	c.scenarioLock.Lock()
+	defer c.scenarioLock.Unlock()
+	if len(c.bruteAccounts) == 0 {
+		return ""
+	}
+	a := c.bruteAccounts[0]
+	c.bruteAccounts = c.bruteAccounts[1:]
+	return a
+}
+
+func (c *Manager) newScenario(ctx context.Context) (Scenario, error) {
 	var credit, isu, unit int64
 	var justprice bool
+	if u, ok := c.nextResumeUser(); ok {
+		cl, err := c.newClient(u.BankID, u.Name, u.Pass)
+		if err != nil {
+			return nil, err
+		}
+		credit, err := c.isubank.GetCredit(ctx, u.BankID)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[DEBUG] resume user %s", u.BankID)
+		return NewExistsUserScenario(cl, credit, u.Isu, 3, false), nil
+	}
 	n := atomic.AddInt32(&c.scounter, 1)
 	switch {
 	case n%10 == 3:
+		if bankid := c.nextBruteForceAccount(); bankid != "" {
+			cl, err := c.newUserClient(n, bankid, "", "12345")
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("[DEBUG] add BruteForce %s (account file)", bankid)
+			return c.newBruteForceScenario(cl), nil
+		}
 		if tu := c.nextTestUser(10); tu.BankID != "" {
-			cl, err := NewClient(c.appep, tu.BankID, tu.Name, "12345", ClientTimeout, RetireTimeout)
+			cl, err := c.newUserClient(n, tu.BankID, tu.Name, "12345")
 			if err != nil {
 				return nil, err
 			}
 			log.Printf("[DEBUG] add BruteForce %s cost:%d, orders:%d", tu.BankID, tu.Cost, tu.Orders)
-			return NewBruteForceScenario(cl), nil
+			return c.newBruteForceScenario(cl), nil
 		}
 		fallthrough
 	case n%5 == 2:
 		if tu := c.nextTestUser(6); tu.BankID != "" {
-			cl, err := NewClient(c.appep, tu.BankID, tu.Name, tu.Pass, ClientTimeout, RetireTimeout)
+			cl, err := c.newUserClient(n, tu.BankID, tu.Name, tu.Pass)
 			if err != nil {
 				return nil, err
 			}
 			// この人達を成り行きにはしたくないけどしょうがない
-			credit, err = c.isubank.GetCredit(tu.BankID)
+			credit, err = c.isubank.GetCredit(ctx, tu.BankID)
 			if err != nil {
 				return nil, err
 			}
@@ -351,30 +929,47 @@ func (c *Manager) newScenario() (Scenario, error) {
 	case n == 11 || n == 21 || n == 31:
 		// 成り行き売り
 		credit, isu, unit, justprice = 0, 200, 5, true
-	case n < 16:
-		credit, isu, unit = 30000, 5, 1
 	default:
-		credit, isu, unit = 35000, 7, 3
+		return c.newInvestor(n)
 	}
-	cl, err := NewClient(c.appep, c.FetchNewID(), c.rand.Name(), c.rand.Password(), ClientTimeout, RetireTimeout)
+	cl, err := c.NewUserClient(n, credit)
 	if err != nil {
 		return nil, err
 	}
-	if credit > 0 {
-		c.isubank.AddCredit(cl.bankid, credit)
-	}
 	return NewNormalScenario(cl, credit, isu, unit, justprice), nil
 }
 
 func (c *Manager) startScenarios(ctx context.Context, smchan chan ScoreMsg, num int) error {
+	_, err := c.startAdmitted(ctx, smchan, num)
+	return err
+}
+
+// startAdmitted は num 人まで開始して、investor.max で減らした後の開始した人数を返す
+func (c *Manager) startAdmitted(ctx context.Context, smchan chan ScoreMsg, num int) (int, error) {
+	if c.Paused() {
+		return 0, errors.New("paused")
+	}
+	if c.replay != nil {
+		// 再生中は記録したユーザーだけを動かす
+		return 0, errors.New("replaying")
+	}
+	if num = c.admitScenarios(num); num == 0 {
+		return 0, nil
+	}
+	c.idpool.reserve(num)
 	for i := 0; i < num; i++ {
 		go func() {
+			defer c.doneStarting()
 			time.Sleep(time.Duration(rand.Int63n(100)) * time.Millisecond)
-			scenario, err := c.newScenario()
+			scenario, err := c.newScenario(ctx)
 			if err != nil {
 				log.Printf("[WARN] newScenario failed. err: %s", err)
 				return
 			}
+			if c.workload != nil {
+				c.workload.attach(scenario)
+			}
+			c.joinMarket(scenario)
 			// add
 			if err := scenario.Start(ctx, smchan); err != nil {
 				switch errors.Cause(err) {
@@ -383,13 +978,11 @@ func (c *Manager) startScenarios(ctx context.Context, smchan chan ScoreMsg, num
 					log.Printf("[INFO] scenario.Start user:%s, failed. %s", scenario.BankID(), err)
 				}
 			} else {
-				c.scenarioLock.Lock()
-				c.scenarios = append(c.scenarios, scenario)
-				c.scenarioLock.Unlock()
+				c.addScenario(scenario)
 			}
 		}()
 	}
-	return nil
+	return num, nil
 }
 
 func (c *Manager) tickScenario(ctx context.Context, smchan chan ScoreMsg) {
@@ -399,18 +992,17 @@ func (c *Manager) tickScenario(ctx context.Context, smchan chan ScoreMsg) {
 			handleContextErr(ctx.Err())
 			return
 		case <-time.After(TickerInterval):
+			if c.Paused() {
+				continue
+			}
 			score := c.GetScore()
 			// 自然増加
 			for {
 				// levelup
-				nextScore := (1 << c.level) * 100
-				if score < int64(nextScore) {
+				if !c.canLevelUp(score) {
 					break
 				}
-				if AllowErrorMin < c.ErrorCount() {
-					break
-				}
-				c.level++
+				c.levelUp()
 				c.Logger().Printf("アクティブユーザーが自然増加します")
 				if e := c.startScenarios(ctx, smchan, AddUsersOnNatural); e != nil {
 					log.Printf("[INFO] scenario.Start failed. %s", e)
@@ -420,6 +1012,28 @@ func (c *Manager) tickScenario(ctx context.Context, smchan chan ScoreMsg) {
 	}
 }
 
+// startCampaign は /info で告知された新しいキャンペーンに応じてユーザーを増やし、ボーナスを加える
+// 告知を繰り返してボーナスを稼げないように、受け付ける数と間隔を制限する
+// recvScoreMsg からだけ呼ぶのでロックは取らない
+func (c *Manager) startCampaign(ctx context.Context, smchan chan ScoreMsg, id int64) {
+	if c.campaigns[id] {
+		return
+	}
+	c.campaigns[id] = true
+	if len(c.campaigns) > CampaignMax || time.Since(c.lastCampaign) < CampaignInterval {
+		c.Logger().Printf("キャンペーン(id:%d)は受け付けませんでした", id)
+		return
+	}
+	if err := c.startScenarios(ctx, smchan, AddUsersOnCampaign); err != nil {
+		log.Printf("[INFO] scenario.Start failed. %s", err)
+		return
+	}
+	c.lastCampaign = time.Now()
+	c.AddScore(c.conf.Score.Of(ScoreTypeCampaign))
+	c.scoreboard.Add(ScoreTypeCampaign)
+	c.Logger().Printf("キャンペーン(id:%d)のためアクティブユーザーが%d人増加しました", id, AddUsersOnCampaign)
+}
+
 func (c *Manager) recvScoreMsg(ctx context.Context, smchan chan ScoreMsg) error {
 	for {
 		select {
@@ -436,15 +1050,14 @@ func (c *Manager) recvScoreMsg(ctx context.Context, smchan chan ScoreMsg) error
 						return e
 					}
 				}
-			} else {
-				c.AddScore(s.st.Score())
+			} else if !c.warmingUp() {
+				c.AddScore(c.conf.Score.Of(s.st))
 				c.scoreboard.Add(s.st)
 				if s.sns {
-					if e := c.startScenarios(ctx, smchan, AddUsersOnShare); e != nil {
-						log.Printf("[INFO] scenario.Start failed. %s", e)
-					} else {
-						c.Logger().Printf("SNSでシェアされたためアクティブユーザーが増加しました")
-					}
+					c.share(ctx, smchan, s)
+				}
+				if s.campaign > 0 {
+					c.startCampaign(ctx, smchan, s.campaign)
 				}
 			}
 		}
@@ -0,0 +1,93 @@
+package bench
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const MarketDriftGrace = 5 * time.Second // 成約を見てからチャートに反映されるまで待つ時間
+
+// Market はユーザー全員が見た価格と成約をまとめて持つ
+// 新しく追加するユーザーの初期の価格に使い、アプリが返すチャートが成約から遅れていないかを調べる
+type Market struct {
+	mu sync.Mutex
+
+	// 最後に見た /info の価格
+	infoAt           time.Time
+	latestTradePrice int64
+	lowestSellPrice  int64
+	highestBuyPrice  int64
+
+	// 見た中で最も新しい成約と、それを最初に見た時刻
+	lastTrade   Trade
+	lastTradeAt time.Time
+	drifted     bool // lastTrade についてチャートの遅れを報告済み
+}
+
+func NewMarket() *Market {
+	return &Market{}
+}
+
+// Prices は最後に見た成約価格, 最安売値, 最高買値を返す
+func (m *Market) Prices() (latest, lowest, highest int64) {
+	if m == nil {
+		return 0, 0, 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.latestTradePrice, m.lowestSellPrice, m.highestBuyPrice
+}
+
+// observeTrades は注文のうち成約したものを記録する
+func (m *Market) observeTrades(orders []Order) {
+	if m == nil {
+		return
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, o := range orders {
+		if o.Trade == nil || !o.Trade.CreatedAt.After(m.lastTrade.CreatedAt) {
+			continue
+		}
+		m.lastTrade = *o.Trade
+		m.lastTradeAt = now
+		m.drifted = false
+	}
+}
+
+// observeInfo は requestedAt に送った /info の価格を記録する
+// MarketDriftGrace より前に見た成約がチャートに出ていなければエラーを返す
+func (m *Market) observeInfo(requestedAt time.Time, info *InfoResponse) error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if requestedAt.After(m.infoAt) {
+		m.infoAt = requestedAt
+		m.lowestSellPrice = info.LowestSellPrice
+		m.highestBuyPrice = info.HighestBuyPrice
+		if l := len(info.ChartByHour); l > 0 {
+			m.latestTradePrice = info.ChartByHour[l-1].Close
+		}
+	}
+	l := len(info.ChartBySec)
+	if l == 0 || m.lastTradeAt.IsZero() || m.drifted || requestedAt.Before(m.lastTradeAt.Add(MarketDriftGrace)) {
+		return nil
+	}
+	at := m.lastTrade.CreatedAt.Truncate(time.Second)
+	if latest := info.ChartBySec[l-1].Time; latest.Before(at) {
+		m.drifted = true
+		return errors.Errorf("GET /info chart_by_sec が最新の成約を反映していません [trade:%d, time:%s, latest:%s]", m.lastTrade.ID, at, latest)
+	}
+	return nil
+}
+
+// joinMarket は s の価格を Market が知っている価格で始める
+func (s *normalScenario) joinMarket(m *Market) {
+	s.market = m
+	s.latestTradePrice, s.lowestSellPrice, s.highestBuyPrice = m.Prices()
+}
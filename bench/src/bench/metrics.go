@@ -0,0 +1,439 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bench/portal"
+	"github.com/pkg/errors"
+)
+
+// パーセンタイル計算用のヒストグラムの境界 (1msから25%刻みで約30sまで)
+var latencyBuckets = func() []time.Duration {
+	b := []time.Duration{}
+	for d := float64(time.Millisecond); d < float64(30*time.Second); d *= 1.25 {
+		b = append(b, time.Duration(d))
+	}
+	return b
+}()
+
+// statusClasses はエンドポイントごとに数えるレスポンスの分類
+var statusClasses = [...]string{"2xx", "3xx", "4xx", "5xx", "failed"}
+
+func statusClass(status int, failed bool) int {
+	switch {
+	case failed || status == 0:
+		return 4
+	case status >= 500:
+		return 3
+	case status >= 400:
+		return 2
+	case status >= 300:
+		return 1
+	default:
+		return 0
+	}
+}
+
+type endpointMetrics struct {
+	count   int64
+	errors  int64
+	retries int64
+	status  [len(statusClasses)]int64
+	elapsed time.Duration
+	buckets []int64 // 最後の要素はlatencyBucketsを超えたもの
+}
+
+func (em *endpointMetrics) observe(elapsed time.Duration) {
+	em.count++
+	em.elapsed += elapsed
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return elapsed <= latencyBuckets[i] })
+	em.buckets[i]++
+}
+
+// percentile はバケットの上限値で近似したパーセンタイルを返す
+func (em *endpointMetrics) percentile(p float64) time.Duration {
+	if em.count == 0 {
+		return 0
+	}
+	rank := int64(float64(em.count)*p + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var n int64
+	for i, c := range em.buckets {
+		n += c
+		if n >= rank {
+			if i < len(latencyBuckets) {
+				return latencyBuckets[i]
+			}
+			break
+		}
+	}
+	return latencyBuckets[len(latencyBuckets)-1]
+}
+
+// Metrics はClientのリクエストをエンドポイント単位で集計する
+type Metrics struct {
+	mu        sync.Mutex
+	endpoints map[string]*endpointMetrics
+	protocols map[string]int64
+
+	outcomes portal.RequestOutcomes
+
+	gzipResponses    int64
+	gzipCompressed   int64 // 受け取ったバイト数
+	gzipUncompressed int64 // 展開したバイト数
+
+	push *endpointMetrics // long polling で成約が届くまでの時間
+}
+
+// longPollSuffix はアプリに待たせた GET /info をまとめるエンドポイント名の接尾辞
+const longPollSuffix = " (long-poll)"
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		endpoints: make(map[string]*endpointMetrics, 20),
+		protocols: make(map[string]int64, 2),
+		push:      &endpointMetrics{buckets: make([]int64, len(latencyBuckets)+1)},
+	}
+}
+
+// /order/123 のようなIDを含むパスは1つのエンドポイントとしてまとめる
+func endpointName(method, path string) string {
+	parts := strings.Split(path, "/")
+	for i, p := range parts {
+		if p != "" && strings.Trim(p, "0123456789") == "" {
+			parts[i] = ":id"
+		}
+	}
+	return method + " " + strings.Join(parts, "/")
+}
+
+// observeRetry はリトライしたリクエストをエラーとは別に数える
+func (m *Metrics) observeRetry(method, path string) {
+	if m == nil {
+		return
+	}
+	name := endpointName(method, path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	em, ok := m.endpoints[name]
+	if !ok {
+		em = &endpointMetrics{buckets: make([]int64, len(latencyBuckets)+1)}
+		m.endpoints[name] = em
+	}
+	em.retries++
+}
+
+func (m *Metrics) observeRequest(method, path, proto string, status int, failed bool, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	name := endpointName(method, path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	em, ok := m.endpoints[name]
+	if !ok {
+		em = &endpointMetrics{buckets: make([]int64, len(latencyBuckets)+1)}
+		m.endpoints[name] = em
+	}
+	em.observe(elapsed)
+	em.status[statusClass(status, failed)]++
+	if failed || status >= 500 {
+		em.errors++
+	}
+	if proto != "" {
+		m.protocols[proto]++
+	}
+}
+
+// observeOutcome はリクエストが最後までできたか、中断されたか、時間切れになったかを数える
+func (m *Metrics) observeOutcome(err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		m.outcomes.Completed++
+		return
+	}
+	switch e := errors.Cause(err).(type) {
+	case *ErrElapsedTimeOverRetire:
+		m.outcomes.TimedOut++
+		return
+	case net.Error:
+		if e.Timeout() {
+			m.outcomes.TimedOut++
+			return
+		}
+	}
+	switch errors.Cause(err) {
+	case context.Canceled:
+		m.outcomes.Cancelled++
+	case context.DeadlineExceeded:
+		m.outcomes.TimedOut++
+	default:
+		m.outcomes.Failed++
+	}
+}
+
+// Outcomes はリクエストの結果ごとの数
+func (m *Metrics) Outcomes() portal.RequestOutcomes {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.outcomes
+}
+
+// observeCompression はgzipで圧縮されていたレスポンスのサイズを記録する
+func (m *Metrics) observeCompression(compressed, uncompressed int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gzipResponses++
+	m.gzipCompressed += compressed
+	m.gzipUncompressed += uncompressed
+}
+
+// Compression はgzipで圧縮されていたレスポンスの数とサイズ
+func (m *Metrics) Compression() portal.CompressionResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total int64
+	for _, em := range m.endpoints {
+		total += em.count
+	}
+	r := portal.CompressionResult{
+		Responses:         total,
+		GzipResponses:     m.gzipResponses,
+		CompressedBytes:   m.gzipCompressed,
+		UncompressedBytes: m.gzipUncompressed,
+	}
+	if d := m.gzipUncompressed - m.gzipCompressed; d > 0 {
+		r.SavedBytes = d
+	}
+	return r
+}
+
+// GzipRatio はレスポンスのうちgzipで圧縮されていたものの割合
+func (m *Metrics) GzipRatio() float64 {
+	c := m.Compression()
+	if c.Responses == 0 {
+		return 0
+	}
+	return float64(c.GzipResponses) / float64(c.Responses)
+}
+
+// totals は全エンドポイントのリクエスト数とかかった時間の合計
+func (m *Metrics) totals() (int64, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var count int64
+	var elapsed time.Duration
+	for _, em := range m.endpoints {
+		count += em.count
+		elapsed += em.elapsed
+	}
+	return count, elapsed
+}
+
+// overall は全エンドポイントをまとめた集計
+func (m *Metrics) overall() *endpointMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	all := &endpointMetrics{buckets: make([]int64, len(latencyBuckets)+1)}
+	for name, em := range m.endpoints {
+		if strings.HasSuffix(name, longPollSuffix) {
+			// 待たせた時間はアプリの遅さではない
+			continue
+		}
+		all.count += em.count
+		all.errors += em.errors
+		all.elapsed += em.elapsed
+		for i, c := range em.buckets {
+			all.buckets[i] += c
+		}
+	}
+	return all
+}
+
+func (m *Metrics) observePush(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.push.observe(d)
+}
+
+// PushLatency は long polling で受け取った成約の数と、届くまでの時間のパーセンタイル
+func (m *Metrics) PushLatency() (count int64, p50, p99 time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.push.count, m.push.percentile(0.50), m.push.percentile(0.99)
+}
+
+// Percentile は全エンドポイントのリクエストのレイテンシのパーセンタイル
+func (m *Metrics) Percentile(p float64) time.Duration {
+	return m.overall().percentile(p)
+}
+
+// ErrorRate は全リクエストのうち失敗したか5xxだったものの割合
+func (m *Metrics) ErrorRate() float64 {
+	all := m.overall()
+	if all.count == 0 {
+		return 0
+	}
+	return float64(all.errors) / float64(all.count)
+}
+
+// Protocols はレスポンスのプロトコル (HTTP/1.1, HTTP/2.0) ごとのリクエスト数
+func (m *Metrics) Protocols() map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := make(map[string]int64, len(m.protocols))
+	for proto, n := range m.protocols {
+		r[proto] = n
+	}
+	return r
+}
+
+func (m *Metrics) HTTP2Ratio() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var total int64
+	for _, n := range m.protocols {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(m.protocols["HTTP/2.0"]) / float64(total)
+}
+
+func (m *Metrics) sortedEndpoints() []string {
+	names := make([]string, 0, len(m.endpoints))
+	for name := range m.endpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (m *Metrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := m.sortedEndpoints()
+	fmt.Fprintln(w, "# HELP bench_requests_total Number of requests sent to the app.")
+	fmt.Fprintln(w, "# TYPE bench_requests_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "bench_requests_total{endpoint=%q} %d\n", name, m.endpoints[name].count)
+	}
+	fmt.Fprintln(w, "# HELP bench_responses_by_protocol_total Number of responses by protocol.")
+	fmt.Fprintln(w, "# TYPE bench_responses_by_protocol_total counter")
+	for proto, n := range m.protocols {
+		fmt.Fprintf(w, "bench_responses_by_protocol_total{protocol=%q} %d\n", proto, n)
+	}
+	fmt.Fprintln(w, "# HELP bench_request_errors_total Number of failed requests.")
+	fmt.Fprintln(w, "# TYPE bench_request_errors_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "bench_request_errors_total{endpoint=%q} %d\n", name, m.endpoints[name].errors)
+	}
+	fmt.Fprintln(w, "# HELP bench_request_retries_total Number of retried requests.")
+	fmt.Fprintln(w, "# TYPE bench_request_retries_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "bench_request_retries_total{endpoint=%q} %d\n", name, m.endpoints[name].retries)
+	}
+	fmt.Fprintln(w, "# HELP bench_request_outcomes_total Number of requests by outcome.")
+	fmt.Fprintln(w, "# TYPE bench_request_outcomes_total counter")
+	fmt.Fprintf(w, "bench_request_outcomes_total{outcome=\"completed\"} %d\n", m.outcomes.Completed)
+	fmt.Fprintf(w, "bench_request_outcomes_total{outcome=\"cancelled\"} %d\n", m.outcomes.Cancelled)
+	fmt.Fprintf(w, "bench_request_outcomes_total{outcome=\"timed_out\"} %d\n", m.outcomes.TimedOut)
+	fmt.Fprintf(w, "bench_request_outcomes_total{outcome=\"failed\"} %d\n", m.outcomes.Failed)
+	fmt.Fprintln(w, "# HELP bench_request_duration_seconds Request latency.")
+	fmt.Fprintln(w, "# TYPE bench_request_duration_seconds summary")
+	for _, name := range names {
+		em := m.endpoints[name]
+		for _, q := range []float64{0.5, 0.95, 0.99} {
+			fmt.Fprintf(w, "bench_request_duration_seconds{endpoint=%q,quantile=\"%g\"} %.6f\n", name, q, em.percentile(q).Seconds())
+		}
+		fmt.Fprintf(w, "bench_request_duration_seconds_sum{endpoint=%q} %.6f\n", name, em.elapsed.Seconds())
+		fmt.Fprintf(w, "bench_request_duration_seconds_count{endpoint=%q} %d\n", name, em.count)
+	}
+	if m.push.count > 0 {
+		fmt.Fprintln(w, "# HELP bench_trade_push_latency_seconds Time from a trade to its delivery by long polling.")
+		fmt.Fprintln(w, "# TYPE bench_trade_push_latency_seconds summary")
+		for _, q := range []float64{0.5, 0.95, 0.99} {
+			fmt.Fprintf(w, "bench_trade_push_latency_seconds{quantile=\"%g\"} %.6f\n", q, m.push.percentile(q).Seconds())
+		}
+		fmt.Fprintf(w, "bench_trade_push_latency_seconds_sum %.6f\n", m.push.elapsed.Seconds())
+		fmt.Fprintf(w, "bench_trade_push_latency_seconds_count %d\n", m.push.count)
+	}
+}
+
+// availability は 2xx, 3xx で返せたリクエストの割合
+func (em *endpointMetrics) availability() float64 {
+	if em.count == 0 {
+		return 0
+	}
+	return float64(em.status[0]+em.status[1]) / float64(em.count)
+}
+
+// Latencies はエンドポイントごとのレイテンシのパーセンタイルを返す
+func (m *Metrics) Latencies() []portal.LatencyResult {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := make([]portal.LatencyResult, 0, len(m.endpoints))
+	for _, name := range m.sortedEndpoints() {
+		em := m.endpoints[name]
+		status := make(map[string]int64, len(statusClasses))
+		for i, class := range statusClasses {
+			if em.status[i] > 0 {
+				status[class] = em.status[i]
+			}
+		}
+		r = append(r, portal.LatencyResult{
+			Endpoint: name,
+			Count:    em.count,
+			Errors:   em.errors,
+			Retries:  em.retries,
+			P50:      em.percentile(0.50).Seconds(),
+			P95:      em.percentile(0.95).Seconds(),
+			P99:      em.percentile(0.99).Seconds(),
+
+			Status:       status,
+			Availability: em.availability(),
+		})
+	}
+	return r
+}
+
+func writeGauge(w io.Writer, name, help string, v int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(w, "%s %d\n", name, v)
+}
+
+// MetricsHandler はPrometheusのtext formatで負荷走行の状態を返す
+func (c *Manager) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeGauge(w, "bench_score", "Current raw score.", c.GetScore())
+		writeGauge(w, "bench_errors", "Number of errors counted against the app.", int64(c.ErrorCount()))
+		writeGauge(w, "bench_level", "Current load level.", int64(c.GetLevel()))
+		writeGauge(w, "bench_active_users", "Number of active (not retired) users.", int64(c.ActiveUsers()))
+		writeGauge(w, "bench_users", "Number of users started.", int64(c.AllUsers()))
+		writeGauge(w, "bench_score_queue_depth", "Number of pending score messages.", int64(len(c.smchan)))
+		c.metrics.writeTo(w)
+	})
+}
@@ -0,0 +1,125 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// pauseGate は止めている間 wait を呼んだgoroutineを待たせる
+type pauseGate struct {
+	mu      sync.Mutex
+	ch      chan struct{} // 止めている間だけ作られ、再開するとcloseされる
+	since   time.Time
+	stopped time.Duration // これまでに止めていた時間の合計
+}
+
+func (g *pauseGate) pause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.ch != nil {
+		return false
+	}
+	g.ch = make(chan struct{})
+	g.since = time.Now()
+	return true
+}
+
+func (g *pauseGate) resume() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.ch == nil {
+		return false
+	}
+	close(g.ch)
+	g.ch = nil
+	g.stopped += time.Since(g.since)
+	return true
+}
+
+func (g *pauseGate) paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.ch != nil
+}
+
+// total は今止めている分も含めて止めていた時間
+func (g *pauseGate) total() time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	d := g.stopped
+	if g.ch != nil {
+		d += time.Since(g.since)
+	}
+	return d
+}
+
+func (g *pauseGate) wait(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	ch := g.ch
+	g.mu.Unlock()
+	if ch == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ch:
+		return nil
+	}
+}
+
+// Pause は負荷走行を一時停止する. ユーザーの追加を止め、各Clientはリクエストを送る前に待つ
+// 止めていた時間の分だけ負荷走行の時間は延びる
+func (c *Manager) Pause() {
+	if c.pause.pause() {
+		c.Logger().Printf("負荷走行を一時停止します")
+	}
+}
+
+func (c *Manager) Unpause() {
+	if c.pause.resume() {
+		c.Logger().Printf("負荷走行を再開します")
+	}
+}
+
+func (c *Manager) Paused() bool {
+	return c.pause.paused()
+}
+
+// ControlHandler は /control/pause と /control/resume で負荷走行を止めたり再開したりする
+func (c *Manager) ControlHandler() http.Handler {
+	mux := http.NewServeMux()
+	status := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"paused":       c.Paused(),
+			"paused_total": c.pause.total().Seconds(),
+		})
+	}
+	mux.HandleFunc("/control/status", func(w http.ResponseWriter, r *http.Request) {
+		status(w)
+	})
+	mux.HandleFunc("/control/pause", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		c.Pause()
+		status(w)
+	})
+	mux.HandleFunc("/control/resume", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		c.Unpause()
+		status(w)
+	})
+	return mux
+}
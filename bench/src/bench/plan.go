@@ -0,0 +1,180 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Plan は負荷走行の進め方の定義. 指定されていれば score によるlevelupの代わりにこれに従ってユーザーを増やす
+// 設定ファイルと同じくTOMLで書く (拡張子が .toml でなければJSON)
+//
+//	[[phases]]
+//	name = "warmup"
+//	duration = 10
+//	add_users = 5
+//
+//	[[phases]]
+//	name = "peak"
+//	duration = 30
+//	every = 2
+//	every_users = 3
+//	mix = { scalper = 1 }
+//	trigger = { score = 1000 }
+//
+//	[[phases]]
+//	name = "cooldown"
+type Plan struct {
+	Phases []PlanPhase `json:"phases"`
+}
+
+type PlanPhase struct {
+	Name       string         `json:"name"`
+	Duration   int64          `json:"duration"`    // 秒. 0なら負荷走行の終わりまで
+	AddUsers   int            `json:"add_users"`   // フェーズの開始時に追加するユーザー数
+	Every      int64          `json:"every"`       // 秒. この間隔で every_users ずつユーザーを追加する
+	EveryUsers int            `json:"every_users"` //
+	Mix        map[string]int `json:"mix"`         // このフェーズで追加するユーザーの種類. 空なら investor.mix
+	Trigger    PlanTrigger    `json:"trigger"`     // 前のフェーズが終わっても条件を満たすまでは始めない
+}
+
+type PlanTrigger struct {
+	Score     int64 `json:"score"`      // スコアがこれ以上
+	MaxErrors int   `json:"max_errors"` // エラーがこれ以下 (0なら見ない)
+}
+
+func (t PlanTrigger) ok(c *Manager) bool {
+	if c.GetScore() < t.Score {
+		return false
+	}
+	if t.MaxErrors > 0 && c.ErrorCount() > t.MaxErrors {
+		return false
+	}
+	return true
+}
+
+func LoadPlan(path string) (*Plan, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "plan file open failed")
+	}
+	defer f.Close()
+	plan := &Plan{}
+	if err = decodeFile(f, plan); err != nil {
+		return nil, errors.Wrap(err, "plan file decode failed")
+	}
+	if err = plan.validate(); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func (p *Plan) validate() error {
+	if len(p.Phases) == 0 {
+		return errors.Errorf("plan has no phases")
+	}
+	for i, ph := range p.Phases {
+		if ph.Duration < 0 || ph.Every < 0 || ph.AddUsers < 0 || ph.EveryUsers < 0 {
+			return errors.Errorf("plan phase %d (%s) has negative value", i, ph.Name)
+		}
+		if ph.Duration == 0 && i < len(p.Phases)-1 {
+			return errors.Errorf("plan phase %d (%s) needs duration", i, ph.Name)
+		}
+		if len(ph.Mix) > 0 {
+			if err := validateInvestorMix(ph.Mix); err != nil {
+				return errors.Wrapf(err, "plan phase %d (%s)", i, ph.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// investorMix は今のフェーズで追加するユーザーの種類の割合
+func (c *Manager) investorMix() map[string]int {
+	if c.conf.Plan != nil {
+		i := atomic.LoadInt32(&c.planPhase)
+		if mix := c.conf.Plan.Phases[i].Mix; len(mix) > 0 {
+			return mix
+		}
+	}
+	return c.conf.Investor.Mix
+}
+
+func (c *Manager) startPlanPhase(ctx context.Context, smchan chan ScoreMsg, i int) {
+	ph := c.conf.Plan.Phases[i]
+	atomic.StoreInt32(&c.planPhase, int32(i))
+	c.levelUp()
+	c.Logger().Printf("フェーズ %s を開始します", ph.Name)
+	if ph.AddUsers > 0 {
+		if e := c.startScenarios(ctx, smchan, ph.AddUsers); e != nil {
+			c.Logger().Printf("[INFO] scenario.Start failed. %s", e)
+		}
+	}
+}
+
+// runPlan は tickScenario の代わりに Plan に従ってユーザーを増やす
+// 最初のフェーズは startPlanPhase で始めてから呼ぶ
+func (c *Manager) runPlan(ctx context.Context, smchan chan ScoreMsg) {
+	phases := c.conf.Plan.Phases
+	i := 0
+	start, added := time.Now(), time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			handleContextErr(ctx.Err())
+			return
+		case <-time.After(TickerInterval):
+			if c.Paused() {
+				// 止めていた時間はフェーズの時間に含めない
+				start, added = start.Add(TickerInterval), added.Add(TickerInterval)
+				continue
+			}
+			ph := phases[i]
+			if ph.Every > 0 && ph.EveryUsers > 0 && time.Since(added) >= time.Duration(ph.Every)*time.Second {
+				added = time.Now()
+				if e := c.startScenarios(ctx, smchan, ph.EveryUsers); e != nil {
+					c.Logger().Printf("[INFO] scenario.Start failed. %s", e)
+				}
+			}
+			if i+1 < len(phases) && time.Since(start) >= time.Duration(ph.Duration)*time.Second && phases[i+1].Trigger.ok(c) {
+				i++
+				start, added = time.Now(), time.Now()
+				c.startPlanPhase(ctx, smchan, i)
+			}
+		}
+	}
+}
+
+// ProfilePlan はよく使う負荷のかけ方を Plan にしたもの
+//
+//	ramp:  1秒ごとに1人ずつ増やし続ける
+//	spike: しばらく少ない人数で動かしたあと一気に増やす
+//	soak:  最初に決まった人数を入れて、そのまま一定の負荷をかけ続ける
+//	step:  10秒ごとに10人ずつ段階的に増やす
+func ProfilePlan(profile string) (*Plan, error) {
+	switch profile {
+	case "ramp":
+		return &Plan{Phases: []PlanPhase{
+			{Name: "ramp", Every: 1, EveryUsers: 1},
+		}}, nil
+	case "spike":
+		return &Plan{Phases: []PlanPhase{
+			{Name: "calm", Duration: 20},
+			{Name: "spike", Duration: 10, AddUsers: 50},
+			{Name: "after spike"},
+		}}, nil
+	case "soak":
+		return &Plan{Phases: []PlanPhase{
+			{Name: "soak", AddUsers: 20},
+		}}, nil
+	case "step":
+		return &Plan{Phases: []PlanPhase{
+			{Name: "step", Every: 10, EveryUsers: 10},
+		}}, nil
+	default:
+		return nil, errors.Errorf("unknown profile %s (ramp, spike, soak, step)", profile)
+	}
+}
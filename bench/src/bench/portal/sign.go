@@ -0,0 +1,51 @@
+package portal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// SignResult は result の Signature 以外の項目をJSONにしたもののHMAC-SHA256を Signature に入れる
+// 結果のJSONのスコアなどを書き換えると VerifyResult で検出できる
+func SignResult(key string, result *BenchResult) error {
+	sig, err := resultSignature(key, *result)
+	if err != nil {
+		return err
+	}
+	result.Signature = sig
+	return nil
+}
+
+// VerifyResult は result がベンチの出力から書き換えられていないか確かめる
+func VerifyResult(key string, result *BenchResult) error {
+	if result.Signature == "" {
+		return errors.New("signature is empty")
+	}
+	sig, err := resultSignature(key, *result)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(sig), []byte(result.Signature)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// resultSignature は JSONにしなおしてから計算するので、ファイルの改行や空白は署名に含まれない
+func resultSignature(key string, result BenchResult) (string, error) {
+	if key == "" {
+		return "", errors.New("sign key is empty")
+	}
+	result.Signature = ""
+	b, err := json.Marshal(result)
+	if err != nil {
+		return "", errors.Wrap(err, "json.Marshal failed")
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
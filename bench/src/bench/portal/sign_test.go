@@ -0,0 +1,74 @@
+package portal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func newSignedResult(t *testing.T) *BenchResult {
+	result := &BenchResult{
+		JobID:     "1",
+		Pass:      true,
+		Score:     12345,
+		Logs:      []string{"Pass => Score: 12345"},
+		LoadLevel: 3,
+		StartTime: time.Date(2018, 10, 20, 10, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2018, 10, 20, 10, 1, 0, 0, time.UTC),
+	}
+	if err := SignResult("secret", result); err != nil {
+		t.Fatalf("sign failed: %s", err)
+	}
+	if result.Signature == "" {
+		t.Fatal("signature is empty after sign")
+	}
+	return result
+}
+
+func TestSignVerify(t *testing.T) {
+	result := newSignedResult(t)
+	if err := VerifyResult("secret", result); err != nil {
+		t.Errorf("verify failed: %s", err)
+	}
+
+	// ファイルに書いて読み直しても検証できる
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %s", err)
+	}
+	decoded := &BenchResult{}
+	if err = json.Unmarshal(b, decoded); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s", err)
+	}
+	if err = VerifyResult("secret", decoded); err != nil {
+		t.Errorf("verify after round trip failed: %s", err)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	tampers := map[string]func(*BenchResult){
+		"score":     func(r *BenchResult) { r.Score = 99999 },
+		"pass":      func(r *BenchResult) { r.Pass = false },
+		"log":       func(r *BenchResult) { r.Logs = append(r.Logs, "added") },
+		"signature": func(r *BenchResult) { r.Signature = r.Signature[1:] + r.Signature[:1] },
+	}
+	for name, tamper := range tampers {
+		result := newSignedResult(t)
+		tamper(result)
+		if err := VerifyResult("secret", result); err == nil {
+			t.Errorf("tampered %s was verified", name)
+		}
+	}
+
+	result := newSignedResult(t)
+	if err := VerifyResult("other", result); err == nil {
+		t.Error("verified with another key")
+	}
+	result.Signature = ""
+	if err := VerifyResult("secret", result); err == nil {
+		t.Error("verified without signature")
+	}
+	if err := SignResult("", result); err == nil {
+		t.Error("signed with an empty key")
+	}
+}
@@ -12,18 +12,111 @@ import (
 const Domain = "isucon8.flying-chair.net"
 
 type BenchResult struct {
-	JobID   string `json:"job_id"`
-	IPAddrs string `json:"ip_addrs"`
+	JobID     string `json:"job_id"`
+	IPAddrs   string `json:"ip_addrs"`
+	BenchNode string `json:"bench_node,omitempty"`
 
-	Pass      bool     `json:"pass"`
-	Score     int64    `json:"score"`
-	Message   string   `json:"message"`
-	Errors    []string `json:"error"`
-	Logs      []string `json:"log"`
-	LoadLevel int      `json:"load_level"`
+	Pass      bool           `json:"pass"`
+	Score     int64          `json:"score"`
+	Message   string         `json:"message"`
+	Errors    []string       `json:"error"`
+	ErrorsBy  map[string]int `json:"error_categories,omitempty"`
+	ErrorsAgg []ErrorGroup   `json:"error_groups,omitempty"`
+	Logs      []string       `json:"log"`
+	LoadLevel int            `json:"load_level"`
+	Seed      int64          `json:"seed"`
+
+	Latencies []LatencyResult  `json:"latencies,omitempty"`
+	Protocols map[string]int64 `json:"protocols,omitempty"`
+	Scores    []ScoreResult    `json:"score_breakdown,omitempty"`
+	Levels    []LevelResult    `json:"levels,omitempty"`
+
+	Retirements []RetireResult `json:"retirements,omitempty"`
+
+	Compression CompressionResult `json:"compression"`
+	Outcomes    RequestOutcomes   `json:"outcomes"`
+	LogUsage    LogUsage          `json:"log_usage"`
 
 	StartTime time.Time `json:"start_time"`
 	EndTime   time.Time `json:"end_time"`
+
+	Signature string `json:"signature,omitempty"` // SignResult で付けるHMAC-SHA256
+}
+
+// LatencyResult はエンドポイントごとのレイテンシ (秒)
+type LatencyResult struct {
+	Endpoint string  `json:"endpoint"`
+	Count    int64   `json:"count"`
+	Errors   int64   `json:"errors"`
+	Retries  int64   `json:"retries"`
+	P50      float64 `json:"p50"`
+	P95      float64 `json:"p95"`
+	P99      float64 `json:"p99"`
+
+	Status       map[string]int64 `json:"status,omitempty"` // 2xx, 3xx, 4xx, 5xx, failed ごとの数
+	Availability float64          `json:"availability"`     // 2xx, 3xx で返せた割合
+}
+
+// ErrorGroup はIDや数値を伏せると同じになるエラーの集計
+type ErrorGroup struct {
+	Endpoint string    `json:"endpoint,omitempty"`
+	Message  string    `json:"message"`
+	Count    int       `json:"count"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+}
+
+// ScoreResult は種類ごとの獲得スコア
+type ScoreResult struct {
+	Type  string `json:"type"`
+	Count int64  `json:"count"`
+	Score int64  `json:"score"`
+}
+
+// LevelResult はlevelごとの記録. Duration, AvgLatency は秒
+type LevelResult struct {
+	Level      int     `json:"level"`
+	Duration   float64 `json:"duration"`
+	Score      int64   `json:"score"`
+	Errors     int     `json:"errors"`
+	Users      int     `json:"users"`
+	Requests   int64   `json:"requests"`
+	AvgLatency float64 `json:"avg_latency"`
+}
+
+// RetireResult は退役した理由ごとのユーザー数
+// Reason は timeout, errors, signin のどれかで、Endpoint は退役するきっかけになったリクエスト
+type RetireResult struct {
+	Reason   string `json:"reason"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Users    int    `json:"users"`
+}
+
+// CompressionResult はgzipで圧縮されていたレスポンスの集計
+type CompressionResult struct {
+	Responses         int64 `json:"responses"`
+	GzipResponses     int64 `json:"gzip_responses"`
+	CompressedBytes   int64 `json:"compressed_bytes"`
+	UncompressedBytes int64 `json:"uncompressed_bytes"`
+	SavedBytes        int64 `json:"saved_bytes"`
+}
+
+// RequestOutcomes はリクエストの結果ごとの数
+// Cancelled は負荷走行の終了などで中断されたもの, TimedOut はタイムアウトしたもの
+type RequestOutcomes struct {
+	Completed int64 `json:"completed"`
+	Cancelled int64 `json:"cancelled"`
+	TimedOut  int64 `json:"timed_out"`
+	Failed    int64 `json:"failed"`
+}
+
+// LogUsage はアプリがisulogにログを送った方法の集計
+// BulkRatio は send_bulk で送られたログの割合
+type LogUsage struct {
+	Send      int64   `json:"send"`
+	SendBulk  int64   `json:"send_bulk"`
+	BulkLogs  int64   `json:"bulk_logs"`
+	BulkRatio float64 `json:"bulk_ratio"`
 }
 
 type Job struct {
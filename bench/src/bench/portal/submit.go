@@ -0,0 +1,114 @@
+package portal
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	SignatureHeader = "X-Bench-Signature"
+	TimestampHeader = "X-Bench-Timestamp"
+	NodeHeader      = "X-Bench-Node"
+)
+
+// Submitter はベンチの結果をportalに送る
+type Submitter struct {
+	URL    string
+	Secret string // 空なら署名しない
+	NodeID string
+
+	Retry      int           // 失敗したときに再送する回数
+	RetryDelay time.Duration // 最初の再送までの時間. 再送するごとに倍にする
+	Client     *http.Client
+}
+
+func NewSubmitter(url, secret, node string) *Submitter {
+	return &Submitter{
+		URL:        url,
+		Secret:     secret,
+		NodeID:     node,
+		Retry:      3,
+		RetryDelay: 2 * time.Second,
+		Client:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Sign は timestamp と body に対するHMAC-SHA256の16進文字列を返す
+// portal側は同じ計算をして SignatureHeader と比べる
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Submit は result をJSONでPOSTする. 通信エラーと5xxの場合は再送する
+func (s *Submitter) Submit(ctx context.Context, result BenchResult) error {
+	if result.BenchNode == "" {
+		result.BenchNode = s.NodeID
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal failed")
+	}
+	delay := s.RetryDelay
+	for try := 0; ; try++ {
+		retry, err := s.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || try >= s.Retry {
+			return err
+		}
+		log.Printf("[WARN] submit result failed. retry after %s. err: %s, try: %d", delay, err, try)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (s *Submitter) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.Wrap(err, "http.NewRequest failed")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if s.NodeID != "" {
+		req.Header.Set(NodeHeader, s.NodeID)
+	}
+	if s.Secret != "" {
+		ts := time.Now().Unix()
+		req.Header.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+		req.Header.Set(SignatureHeader, Sign(s.Secret, ts, body))
+	}
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return true, errors.Wrap(err, "request failed")
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return true, errors.Wrap(err, "ioutil.ReadAll")
+	}
+	if res.StatusCode >= 400 {
+		return res.StatusCode >= 500, errors.Errorf("status code is not success. code: %d, body: %s", res.StatusCode, string(b))
+	}
+	return false, nil
+}
@@ -0,0 +1,147 @@
+package bench
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	PriorityCheckMargin = 1 * time.Second  // 成約の前後にこれだけ開いていた注文だけを調べる
+	PriorityCheckTrades = 1000             // 調べる成約の最大数 (新しい順)
+	TradeLivenessWindow = 10 * time.Second // 売りと買いが交差してからこの間に一件も成約がなければエラー
+)
+
+// verifyPriority は負荷走行中に見た注文から板を組み立て直し、成約が価格優先・時間優先になっているかを確かめる
+//
+// 成約の価格は後から来た注文 (taker) の価格なので、それより安い売り注文は板にあった注文 (target) と分かる
+// target より安いか、同じ価格で先に出された売り注文が、target 以下の数量で成約の前後も開いていたなら、
+// その注文を飛ばして成約させている
+// 買い注文は残高不足で飛ばされることがあるので調べない
+func (r *idRegistry) verifyPriority() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sells := make([]*seenOrder, 0, len(r.orders)/2)
+	for _, s := range r.orders {
+		if s.typ == TradeTypeSell && !s.seenAt.IsZero() {
+			sells = append(sells, s)
+		}
+	}
+	// 板の順 (価格の安い順, 出された順)
+	sort.Slice(sells, func(i, j int) bool {
+		if sells[i].price != sells[j].price {
+			return sells[i].price < sells[j].price
+		}
+		if !sells[i].createdAt.Equal(sells[j].createdAt) {
+			return sells[i].createdAt.Before(sells[j].createdAt)
+		}
+		return sells[i].id < sells[j].id
+	})
+
+	trades := make([]Trade, 0, len(r.trades))
+	for _, t := range r.trades {
+		trades = append(trades, t)
+	}
+	sort.Slice(trades, func(i, j int) bool { return trades[i].CreatedAt.After(trades[j].CreatedAt) })
+	if len(trades) > PriorityCheckTrades {
+		trades = trades[:PriorityCheckTrades]
+	}
+	targets := make(map[int64][]*seenOrder, len(trades))
+	for _, t := range trades {
+		targets[t.ID] = nil
+	}
+	for _, s := range sells {
+		if t, ok := r.trades[s.tradeID]; ok && s.price < t.Price {
+			if _, checked := targets[t.ID]; checked {
+				targets[t.ID] = append(targets[t.ID], s)
+			}
+		}
+	}
+
+	for _, t := range trades {
+		for _, target := range targets[t.ID] {
+			for _, o := range sells {
+				if o == target {
+					break
+				}
+				if o.tradeID == t.ID || o.amount > target.amount || !o.openAround(t.CreatedAt) {
+					continue
+				}
+				return errors.Errorf("成約が価格優先・時間優先になっていません [trade_id:%d, order_id:%d, price:%d, skipped order_id:%d, skipped price:%d]",
+					t.ID, target.id, target.price, o.id, o.price)
+			}
+		}
+	}
+	return nil
+}
+
+// openAround は注文が at の前後 PriorityCheckMargin の間ずっと開いていたことが分かっているか
+func (s *seenOrder) openAround(at time.Time) bool {
+	if s.createdAt.IsZero() || !s.createdAt.Before(at.Add(-PriorityCheckMargin)) {
+		return false
+	}
+	return s.openUntil().After(at.Add(PriorityCheckMargin))
+}
+
+// verifyLiveness は別々のユーザーの売り注文と買い注文の価格が交差していたのに、
+// TradeLivenessWindow の間ひとつも成約しなかったことがないかを chart_by_sec で確かめる
+// 数量の組み合わせ次第で交差したまま残る注文はあるが、その間も他の注文は成約していくはず
+// 外部サービスの障害中や負荷走行の後は成約しなくてもよいので、from から to の間だけを調べる
+func (r *idRegistry) verifyLiveness(chart []CandlestickData, from, to time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// 長く開いていた注文だけが交差したまま残りうる
+	var sells, buys []*seenOrder
+	for _, s := range r.orders {
+		if s.createdAt.IsZero() || s.openUntil().Sub(s.createdAt) <= TradeLivenessWindow {
+			continue
+		}
+		switch s.typ {
+		case TradeTypeSell:
+			sells = append(sells, s)
+		case TradeTypeBuy:
+			buys = append(buys, s)
+		}
+	}
+	for _, so := range sells {
+		for _, bo := range buys {
+			if so.userID == bo.userID || so.price > bo.price {
+				continue
+			}
+			start, end := from, to
+			for _, o := range []*seenOrder{so, bo} {
+				if o.createdAt.After(start) {
+					start = o.createdAt
+				}
+				if until := o.openUntil(); until.Before(end) {
+					end = until
+				}
+			}
+			if end.Sub(start) <= TradeLivenessWindow+PriorityCheckMargin {
+				continue
+			}
+			if !hasCandleBetween(chart, start, end) {
+				return errors.Errorf("売り注文と買い注文が交差したまま%s成約がありませんでした [sell order_id:%d, price:%d, user_id:%d, buy order_id:%d, price:%d, user_id:%d, since:%s]",
+					end.Sub(start).Truncate(time.Second), so.id, so.price, so.userID, bo.id, bo.price, bo.userID, start)
+			}
+		}
+	}
+	return nil
+}
+
+// openUntil は注文が開いていたことが分かっている最後の時刻
+func (s *seenOrder) openUntil() time.Time {
+	if s.closedAt != nil {
+		return *s.closedAt
+	}
+	return s.seenAt
+}
+
+// hasCandleBetween は時刻順の chart に start から end の間の足があるか
+func hasCandleBetween(chart []CandlestickData, start, end time.Time) bool {
+	i := sort.Search(len(chart), func(i int) bool { return !chart[i].Time.Before(start.Truncate(time.Second)) })
+	return i < len(chart) && !chart[i].Time.After(end)
+}
@@ -16,3 +16,8 @@ func init() {
 func Generate() string {
 	return lastnames[rand.Intn(lastnameLen)] + Separator + firstnames[rand.Intn(firstnameLen)]
 }
+
+// GenerateFrom は r から引いて名前を作る
+func GenerateFrom(r *rand.Rand) string {
+	return lastnames[r.Intn(lastnameLen)] + Separator + firstnames[r.Intn(firstnameLen)]
+}
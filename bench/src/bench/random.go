@@ -1,38 +1,187 @@
 package bench
 
 import (
-	"github.com/Songmu/strrand"
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"time"
+
 	"bench/randnameja"
 )
 
+// 乱数列の用途. 同じ seed とユーザーでも用途ごとに別の乱数列にする
+const (
+	streamUser     = 1 // 名前, パスワード, シナリオの判断
+	streamInvestor = 2 // investor.mix からユーザーの種類を選ぶ
+	streamBankID   = 3 // 負荷走行で作る bank_id
+)
+
 type Random struct {
-	passGen strrand.Generator
-	idGen   strrand.Generator
+	seed  int64
+	mu    sync.Mutex
+	ids   *rand.Rand // bank_id. 作る順に引く
+	appID *rand.Rand // isubank, isulog の app_id. 前の実行のデータと混ざらないように seed によらない
 }
 
-func NewRandom() (*Random, error) {
-	passGen, err := strrand.New().CreateGenerator(`[abcdefghjkmnpqrstuvwxyz23456789]{12,16}`)
-	if err != nil {
-		return nil, err
+// seedが0のときはランダムなseedを使う
+// ユーザーごとの名前、パスワード、シナリオの判断は User で seed とユーザーの番号から作った乱数から引くので、同じseedなら同じ番号のユーザーは同じように振る舞う
+// ユーザーに紐付かないもの (負荷の揺らぎ, 障害注入, 事前テストなど) はmath/randのグローバルな乱数を使うのでここで初期化する
+func NewRandom(seed int64) (*Random, error) {
+	if seed == 0 {
+		seed = newSeed()
 	}
-	idGen, err := strrand.New().CreateGenerator(`[abcdefghjkmnpqrstuvwxyz23456789_-]{6,12}`)
-	if err != nil {
-		return nil, err
+	rand.Seed(seed)
+	b := &Random{
+		seed:  seed,
+		appID: rand.New(rand.NewSource(newSeed())),
+	}
+	b.ids = rand.New(rand.NewSource(b.derive(streamBankID, 0)))
+	return b, nil
+}
+
+func newSeed() int64 {
+	var s int64
+	if err := binary.Read(crand.Reader, binary.LittleEndian, &s); err != nil {
+		s = time.Now().UnixNano()
 	}
-	return &Random{
-		passGen: passGen,
-		idGen:   idGen,
-	}, nil
+	return s
 }
 
-func (b *Random) Password() string {
-	return b.passGen.Generate()
+func (b *Random) Seed() int64 {
+	return b.seed
 }
 
-func (b *Random) Name() string {
-	return randnameja.Generate()
+// derive は seed と用途とユーザーの番号から乱数の種を作る
+// splitmix64 で混ぜて、番号が近いユーザー同士の乱数列が似ないようにする
+func (b *Random) derive(stream, n int64) int64 {
+	z := uint64(b.seed) + uint64(stream)*0x9e3779b97f4a7c15 + uint64(n)*0xd1b54a32d192ed03
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
 }
 
+func (b *Random) source(stream, n int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(b.derive(stream, n))})
+}
+
+// User は n 番目に開始したユーザーの乱数
+func (b *Random) User(n int64) UserRand {
+	return UserRand{b.source(streamUser, n)}
+}
+
+// ID は負荷走行で作る bank_id. 同じseedなら同じ順に同じ bank_id を作る
 func (b *Random) ID() string {
-	return b.idGen.Generate()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return randomString(b.ids, idChars, 6, 12)
+}
+
+// AppID は isubank, isulog の app_id
+func (b *Random) AppID() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return randomString(b.appID, idChars, 6, 12)
+}
+
+// lockedSource は同じユーザーの複数のリクエストから同時に引けるようにする
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+// globalSource はmath/randのグローバルな乱数. ユーザーに紐付かない Client が使う
+type globalSource struct{}
+
+func (globalSource) Int63() int64 { return rand.Int63() }
+func (globalSource) Seed(int64)   {}
+
+var globalRand = rand.New(globalSource{})
+
+const (
+	passChars = "abcdefghjkmnpqrstuvwxyz23456789"
+	idChars   = "abcdefghjkmnpqrstuvwxyz23456789_-"
+)
+
+// randomString は chars から選んだ min 文字から max 文字の文字列
+func randomString(r *rand.Rand, chars string, min, max int) string {
+	b := make([]byte, min+r.Intn(max-min+1))
+	for i := range b {
+		b[i] = chars[r.Intn(len(chars))]
+	}
+	return string(b)
+}
+
+// UserRand は1人のユーザーの乱数
+type UserRand struct {
+	*rand.Rand
+}
+
+// Password は ExoticUserPercent の割合で絵文字や多バイト文字を含むパスワードを返す
+func (r UserRand) Password() string {
+	p := randomString(r.Rand, passChars, 12, 16)
+	if r.Intn(100) < ExoticUserPercent {
+		return exoticPassword(r.Rand, p)
+	}
+	return p
+}
+
+// Name は ExoticUserPercent の割合で絵文字を含む名前や最大長の名前を返す
+func (r UserRand) Name() string {
+	if r.Intn(100) < ExoticUserPercent {
+		return exoticName(r.Rand)
+	}
+	return randnameja.GenerateFrom(r.Rand)
+}
+
+// 4バイトの文字, 結合文字, 異体字セレクタ, ZWJで繋いだ絵文字など、文字数の数え方で壊れやすいもの
+var exoticChars = []string{
+	"🍣", "🪑", "💺", "😇", "🇯🇵", "👨‍👩‍👧", "👍🏽", "𠮷", "𩸽", "葛󠄀", "ｶﾞｷﾞ", "e\u0301", "ß", "Ωμέγα", "한국어", "العربية", "\\", "'\"",
+}
+
+func exoticChar(r *rand.Rand) string {
+	return exoticChars[r.Intn(len(exoticChars))]
+}
+
+func exoticName(r *rand.Rand) string {
+	switch r.Intn(3) {
+	case 0:
+		return randnameja.GenerateFrom(r) + exoticChar(r)
+	case 1:
+		return exoticChar(r) + randnameja.GenerateFrom(r) + exoticChar(r)
+	default:
+		// DBのカラムに入る最大の文字数ちょうど
+		rs := make([]rune, 0, UserNameMaxLength+10)
+		for len(rs) < UserNameMaxLength {
+			rs = append(rs, []rune(randnameja.GenerateFrom(r)+exoticChar(r))...)
+		}
+		return string(rs[:UserNameMaxLength])
+	}
+}
+
+// exoticPassword は bcrypt で切り捨てられないように UserPasswordMaxBytes に収める
+func exoticPassword(r *rand.Rand, base string) string {
+	p := base
+	for {
+		c := exoticChar(r)
+		if len(p)+len(c) > UserPasswordMaxBytes {
+			return p
+		}
+		p += c
+		if r.Intn(4) == 0 {
+			return p
+		}
+	}
 }
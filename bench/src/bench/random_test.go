@@ -0,0 +1,32 @@
+package bench
+
+import "testing"
+
+func TestRandomUserReproducible(t *testing.T) {
+	a, _ := NewRandom(42)
+	b, _ := NewRandom(42)
+	for n := int64(1); n <= 20; n++ {
+		ua, ub := a.User(n), b.User(n)
+		if na, nb := ua.Name(), ub.Name(); na != nb {
+			t.Errorf("unexpected name of user %d: got:%s expected:%s", n, nb, na)
+		}
+		if pa, pb := ua.Password(), ub.Password(); pa != pb {
+			t.Errorf("unexpected password of user %d: got:%s expected:%s", n, pb, pa)
+		}
+		if da, db := ua.Intn(1000), ub.Intn(1000); da != db {
+			t.Errorf("unexpected decision of user %d: got:%d expected:%d", n, db, da)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		if ia, ib := a.ID(), b.ID(); ia != ib {
+			t.Errorf("unexpected bank_id %d: got:%s expected:%s", i, ib, ia)
+		}
+	}
+	if a.User(1).Password() == a.User(2).Password() {
+		t.Error("users 1 and 2 have the same password")
+	}
+	c, _ := NewRandom(43)
+	if a.User(1).Password() == c.User(1).Password() {
+		t.Error("another seed gave the same password")
+	}
+}
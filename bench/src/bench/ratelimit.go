@@ -0,0 +1,43 @@
+package bench
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter はすべてのClientで共有して、ベンチ全体のリクエスト数を rps 以下に抑える
+// 負荷を固定して比べたいとき用で、nilなら制限しない
+type rateLimiter struct {
+	mu     sync.Mutex
+	bucket *tokenBucket
+}
+
+func newRateLimiter(rps int) *rateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	return &rateLimiter{bucket: newTokenBucket(rps, rps)}
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	d := l.bucket.reserve()
+	l.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	if ctx == nil {
+		time.Sleep(d)
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,161 @@
+package bench
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// InvestorFactory は負荷走行で追加するユーザーを作る. n は何人目に追加されるユーザーか
+type InvestorFactory func(m *Manager, n int32) (Scenario, error)
+
+var (
+	investorsMu sync.Mutex
+	investors   = map[string]InvestorFactory{}
+)
+
+// RegisterInvestor は config の investor.mix で選べるユーザーの種類を追加する
+// 同じ名前で二回登録すると panic する
+func RegisterInvestor(name string, f InvestorFactory) {
+	investorsMu.Lock()
+	defer investorsMu.Unlock()
+	if f == nil {
+		panic("bench: RegisterInvestor factory is nil")
+	}
+	if _, dup := investors[name]; dup {
+		panic("bench: RegisterInvestor called twice for " + name)
+	}
+	investors[name] = f
+}
+
+// Investors は登録されているユーザーの種類の名前
+func Investors() []string {
+	investorsMu.Lock()
+	defer investorsMu.Unlock()
+	names := make([]string, 0, len(investors))
+	for name := range investors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupInvestor(name string) (InvestorFactory, bool) {
+	investorsMu.Lock()
+	defer investorsMu.Unlock()
+	f, ok := investors[name]
+	return f, ok
+}
+
+func init() {
+	RegisterInvestor("normal", func(m *Manager, n int32) (Scenario, error) {
+		credit, isu, unit := int64(35000), int64(7), int64(3)
+		if n < 16 {
+			credit, isu, unit = 30000, 5, 1
+		}
+		cl, err := m.NewUserClient(n, credit)
+		if err != nil {
+			return nil, err
+		}
+		return NewNormalScenario(cl, credit, isu, unit, false), nil
+	})
+	RegisterInvestor("market_maker", func(m *Manager, n int32) (Scenario, error) {
+		var credit int64 = 50000
+		cl, err := m.NewUserClient(n, credit)
+		if err != nil {
+			return nil, err
+		}
+		return NewMarketMakerScenario(cl, credit, 10, 2, m.InvestorConfig().MarketMakerSpread), nil
+	})
+	RegisterInvestor("scalper", func(m *Manager, n int32) (Scenario, error) {
+		var credit int64 = 20000
+		cl, err := m.NewUserClient(n, credit)
+		if err != nil {
+			return nil, err
+		}
+		ic := m.InvestorConfig()
+		return NewScalperScenario(cl, credit, 20, ic.ScalperRate, ic.ScalperBurst), nil
+	})
+	RegisterInvestor("panic_seller", func(m *Manager, n int32) (Scenario, error) {
+		var credit int64 = 30000
+		cl, err := m.NewUserClient(n, credit)
+		if err != nil {
+			return nil, err
+		}
+		ic := m.InvestorConfig()
+		return NewPanicSellerScenario(cl, credit, 30, 3, ic.PanicSellDropPercent, time.Duration(ic.PanicSellWindow)*time.Second), nil
+	})
+	RegisterInvestor("chart_watcher", func(m *Manager, n int32) (Scenario, error) {
+		cl, err := m.newUserClient(n, "", "", "")
+		if err != nil {
+			return nil, err
+		}
+		return NewChartWatcherScenario(cl, ChartWatchInterval), nil
+	})
+}
+
+// NewUserClient は n 人目に追加する新規登録のユーザーのClientを作り、銀行に credit を入金しておく
+// 名前、パスワード、シナリオの判断は n から決まる乱数から引く
+func (c *Manager) NewUserClient(n int32, credit int64) (*Client, error) {
+	id, err := c.FetchNewID()
+	if err != nil {
+		return nil, err
+	}
+	r := c.rand.User(int64(n))
+	cl, err := c.newClient(id, r.Name(), r.Password())
+	if err != nil {
+		return nil, err
+	}
+	cl.rand = r.Rand
+	if credit > 0 {
+		c.isubank.AddCredit(cl.bankid, credit)
+	}
+	return cl, nil
+}
+
+func (c *Manager) InvestorConfig() InvestorConfig {
+	return c.conf.Investor
+}
+
+// newInvestor は investor.mix (Planがあれば今のフェーズのmix) の重みに従ってユーザーの種類を選ぶ
+func (c *Manager) newInvestor(n int32) (Scenario, error) {
+	mix := c.investorMix()
+	names := make([]string, 0, len(mix))
+	var total int
+	for name, w := range mix {
+		if w > 0 {
+			names = append(names, name)
+			total += w
+		}
+	}
+	// seedを固定したときに同じ順番で選ばれるようにする
+	sort.Strings(names)
+	r := c.rand.source(streamInvestor, int64(n)).Intn(total)
+	for _, name := range names {
+		if r < mix[name] {
+			f, _ := lookupInvestor(name)
+			return f(c, n)
+		}
+		r -= mix[name]
+	}
+	return nil, errors.Errorf("investor not selected")
+}
+
+func validateInvestorMix(mix map[string]int) error {
+	var total int
+	for name, w := range mix {
+		if _, ok := lookupInvestor(name); !ok {
+			return errors.Errorf("config investor.mix: unknown investor %s (available: %v)", name, Investors())
+		}
+		if w < 0 {
+			return errors.Errorf("config investor.mix: weight of %s must not be negative", name)
+		}
+		total += w
+	}
+	if total == 0 {
+		return errors.Errorf("config investor.mix: no investor is enabled")
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"bench/portal"
+)
+
+// recordTimeline は負荷走行中 StreamInterval ごとにSnapshotを記録する
+func (c *Manager) recordTimeline(ctx context.Context) {
+	for {
+		s := c.Snapshot()
+		c.timelineLock.Lock()
+		c.timeline = append(c.timeline, s)
+		c.timelineLock.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(StreamInterval):
+		}
+	}
+}
+
+// Timeline は負荷走行中に記録したSnapshot
+func (c *Manager) Timeline() []Snapshot {
+	c.timelineLock.Lock()
+	defer c.timelineLock.Unlock()
+	r := make([]Snapshot, len(c.timeline))
+	copy(r, c.timeline)
+	return r
+}
+
+const chartWidth, chartHeight = 800, 240
+
+// scorePolyline はスコアの推移をSVGのpolylineの座標にする
+func scorePolyline(timeline []Snapshot) string {
+	if len(timeline) < 2 {
+		return ""
+	}
+	start := timeline[0].Time
+	span := timeline[len(timeline)-1].Time.Sub(start).Seconds()
+	var max int64 = 1
+	for _, s := range timeline {
+		if s.Score > max {
+			max = s.Score
+		}
+	}
+	points := make([]string, 0, len(timeline))
+	for _, s := range timeline {
+		x := float64(chartWidth) * s.Time.Sub(start).Seconds() / span
+		y := float64(chartHeight) * (1 - float64(s.Score)/float64(max))
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	return strings.Join(points, " ")
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"mul100": func(f float64) float64 { return f * 100 },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>isucon8 bench report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.pass { color: #2a2; } .fail { color: #c22; }
+svg { background: #fafafa; border: 1px solid #ccc; margin-bottom: 2em; }
+</style>
+</head>
+<body>
+<h1>Score: {{.Result.Score}} <span class="{{if .Result.Pass}}pass{{else}}fail{{end}}">{{if .Result.Pass}}PASS{{else}}FAIL{{end}}</span></h1>
+<p>{{.Result.Message}}</p>
+<p>level: {{.Result.LoadLevel}}, seed: {{.Result.Seed}}, {{.Result.StartTime.Format "2006-01-02 15:04:05"}} - {{.Result.EndTime.Format "15:04:05"}}</p>
+
+<h2>Score timeline</h2>
+{{if .Points}}<svg width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+<polyline fill="none" stroke="#36c" stroke-width="2" points="{{.Points}}"/>
+</svg>{{else}}<p>no data</p>{{end}}
+
+<h2>Levels</h2>
+<table>
+<tr><th>level</th><th>duration</th><th>score</th><th>errors</th><th>users</th><th>requests</th><th>avg latency</th></tr>
+{{range .Result.Levels}}<tr><td>{{.Level}}</td><td>{{printf "%.1fs" .Duration}}</td><td>{{.Score}}</td><td>{{.Errors}}</td><td>{{.Users}}</td><td>{{.Requests}}</td><td>{{printf "%.3fs" .AvgLatency}}</td></tr>
+{{end}}</table>
+
+<h2>Retirements</h2>
+<table>
+<tr><th>reason</th><th>endpoint</th><th>users</th></tr>
+{{range .Result.Retirements}}<tr><td>{{.Reason}}</td><td>{{.Endpoint}}</td><td>{{.Users}}</td></tr>
+{{end}}</table>
+
+<h2>Latency</h2>
+<table>
+<tr><th>endpoint</th><th>count</th><th>errors</th><th>retries</th><th>availability</th><th>p50</th><th>p95</th><th>p99</th></tr>
+{{range .Result.Latencies}}<tr><td>{{.Endpoint}}</td><td>{{.Count}}</td><td>{{.Errors}}</td><td>{{.Retries}}</td><td>{{printf "%.1f%%" (mul100 .Availability)}}</td><td>{{printf "%.3fs" .P50}}</td><td>{{printf "%.3fs" .P95}}</td><td>{{printf "%.3fs" .P99}}</td></tr>
+{{end}}</table>
+
+<h2>Score breakdown</h2>
+<table>
+<tr><th>type</th><th>count</th><th>score</th></tr>
+{{range .Result.Scores}}<tr><td>{{.Type}}</td><td>{{.Count}}</td><td>{{.Score}}</td></tr>
+{{end}}</table>
+
+<h2>Errors</h2>
+<table>
+<tr><th>category</th><th>count</th></tr>
+{{range $cat, $n := .Result.ErrorsBy}}<tr><td>{{$cat}}</td><td>{{$n}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>message</th><th>count</th><th>first</th><th>last</th></tr>
+{{range .Result.ErrorsAgg}}<tr><td>{{.Message}}</td><td>{{.Count}}</td><td>{{.First.Format "15:04:05"}}</td><td>{{.Last.Format "15:04:05"}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// WriteHTMLReport は結果とスコアの推移をHTMLにして書き出す
+func WriteHTMLReport(w io.Writer, result portal.BenchResult, timeline []Snapshot) error {
+	return reportTemplate.Execute(w, struct {
+		Result        portal.BenchResult
+		Points        string
+		Width, Height int
+	}{
+		Result: result,
+		Points: scorePolyline(timeline),
+		Width:  chartWidth,
+		Height: chartHeight,
+	})
+}
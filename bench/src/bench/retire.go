@@ -0,0 +1,130 @@
+package bench
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"bench/portal"
+)
+
+// ユーザーが退役した理由
+const (
+	RetireReasonTimeout = "timeout" // リクエストが遅かった
+	RetireReasonErrors  = "errors"  // 続けてリクエストに失敗した
+	RetireReasonSignin  = "signin"  // ログインできなかった
+)
+
+// retirePolicy は負荷走行のユーザーが退役するまでの時間を今のlevelから決める
+// levelが上がるほど短くして、高負荷でも応答が速いことを求める
+type retirePolicy struct {
+	conf  RetireConfig
+	base  time.Duration // level 0 での退役するまでの時間 (client.retire_timeout)
+	level uint32
+}
+
+func newRetirePolicy(conf RetireConfig, base time.Duration) *retirePolicy {
+	return &retirePolicy{conf: conf, base: base}
+}
+
+func (p *retirePolicy) setLevel(level uint) {
+	atomic.StoreUint32(&p.level, uint32(level))
+}
+
+// timeout は今のlevelで退役するまでの時間. level_step(%) ずつ縮め、min_timeout より短くはしない
+func (p *retirePolicy) timeout() time.Duration {
+	rate := 100 - p.conf.LevelStep*int(atomic.LoadUint32(&p.level))
+	d := p.base * time.Duration(rate) / 100
+	if min := time.Duration(p.conf.MinTimeout) * time.Millisecond; d < min {
+		d = min
+	}
+	if d > p.base {
+		d = p.base
+	}
+	return d
+}
+
+func (p *retirePolicy) p95() bool {
+	return p.conf.Policy == "p95"
+}
+
+// latencyWindow はユーザーの直近のリクエストのレイテンシ
+type latencyWindow struct {
+	mu   sync.Mutex
+	buf  []time.Duration
+	next int
+	full bool
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	return &latencyWindow{buf: make([]time.Duration, size)}
+}
+
+// add は d を記録して、直近 size 回の p95 を返す. まだ size 回に満たなければ false
+func (w *latencyWindow) add(d time.Duration) (time.Duration, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf[w.next] = d
+	w.next = (w.next + 1) % len(w.buf)
+	if w.next == 0 {
+		w.full = true
+	}
+	if !w.full {
+		return 0, false
+	}
+	sorted := make([]time.Duration, len(w.buf))
+	copy(sorted, w.buf)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[(len(sorted)*95+99)/100-1], true
+}
+
+type retireKey struct {
+	reason   string
+	endpoint string
+}
+
+// retireStats は退役したユーザーを理由ごとに数える
+// 負荷走行の最後にユーザーが全滅していたときに、なぜ退役したのかがわかるようにする
+type retireStats struct {
+	mu    sync.Mutex
+	count map[retireKey]int
+}
+
+func newRetireStats() *retireStats {
+	return &retireStats{count: make(map[retireKey]int, 10)}
+}
+
+func (s *retireStats) add(reason, endpoint string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count[retireKey{reason, endpoint}]++
+}
+
+// results は退役したユーザーが多い順の内訳
+func (s *retireStats) results() []portal.RetireResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := make([]portal.RetireResult, 0, len(s.count))
+	for k, n := range s.count {
+		r = append(r, portal.RetireResult{Reason: k.reason, Endpoint: k.endpoint, Users: n})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].Users != r[j].Users {
+			return r[i].Users > r[j].Users
+		}
+		if r[i].Reason != r[j].Reason {
+			return r[i].Reason < r[j].Reason
+		}
+		return r[i].Endpoint < r[j].Endpoint
+	})
+	return r
+}
+
+// Retirements は負荷走行で退役したユーザーの理由ごとの内訳
+func (c *Manager) Retirements() []portal.RetireResult {
+	return c.retirees.results()
+}
@@ -0,0 +1,90 @@
+package bench
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+
+	"bench/portal"
+)
+
+// Options は Run で1回の負荷走行をするための設定
+type Options struct {
+	AppEndpoint  string // カンマ区切りで複数指定できる
+	BankEndpoint string
+	LogEndpoint  string
+	InternalBank string // 空なら BankEndpoint
+	InternalLog  string // 空なら LogEndpoint
+	StateFile    string // 最終的な状態を保存するファイル. 空なら保存しない
+	JobID        string
+
+	Config *Config              // nil なら DefaultConfig
+	Log    io.Writer            // ログの出力先. nil なら捨てる
+	SLA    SLA                  // 満たしていなければ結果を返した上でエラーにする
+	Setup  func(*Manager) error // 負荷走行の前に Manager の機能を有効にしたり、状態を配信したりする
+}
+
+// Result は負荷走行の結果. portal に送る結果の他に、レポートを作るための記録を持つ
+type Result struct {
+	portal.BenchResult
+	Timeline []Snapshot
+	Shares   []ShareEvent
+	Checks   *CheckRecorder
+}
+
+// Run は Initialize, PreTest, 負荷走行, PostTest を行って結果を返す
+// ベンチマーカーをコマンドとして起動せずに、portal や回帰テストから呼び出すためのもの
+// 負荷走行に失敗してもスコアを0にした結果を返す. Manager を作れなかったときだけ結果は空になる
+func Run(ctx context.Context, opts Options) (Result, error) {
+	out := opts.Log
+	if out == nil {
+		out = ioutil.Discard
+	}
+	internalbank, internallog := opts.InternalBank, opts.InternalLog
+	if internalbank == "" {
+		internalbank = opts.BankEndpoint
+	}
+	if internallog == "" {
+		internallog = opts.LogEndpoint
+	}
+	mgr, err := NewManager(out, opts.AppEndpoint, opts.BankEndpoint, opts.LogEndpoint, internalbank, internallog, opts.StateFile, opts.Config)
+	if err != nil {
+		return Result{}, err
+	}
+	defer func() {
+		tctx, cancel := context.WithTimeout(context.Background(), TeardownLimit)
+		defer cancel()
+		mgr.Close(tctx)
+	}()
+	if opts.Setup != nil {
+		if err = opts.Setup(mgr); err != nil {
+			return Result{}, err
+		}
+	}
+	result, err := mgr.Run(ctx)
+	result.JobID = opts.JobID
+	result.IPAddrs = opts.AppEndpoint
+	if err != nil {
+		return result, err
+	}
+	return result, mgr.CheckSLA(opts.SLA)
+}
+
+// Run は負荷走行をして結果を返す. 失敗したときは結果の Message にその理由を入れる
+func (c *Manager) Run(ctx context.Context) (Result, error) {
+	bm := NewRunner(c)
+	msg := "ok"
+	err := bm.Run(ctx)
+	if err != nil {
+		msg = Translate(err.Error())
+		c.Logger().Print(msg)
+	}
+	result := Result{
+		BenchResult: bm.Result(),
+		Timeline:    c.Timeline(),
+		Shares:      c.ShareEvents(),
+		Checks:      c.Checks(),
+	}
+	result.Message = msg
+	return result, err
+}
@@ -32,9 +32,75 @@ func (r *Runner) Result() portal.BenchResult {
 	level := r.mgr.GetLevel()
 	errors := r.mgr.GetErrorsString()
 	if score > 0 {
-		r.mgr.Logger().Printf("Pass => Score: %d, (level: %d, errors: %d, users: %d/%d)", score, level, r.mgr.ErrorCount(), r.mgr.ActiveUsers(), r.mgr.AllUsers())
+		r.mgr.Logger().Printf("Pass => Score: %d, (level: %d, errors: %d, users: %d/%d, seed: %d)", score, level, r.mgr.ErrorCount(), r.mgr.ActiveUsers(), r.mgr.AllUsers(), r.mgr.Seed())
 	} else {
-		r.mgr.Logger().Printf("Fail => Score: %d, (level: %d, errors: %d, users: %d/%d, score:%d)", score, level, r.mgr.ErrorCount(), r.mgr.ActiveUsers(), r.mgr.AllUsers(), r.mgr.TotalScore())
+		r.mgr.Logger().Printf("Fail => Score: %d, (level: %d, errors: %d, users: %d/%d, score:%d, seed: %d)", score, level, r.mgr.ErrorCount(), r.mgr.ActiveUsers(), r.mgr.AllUsers(), r.mgr.TotalScore(), r.mgr.Seed())
+	}
+
+	errorsBy := r.mgr.ErrorCountByCategory()
+	for _, cat := range errorCategories {
+		if n := errorsBy[cat.String()]; n > 0 {
+			r.mgr.Logger().Printf("errors %-10s: %d", cat, n)
+		}
+	}
+	errorGroups := r.mgr.ErrorGroups()
+	for _, eg := range errorGroups {
+		r.mgr.Logger().Printf("%s ×%d (first:%s, last:%s)", eg.Message, eg.Count, eg.First.Format("15:04:05"), eg.Last.Format("15:04:05"))
+	}
+
+	latencies := r.mgr.metrics.Latencies()
+	for _, l := range latencies {
+		r.mgr.Logger().Printf("%-32s count:%d, errors:%d, retries:%d, p50:%.3fs, p95:%.3fs, p99:%.3fs", l.Endpoint, l.Count, l.Errors, l.Retries, l.P50, l.P95, l.P99)
+	}
+
+	if n := r.mgr.IDFetchFailures(); n > 0 {
+		r.mgr.Logger().Printf("bank_id fetch failures: %d", n)
+	}
+
+	// どのAPIが失敗しているかを一覧にする
+	r.mgr.Logger().Printf("%-32s %8s %8s %8s %8s %8s %8s", "endpoint", "2xx", "3xx", "4xx", "5xx", "failed", "avail")
+	for _, l := range latencies {
+		r.mgr.Logger().Printf("%-32s %8d %8d %8d %8d %8d %7.1f%%", l.Endpoint, l.Status["2xx"], l.Status["3xx"], l.Status["4xx"], l.Status["5xx"], l.Status["failed"], l.Availability*100)
+	}
+
+	scores := r.mgr.scoreboard.Breakdown(r.mgr.conf.Score)
+	for _, s := range scores {
+		r.mgr.Logger().Printf("score %-16s: %d (count:%d)", s.Type, s.Score, s.Count)
+	}
+
+	levels := r.mgr.LevelResults()
+	if len(levels) > 0 {
+		r.mgr.Logger().Printf("level  duration    score  errors  users  requests  avg latency")
+	}
+	for _, l := range levels {
+		r.mgr.Logger().Printf("%5d  %7.1fs  %7d  %6d  %5d  %8d  %10.3fs", l.Level, l.Duration, l.Score, l.Errors, l.Users, l.Requests, l.AvgLatency)
+	}
+
+	// ユーザーが全滅したときになぜ退役したのかわかるようにする
+	retirements := r.mgr.Retirements()
+	for _, rr := range retirements {
+		r.mgr.Logger().Printf("retired %-8s %-24s: %d users", rr.Reason, rr.Endpoint, rr.Users)
+	}
+
+	if shares := r.mgr.ShareEvents(); len(shares) > 0 {
+		added := 0
+		for _, ev := range shares {
+			added += ev.Users
+		}
+		r.mgr.Logger().Printf("sns shares: %d (users added: %d)", len(shares), added)
+	}
+
+	compression := r.mgr.metrics.Compression()
+	if compression.GzipResponses > 0 {
+		r.mgr.Logger().Printf("gzip: %d/%d responses, saved %d bytes", compression.GzipResponses, compression.Responses, compression.SavedBytes)
+	}
+
+	outcomes := r.mgr.metrics.Outcomes()
+	r.mgr.Logger().Printf("requests completed:%d, cancelled:%d, timed out:%d, failed:%d", outcomes.Completed, outcomes.Cancelled, outcomes.TimedOut, outcomes.Failed)
+
+	protocols := r.mgr.metrics.Protocols()
+	for proto, n := range protocols {
+		r.mgr.Logger().Printf("protocol %s: %d requests", proto, n)
 	}
 
 	logs, _ := r.mgr.GetLogs()
@@ -42,52 +108,107 @@ func (r *Runner) Result() portal.BenchResult {
 		Pass:      score > 0,
 		Score:     score,
 		Errors:    errors,
+		ErrorsBy:  errorsBy,
+		ErrorsAgg: errorGroups,
 		Logs:      logs,
 		LoadLevel: int(level),
+		Seed:      r.mgr.Seed(),
+		Latencies: latencies,
+		Protocols: protocols,
+		Scores:    scores,
+		Levels:    levels,
+
+		Retirements: retirements,
+
+		Compression: compression,
+		Outcomes:    outcomes,
+		LogUsage:    r.mgr.logUsage,
 
 		StartTime: r.start,
 		EndTime:   r.end,
 	}
 }
 
-func (r *Runner) Run(ctx context.Context) error {
+func (r *Runner) Run(ctx context.Context) (err error) {
 	m := r.mgr
 	defer func() {
 		r.end = time.Now()
+		m.SetPhase(PhaseDone)
 	}()
 	r.start = time.Now()
 
 	cctx, ccancel := context.WithCancel(ctx)
 	defer ccancel()
-	go m.RunIDFetcher(cctx)
+	// bank_idが作れなくなったら続けても意味がないので止める
+	broken := make(chan error, 1)
+	go func() {
+		if err := m.RunIDFetcher(cctx); err != nil {
+			broken <- err
+			ccancel()
+		}
+	}()
+	defer func() {
+		select {
+		case berr := <-broken:
+			r.fail = true
+			err = errors.Wrap(berr, "ベンチマークを中断しました")
+		default:
+		}
+	}()
 
-	m.Logger().Println("# initialize")
-	if err := m.Initialize(cctx); err != nil {
-		return errors.Wrap(err, "Initialize に失敗しました")
+	if m.Resumed() {
+		m.Logger().Println("# resume (skip initialize)")
+	} else {
+		m.SetPhase(PhaseInitialize)
+		m.Logger().Println("# initialize")
+		if err := m.Initialize(cctx); err != nil {
+			return errors.Wrap(err, "Initialize に失敗しました")
+		}
 	}
 
+	m.SetPhase(PhasePreTest)
 	m.Logger().Println("# pre test")
-	if err := m.PreTest(cctx); err != nil {
+	if err := r.runPreTest(cctx); err != nil {
 		return errors.Wrap(err, "負荷走行前のテストに失敗しました")
 	}
 
+	m.SetPhase(PhaseBenchmark)
 	m.Logger().Printf("# benchmark")
 
-	if err := r.runScenarioBenchmark(cctx); err != nil {
+	if err := m.startBankFault(cctx); err != nil {
+		return errors.Wrap(err, "isubankの障害注入の設定に失敗しました")
+	}
+	err = r.runScenarioBenchmark(cctx)
+	m.stopBenchmarkClock()
+	// 中断されていても障害の注入は止める
+	tctx, tcancel := context.WithTimeout(context.Background(), TeardownLimit)
+	m.stopBankFault(tctx)
+	m.fetchLogUsage(tctx)
+	tcancel()
+	m.dumpSlowRequests()
+	if n, p50, p99 := m.metrics.PushLatency(); n > 0 {
+		m.Logger().Printf("long polling で受け取った成約: %d件 [p50:%.3fs, p99:%.3fs]", n, p50.Seconds(), p99.Seconds())
+	}
+	if err != nil {
 		r.fail = true
 		return errors.Wrap(err, "負荷走行 に失敗しました")
 	}
-	m.scoreboard.Dump()
+	if err := ctx.Err(); err != nil {
+		r.fail = true
+		return errors.Wrap(err, "負荷走行が中断されました")
+	}
+	m.scoreboard.Dump(m.conf.Score)
 
 	if r.fail {
 		return errors.New("finish by fail")
 	}
 
 	// cancelたちが終わるように少し待つ(すべての状態管理はつらすぎるので)
-	time.Sleep(50 * time.Millisecond)
+	time.Sleep(time.Duration(m.conf.PostTestGrace) * time.Millisecond)
 
+	m.SetPhase(PhasePostTest)
 	m.Logger().Printf("# post test")
-	if err := m.PostTest(cctx); err != nil {
+	if err := r.runPostTest(cctx); err != nil {
 		r.fail = true
 		return errors.Wrap(err, "負荷走行後のテストに失敗しました")
 	}
@@ -95,9 +216,42 @@ func (r *Runner) Run(ctx context.Context) error {
 	return nil
 }
 
+func (r *Runner) runPreTest(ctx context.Context) error {
+	if t := r.mgr.conf.PreTestTimeout; t > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(t)*time.Second)
+		defer cancel()
+	}
+	return r.mgr.PreTest(ctx)
+}
+
+func (r *Runner) runPostTest(ctx context.Context) error {
+	if t := r.mgr.conf.PostTestTimeout; t > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(t)*time.Second)
+		defer cancel()
+	}
+	return r.mgr.PostTest(ctx)
+}
+
 func (r *Runner) runScenarioBenchmark(ctx context.Context) error {
-	cctx, cancel := context.WithTimeout(ctx, BenchMarkTime)
+	cctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	// 一時停止していた分だけ終わりを延ばす
+	go func() {
+		for {
+			wait := r.mgr.benchmarkDeadline().Sub(time.Now())
+			if wait <= 0 && !r.mgr.Paused() {
+				cancel()
+				return
+			}
+			select {
+			case <-cctx.Done():
+				return
+			case <-time.After(TickerInterval):
+			}
+		}
+	}()
 
 	err := r.mgr.ScenarioStart(cctx)
 	if err == context.DeadlineExceeded {
@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"math/rand"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -55,7 +55,9 @@ type normalScenario struct {
 	highestBuyPrice  int64
 	latestTradePrice int64
 	enableShare      bool
+	campaign         int64
 	orders           []*Order
+	cancelled        map[int64]bool // キャンセルに成功した注文
 	ordersLock       sync.Mutex
 
 	unitIsu        int64
@@ -70,6 +72,19 @@ type normalScenario struct {
 	existed    bool
 	ignoretest bool
 	justprice  bool
+
+	// 注文の出し方. nilなら tryTrade
+	trade func(context.Context) (ScoreType, error)
+
+	actions int
+
+	// ユーザー全員で共有する価格. Manager から起動していなければnil
+	market *Market
+
+	// 負荷の記録. EnableWorkloadRecord していなければnil
+	workload       *workloadRecorder
+	workloadUser   *WorkloadUser
+	workloadOrders map[int64]int // 注文IDから記録した actions の添字
 }
 
 func newNormalScenario(c *Client, credit, isu, unit int64, justprice bool) *normalScenario {
@@ -81,6 +96,7 @@ func newNormalScenario(c *Client, credit, isu, unit int64, justprice bool) *norm
 		currentIsu:    isu,
 		unitIsu:       unit,
 		orders:        make([]*Order, 0, 60),
+		cancelled:     make(map[int64]bool, 10),
 		actionchan:    make(chan struct{}, BenchMarkTime/PollingInterval),
 		justprice:     justprice,
 	}
@@ -101,6 +117,18 @@ func (s *normalScenario) Orders() []*Order {
 	return s.orders
 }
 
+// snapshotOrders は負荷走行中に他のgoroutineから見るための注文のコピー
+func (s *normalScenario) snapshotOrders() []*Order {
+	s.ordersLock.Lock()
+	defer s.ordersLock.Unlock()
+	orders := make([]*Order, len(s.orders))
+	for i, o := range s.orders {
+		oc := *o
+		orders[i] = &oc
+	}
+	return orders
+}
+
 func (s *normalScenario) Credit() int64 {
 	return s.currentCredit
 }
@@ -125,11 +153,32 @@ func (s *normalScenario) waitingOrders() int {
 }
 
 func (s *normalScenario) Start(ctx context.Context, smchan chan ScoreMsg) error {
+	if err := s.enter(ctx, smchan); err != nil {
+		return err
+	}
+
+	go s.runAction(ctx, smchan)
+
+	go s.runInfoLoop(ctx, smchan)
+
+	return nil
+}
+
+// enter はトップページを開いてからログインして注文履歴を見るまで
+func (s *normalScenario) enter(ctx context.Context, smchan chan ScoreMsg) error {
 	err := s.c.Top(ctx)
 	smchan <- ScoreMsg{st: ScoreTypeGetTop, err: err}
 	if err != nil {
 		return errors.Wrap(err, "トップページを表示できません")
 	}
+	// リロード
+	n, err := s.c.Revalidate(ctx)
+	if err != nil {
+		smchan <- ScoreMsg{st: ScoreTypeNotModified, err: err}
+	}
+	for i := 0; i < n; i++ {
+		smchan <- ScoreMsg{st: ScoreTypeNotModified}
+	}
 
 	_, _, err = s.fetchInfo(ctx, 0)
 	smchan <- ScoreMsg{st: ScoreTypeGetInfo, err: err}
@@ -148,6 +197,9 @@ func (s *normalScenario) Start(ctx context.Context, smchan chan ScoreMsg) error
 	err = s.c.Signin(ctx)
 	smchan <- ScoreMsg{st: ScoreTypeSignin, err: err}
 	if err != nil {
+		if ctx.Err() == nil {
+			s.c.retireBy(RetireReasonSignin, endpointName(http.MethodPost, "/signin"))
+		}
 		return errors.Wrap(err, "ログインできませんでした")
 	}
 
@@ -156,11 +208,6 @@ func (s *normalScenario) Start(ctx context.Context, smchan chan ScoreMsg) error
 	if err != nil {
 		return errors.Wrap(err, "注文履歴の取得に失敗しました")
 	}
-
-	go s.runAction(ctx, smchan)
-
-	go s.runInfoLoop(ctx, smchan)
-
 	return nil
 }
 
@@ -177,9 +224,9 @@ func (s *normalScenario) runInfoLoop(ctx context.Context, smchan chan ScoreMsg)
 			}
 			nextLoopUnlock := time.After(PollingInterval)
 			next, traded, err := s.fetchInfo(ctx, cursor)
-			smchan <- ScoreMsg{st: ScoreTypeGetInfo, err: err}
+			smchan <- ScoreMsg{st: ScoreTypeGetInfo, err: err, campaign: s.campaign}
 			if err != nil {
-				if _, ok := err.(*ErrElapsedTimeOverRetire); ok {
+				if _, ok := errors.Cause(err).(*ErrElapsedTimeOverRetire); ok {
 					return
 				}
 			}
@@ -194,11 +241,11 @@ func (s *normalScenario) runInfoLoop(ctx context.Context, smchan chan ScoreMsg)
 					tradedOrders, err := s.fetchOrders(ctx, false)
 					smchan <- ScoreMsg{st: ScoreTypeGetOrders, err: err}
 					if err == nil {
-						for range tradedOrders {
-							smchan <- ScoreMsg{st: ScoreTypeTradeSuccess, sns: s.enableShare}
+						for _, o := range tradedOrders {
+							smchan <- s.tradeSuccess(o)
 						}
 					} else {
-						if _, ok := err.(*ErrElapsedTimeOverRetire); ok {
+						if _, ok := errors.Cause(err).(*ErrElapsedTimeOverRetire); ok {
 							return
 						}
 					}
@@ -222,28 +269,30 @@ func (s *normalScenario) runAction(ctx context.Context, smchan chan ScoreMsg) {
 				return
 			}
 			nextActionLock := time.After(OrderUpdateInterval)
-			st, err := s.tryTrade(ctx)
+			trade := s.tryTrade
+			if s.trade != nil {
+				trade = s.trade
+			}
+			st, err := trade(ctx)
 			if st == 0 {
 				continue
 			}
 			smchan <- ScoreMsg{st: st, err: err}
 			if err != nil {
-				if _, ok := err.(*ErrElapsedTimeOverRetire); ok {
+				if _, ok := errors.Cause(err).(*ErrElapsedTimeOverRetire); ok {
 					return
 				}
 				continue
 			}
-			tradedOrders, err := s.fetchOrders(ctx, false)
-			smchan <- ScoreMsg{st: ScoreTypeGetOrders, err: err}
-			if err == nil {
-				for range tradedOrders {
-					smchan <- ScoreMsg{st: ScoreTypeTradeSuccess, sns: s.enableShare}
-				}
-			} else {
-				if _, ok := err.(*ErrElapsedTimeOverRetire); ok {
+			if err = s.reportTraded(ctx, smchan); err != nil {
+				if _, ok := errors.Cause(err).(*ErrElapsedTimeOverRetire); ok {
 					return
 				}
 			}
+			s.actions++
+			if s.actions%SessionRestartEvery == 0 {
+				smchan <- ScoreMsg{st: ScoreTypeGetOrders, err: s.restartBrowser(ctx)}
+			}
 			<-nextActionLock
 			// 取引可能状態が続くとtradeが渋滞しているはずなのでインターバルを伸ばす
 			if s.lowestSellPrice < s.highestBuyPrice {
@@ -258,15 +307,53 @@ func (s *normalScenario) runAction(ctx context.Context, smchan chan ScoreMsg) {
 	}
 }
 
+// reportTraded は注文履歴を取り直して、新しく成約した注文の数だけスコアを送る
+func (s *normalScenario) reportTraded(ctx context.Context, smchan chan ScoreMsg) error {
+	tradedOrders, err := s.fetchOrders(ctx, false)
+	smchan <- ScoreMsg{st: ScoreTypeGetOrders, err: err}
+	if err != nil {
+		return err
+	}
+	for _, o := range tradedOrders {
+		smchan <- s.tradeSuccess(o)
+	}
+	return nil
+}
+
+// tradeSuccess は成約した注文 o のスコア. アプリが enable_share を返していればSNSでシェアされることがある
+func (s *normalScenario) tradeSuccess(o *Order) ScoreMsg {
+	return ScoreMsg{st: ScoreTypeTradeSuccess, sns: s.enableShare, bankid: s.BankID(), trade: o.TradeID}
+}
+
+// restartBrowser はcookieだけを引き継いだClientでログインしたままになっているかを確かめる
+func (s *normalScenario) restartBrowser(ctx context.Context) error {
+	c := s.c.restartBrowser()
+	defer c.closeIdleConnections()
+	_, err := c.GetOrders(ctx)
+	if e, ok := errors.Cause(err).(*ErrorWithStatus); ok && e.StatusCode == 401 {
+		return errors.Wrapf(err, "ブラウザを再起動したらログインが切れています [user:%d]", s.c.UserID())
+	}
+	return err
+}
+
 func (s *normalScenario) fetchInfo(ctx context.Context, cursor int64) (int64, bool, error) {
 	var traded bool
+	requestedAt := time.Now()
 	info, err := s.c.Info(ctx, cursor)
 	if err != nil {
 		return cursor, traded, err
 	}
+	s.market.observeTrades(info.TradedOrders)
+	if err := s.market.observeInfo(requestedAt, info); err != nil {
+		return info.Cursor, traded, err
+	}
 	s.lowestSellPrice = info.LowestSellPrice
 	s.highestBuyPrice = info.HighestBuyPrice
 	s.enableShare = info.EnableShare
+	s.campaign = 0
+	if info.Campaign != nil {
+		s.campaign = info.Campaign.ID
+	}
 	if l := len(info.ChartByHour); l > 0 {
 		s.latestTradePrice = info.ChartByHour[l-1].Close
 	}
@@ -295,6 +382,7 @@ func (s *normalScenario) fetchOrders(ctx context.Context, skipReflectCheck bool)
 	if err != nil {
 		return nil, err
 	}
+	s.market.observeTrades(orders)
 	if len(s.orders) > 0 && !skipReflectCheck {
 		var lo *Order
 		// cancelされていない最後の注文
@@ -339,6 +427,9 @@ func (s *normalScenario) fetchOrders(ctx context.Context, skipReflectCheck bool)
 			}
 			continue
 		}
+		if order.Trade != nil && s.cancelled[o.ID] {
+			return tradedOrders, errors.Errorf("GET /orders キャンセルした注文が成約しています [id:%d, trade_id:%d]", o.ID, order.Trade.ID)
+		}
 		if order.Trade != nil && o.TradeID == 0 {
 			tradedOrders = append(tradedOrders, order)
 		}
@@ -377,7 +468,7 @@ func (s *normalScenario) tryTrade(ctx context.Context) (ScoreType, error) {
 	logicalCredit := s.currentCredit - s.reservedCredit
 	logicalIsu := s.currentIsu - s.reservedIsu
 	waiting := s.waitingOrders()
-	if waiting >= rand.Intn(2)+4 { // 4,5になるので 5なら100%,4なら50%
+	if waiting >= s.c.rand.Intn(2)+4 { // 4,5になるので 5なら100%,4なら50%
 		var o *Order
 		var df int64
 		for _, order := range s.orders {
@@ -394,23 +485,22 @@ func (s *normalScenario) tryTrade(ctx context.Context) (ScoreType, error) {
 				}
 			}
 		}
-		if err := s.c.DeleteOrders(ctx, o.ID); err != nil {
-			if er, ok := err.(*ErrorWithStatus); ok && er.StatusCode == 404 {
-				// 404エラーはありえるのでOK
-				log.Printf("[INFO] delete 404 %s", er)
-			} else {
-				return ScoreTypeDeleteOrders, err
+		return s.cancelOrder(ctx, o)
+	}
+	if waiting > 0 && s.c.rand.Intn(100) < RandomCancelPercent {
+		open := make([]*Order, 0, waiting)
+		for _, order := range s.orders {
+			if order.ClosedAt == nil {
+				open = append(open, order)
 			}
 		}
-		now := time.Now()
-		o.ClosedAt = &now
-		return ScoreTypeDeleteOrders, nil
+		return s.cancelOrder(ctx, open[s.c.rand.Intn(len(open))])
 	}
 	// 価格の決定
 	var (
 		ot      string
 		price   int64 = s.latestTradePrice
-		amount  int64 = rand.Int63n(s.unitIsu) + 1
+		amount  int64 = s.c.rand.Int63n(s.unitIsu) + 1
 		buyable int64
 	)
 	if s.lowestSellPrice > 0 {
@@ -419,7 +509,7 @@ func (s *normalScenario) tryTrade(ctx context.Context) (ScoreType, error) {
 		buyable = logicalCredit / s.latestTradePrice
 	}
 	// 価格は成り行き以外は前回価格からランダムに前後する
-	switch rand.Intn(5) {
+	switch s.c.rand.Intn(5) {
 	case 1, 2:
 		price++
 	case 3, 4:
@@ -442,7 +532,7 @@ func (s *normalScenario) tryTrade(ctx context.Context) (ScoreType, error) {
 	case buyable < 1:
 		// 買う金が無い = 売り確定
 		ot = TradeTypeBuy
-	case rand.Intn(2) == 0:
+	case s.c.rand.Intn(2) == 0:
 		ot = TradeTypeBuy
 	default:
 		ot = TradeTypeSell
@@ -462,10 +552,43 @@ func (s *normalScenario) tryTrade(ctx context.Context) (ScoreType, error) {
 		return 0, nil
 	}
 
+	return s.placeOrder(ctx, ot, amount, price)
+}
+
+// cancelOrder と placeOrder は ordersLock を取った状態で呼ぶ
+func (s *normalScenario) cancelOrder(ctx context.Context, o *Order) (ScoreType, error) {
+	if s.workload != nil {
+		if i, ok := s.workloadOrders[o.ID]; ok {
+			s.workload.add(s.workloadUser, WorkloadAction{Type: WorkloadCancel, Order: i})
+		}
+	}
+	if err := s.c.DeleteOrders(ctx, o.ID); err != nil {
+		if er, ok := errors.Cause(err).(*ErrorWithStatus); ok && er.StatusCode == 404 {
+			// 404エラーはありえるのでOK
+			log.Printf("[INFO] delete 404 %s", er)
+		} else {
+			return ScoreTypeDeleteOrders, err
+		}
+	} else {
+		s.cancelled[o.ID] = true
+	}
+	now := time.Now()
+	o.ClosedAt = &now
+	return ScoreTypeDeleteOrders, nil
+}
+
+func (s *normalScenario) placeOrder(ctx context.Context, ot string, amount, price int64) (ScoreType, error) {
+	recorded := -1
+	if s.workload != nil {
+		recorded = s.workload.add(s.workloadUser, WorkloadAction{Type: ot, Amount: amount, Price: price})
+	}
 	order, err := s.c.AddOrder(ctx, ot, amount, price)
+	if err == nil && recorded >= 0 {
+		s.workloadOrders[order.ID] = recorded
+	}
 	if err != nil {
 		// 残高不足はOKとする
-		if er, ok := err.(*ErrorWithStatus); ok && er.StatusCode == 400 && strings.Index(err.Error(), "残高") > -1 {
+		if er, ok := errors.Cause(err).(*ErrorWithStatus); ok && er.StatusCode == 400 && strings.Index(err.Error(), "残高") > -1 {
 			log.Printf("[INFO] 残高不足 [user:%d, price:%d, amount:%d]", s.c.UserID(), price, amount)
 			return ScoreTypePostOrders, nil
 		}
@@ -478,21 +601,48 @@ func (s *normalScenario) tryTrade(ctx context.Context) (ScoreType, error) {
 
 type bruteForceScenario struct {
 	*baseScenario
-	defpass string
+	defpass   string
+	passwords []string // 試すパスワード. 空なら password000 ~ password999 からランダムに選ぶ
+	delayMin  time.Duration
+	delayMax  time.Duration
+
+	lockoutAfter   int  // この回数続けて失敗したら403を返してよい
+	requireLockout bool // lockoutAfter の倍失敗しても403が返らなければエラーにする
 }
 
-func NewBruteForceScenario(c *Client) Scenario {
+func NewBruteForceScenario(c *Client, passwords []string, delayMin, delayMax time.Duration, lockoutAfter int, requireLockout bool) Scenario {
 	return &bruteForceScenario{
-		baseScenario: &baseScenario{c},
-		defpass:      c.pass,
+		baseScenario:   &baseScenario{c},
+		defpass:        c.pass,
+		passwords:      passwords,
+		delayMin:       delayMin,
+		delayMax:       delayMax,
+		lockoutAfter:   lockoutAfter,
+		requireLockout: requireLockout,
 	}
 }
 
+// 試行ごとに間隔を変えて、一定間隔の試行だけを弾くような実装を見逃さないようにする
+func (s *bruteForceScenario) delay() time.Duration {
+	if s.delayMax <= s.delayMin {
+		return s.delayMin
+	}
+	return s.delayMin + time.Duration(s.c.rand.Int63n(int64(s.delayMax-s.delayMin)))
+}
+
+func (s *bruteForceScenario) nextPassword(i int) string {
+	if len(s.passwords) == 0 {
+		return fmt.Sprintf("password%03d", s.c.rand.Intn(1000))
+	}
+	return s.passwords[i%len(s.passwords)]
+}
+
 func (s *bruteForceScenario) Start(ctx context.Context, smchan chan ScoreMsg) error {
 	var cursor int64
 	go func() {
 		n := 0
 		b := 0
+		tried := 0
 		for {
 			select {
 			case <-ctx.Done():
@@ -502,11 +652,11 @@ func (s *bruteForceScenario) Start(ctx context.Context, smchan chan ScoreMsg) er
 				if s.c.IsRetired() {
 					return
 				}
-				actionInterval := time.After(BruteForceDelay)
+				actionInterval := time.After(s.delay())
 				err := s.c.Top(ctx)
 				smchan <- ScoreMsg{st: ScoreTypeGetTop, err: err}
 				if err != nil {
-					if _, ok := err.(*ErrElapsedTimeOverRetire); ok {
+					if _, ok := errors.Cause(err).(*ErrElapsedTimeOverRetire); ok {
 						return
 					}
 					<-actionInterval
@@ -515,7 +665,7 @@ func (s *bruteForceScenario) Start(ctx context.Context, smchan chan ScoreMsg) er
 				info, err := s.c.Info(ctx, cursor)
 				smchan <- ScoreMsg{st: ScoreTypeGetInfo, err: err}
 				if err != nil {
-					if _, ok := err.(*ErrElapsedTimeOverRetire); ok {
+					if _, ok := errors.Cause(err).(*ErrElapsedTimeOverRetire); ok {
 						return
 					}
 					<-actionInterval
@@ -531,28 +681,42 @@ func (s *bruteForceScenario) Start(ctx context.Context, smchan chan ScoreMsg) er
 					continue
 				}
 
-				s.c.pass = fmt.Sprintf("password%03d", rand.Intn(1000))
+				s.c.pass = s.nextPassword(tried)
+				tried++
 				n++
+				st := ScoreTypeSignin
 				err = s.c.Signin(ctx)
 				if err == nil {
-					err = errors.Errorf("不正ログインに成功しました")
+					if n > s.lockoutAfter {
+						err = errors.Errorf("%d回続けて失敗したあとに不正ログインに成功しました。ロックされていません", n-1)
+					} else {
+						err = errors.Errorf("不正ログインに成功しました")
+					}
 					n = 0
-				} else if e, ok := err.(*ErrorWithStatus); ok {
+				} else if e, ok := errors.Cause(err).(*ErrorWithStatus); ok {
 					switch e.StatusCode {
 					case 403:
-						if n > 5 {
+						if n > s.lockoutAfter {
+							// ロックされたことを確認できた
 							err = nil
+							st = ScoreTypeLockout
 							b = n
+						} else {
+							err = errors.Wrapf(err, "POST /signin %d回の失敗でロックされました", n-1)
 						}
 					case 404:
 						err = nil
+						if s.requireLockout && n > s.lockoutAfter*2 {
+							err = errors.Errorf("POST /signin %d回続けて失敗してもロックされません [bank_id:%s]", n, s.c.bankid)
+							n = 0
+						}
 					default:
 						n = 0
 					}
 				}
-				smchan <- ScoreMsg{st: ScoreTypeSignin, err: err}
+				smchan <- ScoreMsg{st: st, err: err}
 				if err != nil {
-					if _, ok := err.(*ErrElapsedTimeOverRetire); ok {
+					if _, ok := errors.Cause(err).(*ErrElapsedTimeOverRetire); ok {
 						return
 					}
 				}
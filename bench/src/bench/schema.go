@@ -0,0 +1,207 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// jsonSchema はレスポンスのJSONの型と必須の項目の定義
+// 構造体へのdecodeでは分からない、どの項目がおかしいのかを返すために使う
+type jsonSchema struct {
+	kind     string // object, array, integer, string, boolean, time (RFC3339の文字列)
+	nullable bool
+	fields   map[string]jsonField // object の項目
+	items    *jsonSchema          // array の要素
+	enum     []string             // string で許す値
+}
+
+type jsonField struct {
+	schema   *jsonSchema
+	required bool
+}
+
+func requiredField(s *jsonSchema) jsonField { return jsonField{s, true} }
+func optionalField(s *jsonSchema) jsonField { return jsonField{s, false} }
+
+func nullableSchema(s jsonSchema) *jsonSchema {
+	s.nullable = true
+	return &s
+}
+
+var (
+	integerSchema = &jsonSchema{kind: "integer"}
+	stringSchema  = &jsonSchema{kind: "string"}
+	booleanSchema = &jsonSchema{kind: "boolean"}
+	timeSchema    = &jsonSchema{kind: "time"}
+
+	userSchema = &jsonSchema{kind: "object", fields: map[string]jsonField{
+		"id":   requiredField(integerSchema),
+		"name": requiredField(stringSchema),
+	}}
+	tradeSchema = &jsonSchema{kind: "object", fields: map[string]jsonField{
+		"id":         requiredField(integerSchema),
+		"amount":     requiredField(integerSchema),
+		"price":      requiredField(integerSchema),
+		"created_at": requiredField(timeSchema),
+	}}
+	orderSchema = &jsonSchema{kind: "object", fields: map[string]jsonField{
+		"id":         requiredField(integerSchema),
+		"type":       requiredField(&jsonSchema{kind: "string", enum: []string{TradeTypeBuy, TradeTypeSell}}),
+		"user_id":    requiredField(integerSchema),
+		"amount":     requiredField(integerSchema),
+		"price":      requiredField(integerSchema),
+		"closed_at":  requiredField(nullableSchema(*timeSchema)),
+		"trade_id":   optionalField(integerSchema),
+		"created_at": requiredField(timeSchema),
+		"user":       optionalField(userSchema),
+		"trade":      optionalField(tradeSchema),
+	}}
+	candlestickSchema = &jsonSchema{kind: "object", fields: map[string]jsonField{
+		"time":  requiredField(timeSchema),
+		"open":  requiredField(integerSchema),
+		"close": requiredField(integerSchema),
+		"high":  requiredField(integerSchema),
+		"low":   requiredField(integerSchema),
+	}}
+	chartSchema = &jsonSchema{kind: "array", nullable: true, items: candlestickSchema}
+
+	// traded_orders はログインしているときだけ, *_price は注文があるときだけ返る
+	infoSchema = &jsonSchema{kind: "object", fields: map[string]jsonField{
+		"cursor":            requiredField(integerSchema),
+		"traded_orders":     optionalField(&jsonSchema{kind: "array", nullable: true, items: orderSchema}),
+		"lowest_sell_price": optionalField(integerSchema),
+		"highest_buy_price": optionalField(integerSchema),
+		"chart_by_sec":      requiredField(chartSchema),
+		"chart_by_min":      requiredField(chartSchema),
+		"chart_by_hour":     requiredField(chartSchema),
+		"enable_share":      requiredField(booleanSchema),
+		"campaign": optionalField(nullableSchema(jsonSchema{kind: "object", fields: map[string]jsonField{
+			"id": requiredField(integerSchema),
+		}})),
+	}}
+)
+
+// SchemaError はJSONのどの項目が定義と違うか
+type SchemaError struct {
+	Path string
+	Msg  string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+}
+
+// validateJSON は b が s の定義どおりかを確かめる
+func validateJSON(s *jsonSchema, b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return &SchemaError{Path: "$", Msg: "invalid json: " + err.Error()}
+	}
+	return s.validate("$", v)
+}
+
+func (s *jsonSchema) validate(path string, v interface{}) error {
+	if v == nil {
+		if s.nullable {
+			return nil
+		}
+		return &SchemaError{path, fmt.Sprintf("must be %s but null", s.kind)}
+	}
+	mismatch := func() error {
+		return &SchemaError{path, fmt.Sprintf("must be %s but %s", s.kind, jsonKind(v))}
+	}
+	switch s.kind {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		names := make([]string, 0, len(s.fields))
+		for name := range s.fields {
+			names = append(names, name)
+		}
+		// 複数の項目がおかしくても毎回同じ項目を返す
+		sort.Strings(names)
+		for _, name := range names {
+			f := s.fields[name]
+			fv, ok := obj[name]
+			if !ok {
+				if f.required {
+					return &SchemaError{path + "." + name, "is required"}
+				}
+				continue
+			}
+			if err := f.schema.validate(path+"."+name, fv); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for i, iv := range arr {
+			if err := s.items.validate(fmt.Sprintf("%s[%d]", path, i), iv); err != nil {
+				return err
+			}
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return mismatch()
+		}
+		if _, err := n.Int64(); err != nil {
+			return &SchemaError{path, fmt.Sprintf("must be integer but %s", n)}
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return mismatch()
+		}
+	case "string", "time":
+		str, ok := v.(string)
+		if !ok {
+			return mismatch()
+		}
+		if s.kind == "time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return &SchemaError{path, fmt.Sprintf("must be RFC3339 time but %q", str)}
+			}
+		}
+		if len(s.enum) > 0 && !containsString(s.enum, str) {
+			return &SchemaError{path, fmt.Sprintf("must be one of %s but %q", strings.Join(s.enum, ", "), str)}
+		}
+	}
+	return nil
+}
+
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"sync"
+
+	"bench/portal"
 )
 
 type ScoreType int
@@ -17,6 +19,12 @@ const (
 	ScoreTypeGetOrders
 	ScoreTypeDeleteOrders
 	ScoreTypeTradeSuccess
+	ScoreTypeTradePush
+	ScoreTypeNotModified
+	ScoreTypeLockout
+	ScoreTypeCampaign
+
+	scoreTypeCount // ScoreType の数 + 1. ScoreType を足すときはこの前に書く
 )
 
 func (st ScoreType) String() string {
@@ -37,6 +45,14 @@ func (st ScoreType) String() string {
 		return "DeleteOrders"
 	case ScoreTypeTradeSuccess:
 		return "TradeSuccess"
+	case ScoreTypeTradePush:
+		return "TradePush"
+	case ScoreTypeNotModified:
+		return "NotModified"
+	case ScoreTypeLockout:
+		return "Lockout"
+	case ScoreTypeCampaign:
+		return "Campaign"
 	default:
 		return fmt.Sprintf("Unknown[%d]", st)
 	}
@@ -60,6 +76,14 @@ func (st ScoreType) Score() int64 {
 		return DeleteOrdersScore
 	case ScoreTypeTradeSuccess:
 		return TradeSuccessScore
+	case ScoreTypeTradePush:
+		return TradePushScore
+	case ScoreTypeNotModified:
+		return NotModifiedScore
+	case ScoreTypeLockout:
+		return LockoutScore
+	case ScoreTypeCampaign:
+		return CampaignScore
 	default:
 		log.Printf("[WARN] not defined score [%d]", st)
 		return 0
@@ -67,8 +91,9 @@ func (st ScoreType) Score() int64 {
 }
 
 type ScoreBoard struct {
-	count map[ScoreType]int64
-	mux   sync.Mutex
+	count  map[ScoreType]int64
+	shared int64 // SNSでシェアされた成約の数 (TradeSuccessに含まれる)
+	mux    sync.Mutex
 }
 
 func (sb *ScoreBoard) Add(p ScoreType) {
@@ -80,19 +105,60 @@ func (sb *ScoreBoard) Add(p ScoreType) {
 	sb.count[p]++
 }
 
-func (sb *ScoreBoard) Dump() {
+// AddShared はSNSでシェアされた成約を数える
+func (sb *ScoreBoard) AddShared() {
+	sb.mux.Lock()
+	defer sb.mux.Unlock()
+	sb.shared++
+}
+
+// Breakdown は種類ごとの獲得スコア
+// シェアされた成約は TradeSuccess から分けて TradeShared とする
+func (sb *ScoreBoard) Breakdown(sc ScoreConfig) []portal.ScoreResult {
 	sb.mux.Lock()
 	defer sb.mux.Unlock()
-	for i := 0; i < 15; i++ {
-		st := ScoreType(i)
+	r := make([]portal.ScoreResult, 0, len(sb.count)+1)
+	for st := ScoreTypeGetTop; st < scoreTypeCount; st++ {
+		count, ok := sb.count[st]
+		if !ok {
+			continue
+		}
+		if st == ScoreTypeTradeSuccess && sb.shared > 0 {
+			count -= sb.shared
+			r = append(r, portal.ScoreResult{Type: "TradeShared", Count: sb.shared, Score: sb.shared * sc.Of(st)})
+		}
+		r = append(r, portal.ScoreResult{Type: st.String(), Count: count, Score: count * sc.Of(st)})
+	}
+	return r
+}
+
+func (sb *ScoreBoard) Counts() map[ScoreType]int64 {
+	sb.mux.Lock()
+	defer sb.mux.Unlock()
+	r := make(map[ScoreType]int64, len(sb.count))
+	for st, n := range sb.count {
+		r[st] = n
+	}
+	return r
+}
+
+func (sb *ScoreBoard) Dump(sc ScoreConfig) {
+	sb.mux.Lock()
+	defer sb.mux.Unlock()
+	for st := ScoreTypeGetTop; st < scoreTypeCount; st++ {
 		if count, ok := sb.count[st]; ok {
-			log.Printf("[INFO] %-16s: score=%d, count=%d", st, count*st.Score(), count)
+			log.Printf("[INFO] %-16s: score=%d, count=%d", st, count*sc.Of(st), count)
 		}
 	}
 }
 
 type ScoreMsg struct {
-	st  ScoreType
-	err error
-	sns bool
+	st       ScoreType
+	err      error
+	sns      bool
+	campaign int64 // /info で告知されていたキャンペーンのID
+
+	// sns のときにシェアするユーザーと成約
+	bankid string
+	trade  int64
 }
@@ -0,0 +1,87 @@
+package bench
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ScoreModel は加点の合計とエラー数から減点後のスコアを計算する
+// HTTP/2, gzip などのボーナスは ScoreModel によらず後から加える
+type ScoreModel interface {
+	Total(score int64, nerr int, ec ErrorConfig) int64
+}
+
+// ScoreModelFunc は関数を ScoreModel として使う
+type ScoreModelFunc func(score int64, nerr int, ec ErrorConfig) int64
+
+func (f ScoreModelFunc) Total(score int64, nerr int, ec ErrorConfig) int64 {
+	return f(score, nerr, ec)
+}
+
+const DefaultScoreModel = "demerit"
+
+var (
+	scoreModelsMu sync.Mutex
+	scoreModels   = map[string]ScoreModel{}
+)
+
+// RegisterScoreModel は config の score_model で選べるスコアの計算方法を追加する
+// 同じ名前で二回登録すると panic する
+func RegisterScoreModel(name string, m ScoreModel) {
+	scoreModelsMu.Lock()
+	defer scoreModelsMu.Unlock()
+	if m == nil {
+		panic("bench: RegisterScoreModel model is nil")
+	}
+	if _, dup := scoreModels[name]; dup {
+		panic("bench: RegisterScoreModel called twice for " + name)
+	}
+	scoreModels[name] = m
+}
+
+// ScoreModels は登録されているスコアの計算方法の名前
+func ScoreModels() []string {
+	scoreModelsMu.Lock()
+	defer scoreModelsMu.Unlock()
+	names := make([]string, 0, len(scoreModels))
+	for name := range scoreModels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupScoreModel(name string) (ScoreModel, error) {
+	if name == "" {
+		name = DefaultScoreModel
+	}
+	scoreModelsMu.Lock()
+	m, ok := scoreModels[name]
+	scoreModelsMu.Unlock()
+	if !ok {
+		return nil, errors.Errorf("unknown score model %s (available: %v)", name, ScoreModels())
+	}
+	return m, nil
+}
+
+func init() {
+	// エラー1件ごとにスコアの 1/DemeritDivisor を引く. エラーが多いと最大スコアが半分になる
+	RegisterScoreModel("demerit", ScoreModelFunc(func(score int64, nerr int, ec ErrorConfig) int64 {
+		demerit := score / ec.DemeritDivisor
+		return score - demerit*int64(nerr)
+	}))
+	// エラー1件ごとに決まった点数を引く. スコアが高くてもエラーの重さが変わらない
+	RegisterScoreModel("linear", ScoreModelFunc(func(score int64, nerr int, ec ErrorConfig) int64 {
+		score -= ec.Penalty * int64(nerr)
+		if score < 0 {
+			return 0
+		}
+		return score
+	}))
+	// 減点しない. 処理できた量だけを比べる
+	RegisterScoreModel("raw", ScoreModelFunc(func(score int64, nerr int, ec ErrorConfig) int64 {
+		return score
+	}))
+}
@@ -0,0 +1,45 @@
+package bench
+
+import "testing"
+
+func TestScoreModels(t *testing.T) {
+	ec := ErrorConfig{DemeritDivisor: 20, Penalty: 100}
+	specs := []struct {
+		model    string
+		score    int64
+		nerr     int
+		expected int64
+	}{
+		{"demerit", 10000, 0, 10000},
+		{"demerit", 10000, 3, 8500},
+		{"demerit", 10000, 10, 5000},
+		{"linear", 10000, 3, 9700},
+		{"linear", 200, 3, 0},
+		{"raw", 10000, 10, 10000},
+		{"", 10000, 3, 8500}, // 指定しなければ demerit
+	}
+	for _, s := range specs {
+		m, err := lookupScoreModel(s.model)
+		if err != nil {
+			t.Fatalf("lookup %q failed: %s", s.model, err)
+		}
+		if got := m.Total(s.score, s.nerr, ec); got != s.expected {
+			t.Errorf("unexpected total of %q (score:%d, errors:%d): got:%d expected:%d", s.model, s.score, s.nerr, got, s.expected)
+		}
+	}
+}
+
+func TestScoreModelUnknown(t *testing.T) {
+	if _, err := lookupScoreModel("unknown"); err == nil {
+		t.Error("unknown score model was found")
+	}
+}
+
+func TestRegisterScoreModelTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("registering demerit twice did not panic")
+		}
+	}()
+	RegisterScoreModel("demerit", ScoreModelFunc(func(score int64, nerr int, ec ErrorConfig) int64 { return score }))
+}
@@ -0,0 +1,77 @@
+package bench
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ShareEvent はユーザーが成約をSNSでシェアしたこと. Users はそれで増えたユーザー数
+type ShareEvent struct {
+	Time    time.Time `json:"time"`
+	BankID  string    `json:"bank_id"`
+	TradeID int64     `json:"trade_id"`
+	Users   int       `json:"users"`
+}
+
+// shareLog は負荷走行中のSNSシェアを記録する
+type shareLog struct {
+	mu     sync.Mutex
+	events []ShareEvent
+	last   time.Time // 最後にシェアでユーザーを増やした時刻
+}
+
+// cooling は前にユーザーを増やしてから interval が経っていなければtrue. 経っていれば now を最後に増やした時刻にする
+func (l *shareLog) cooling(now time.Time, interval time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if interval > 0 && !l.last.IsZero() && now.Sub(l.last) < interval {
+		return true
+	}
+	l.last = now
+	return false
+}
+
+func (l *shareLog) add(ev ShareEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, ev)
+}
+
+func (l *shareLog) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.events)
+}
+
+// share は enable_share のユーザーが成約したときに share.probability の確率でシェアさせ、ユーザーを増やす
+func (c *Manager) share(ctx context.Context, smchan chan ScoreMsg, s ScoreMsg) {
+	sc := c.conf.Share
+	if rand.Intn(100) >= sc.Probability {
+		return
+	}
+	c.scoreboard.AddShared()
+	now := time.Now()
+	ev := ShareEvent{Time: now, BankID: s.bankid, TradeID: s.trade}
+	if sc.AddUsers > 0 && !c.shares.cooling(now, time.Duration(sc.Interval)*time.Millisecond) {
+		n, err := c.startAdmitted(ctx, smchan, sc.AddUsers)
+		if err != nil {
+			log.Printf("[INFO] scenario.Start failed. %s", err)
+		} else if n > 0 {
+			ev.Users = n
+			c.Logger().Printf("SNSでシェアされたためアクティブユーザーが%d人増加しました [user:%s, trade:%d]", n, s.bankid, s.trade)
+		}
+	}
+	c.shares.add(ev)
+}
+
+// ShareEvents は負荷走行中のSNSシェアを起きた順に返す
+func (c *Manager) ShareEvents() []ShareEvent {
+	c.shares.mu.Lock()
+	defer c.shares.mu.Unlock()
+	r := make([]ShareEvent, len(c.shares.events))
+	copy(r, c.shares.events)
+	return r
+}
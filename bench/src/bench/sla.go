@@ -0,0 +1,46 @@
+package bench
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SLA は負荷走行の後に確かめる性能の条件. ゼロ値の項目は確かめない
+// スコアで合格していても満たしていなければ失敗にしたいときに使う
+type SLA struct {
+	P95       time.Duration // 全リクエストのレイテンシの95パーセンタイルの上限
+	ErrorRate float64       // 失敗したか5xxだったリクエストの割合の上限 (0.01 で 1%)
+}
+
+func (s SLA) Enabled() bool {
+	return s.P95 > 0 || s.ErrorRate > 0
+}
+
+// CheckSLA は負荷走行の結果が sla を満たしているかを確かめる
+func (c *Manager) CheckSLA(sla SLA) error {
+	if !sla.Enabled() {
+		return nil
+	}
+	var failed []string
+	if sla.P95 > 0 {
+		p95 := c.metrics.Percentile(0.95)
+		c.Logger().Printf("SLA: p95 %s (上限 %s)", p95, sla.P95)
+		if p95 > sla.P95 {
+			failed = append(failed, fmt.Sprintf("p95 %s > %s", p95, sla.P95))
+		}
+	}
+	if sla.ErrorRate > 0 {
+		rate := c.metrics.ErrorRate()
+		c.Logger().Printf("SLA: error rate %.3f%% (上限 %.3f%%)", rate*100, sla.ErrorRate*100)
+		if rate > sla.ErrorRate {
+			failed = append(failed, fmt.Sprintf("error rate %.3f%% > %.3f%%", rate*100, sla.ErrorRate*100))
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("SLAを満たしていません: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
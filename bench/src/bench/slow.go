@@ -0,0 +1,73 @@
+package bench
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// slowRequests は threshold より時間がかかったリクエストのうち遅いものを SlowRequestTopN 件だけ残す
+type slowRequests struct {
+	threshold time.Duration
+	mu        sync.Mutex
+	top       []TraceEvent // 遅い順
+	count     int
+}
+
+func newSlowRequests(threshold time.Duration) *slowRequests {
+	return &slowRequests{threshold: threshold, top: make([]TraceEvent, 0, SlowRequestTopN+1)}
+}
+
+func (s *slowRequests) OnStart(ev TraceEvent) {}
+
+func (s *slowRequests) OnFinish(ev TraceEvent) {
+	s.add(ev)
+}
+
+func (s *slowRequests) OnError(ev TraceEvent, err error) {
+	ev.Error = err.Error()
+	s.add(ev)
+}
+
+func (s *slowRequests) add(ev TraceEvent) {
+	elapsed := ev.End.Sub(ev.Start)
+	if elapsed < s.threshold || ev.LongPoll {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count++
+	i := sort.Search(len(s.top), func(i int) bool {
+		return s.top[i].End.Sub(s.top[i].Start) < elapsed
+	})
+	if i >= SlowRequestTopN {
+		return
+	}
+	s.top = append(s.top, TraceEvent{})
+	copy(s.top[i+1:], s.top[i:])
+	s.top[i] = ev
+	if len(s.top) > SlowRequestTopN {
+		s.top = s.top[:SlowRequestTopN]
+	}
+}
+
+// dumpSlowRequests は負荷走行で遅かったリクエストを表示する
+func (c *Manager) dumpSlowRequests() {
+	if c.slow == nil {
+		return
+	}
+	c.slow.mu.Lock()
+	defer c.slow.mu.Unlock()
+	if c.slow.count == 0 {
+		return
+	}
+	c.Logger().Printf("%s 以上かかったリクエスト: %d件 (遅い順に%d件)", c.slow.threshold, c.slow.count, len(c.slow.top))
+	for i, ev := range c.slow.top {
+		msg := fmt.Sprintf("%2d. %s %.3fs [user:%s, at:%s]", i+1, endpointName(ev.Method, ev.Path), ev.End.Sub(ev.Start).Seconds(), ev.BankID, ev.Start.Format("15:04:05.000"))
+		if ev.Error != "" {
+			msg += " err: " + ev.Error
+		}
+		c.Logger().Print(msg)
+	}
+}
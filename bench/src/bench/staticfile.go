@@ -1,19 +1,20 @@
 package bench
 
 type StaticFile struct {
-	Path string
-	Size int64
-	Hash string
+	Path   string
+	Size   int64
+	Hash   string
+	SHA256 string
 }
 
 var StaticFiles = []*StaticFile{
-	&StaticFile{"/", 886, "3a571469b58349f869846a51327e7cff"},
-	&StaticFile{"/css/app.033eaee3.css", 11992, "7bf63f337dc2e96d62aefa4c7c482732"},
-	&StaticFile{"/favicon.ico", 894, "74ba79ca3f41bb01fe12454f4f13bd96"},
-	&StaticFile{"/img/isucoin_logo.png", 8988, "549012f31fcf8a328bedf6b8cab2b1af"},
-	&StaticFile{"/js/Chart.Financial.js", 14403, "765490c323c5073857bf15309133edee"},
-	&StaticFile{"/js/Chart.min.js", 159638, "f6c8efa65711e0cbbc99ba72997ecd0e"},
-	&StaticFile{"/js/app.2be81752.js", 19425, "ece197c60a70f36b87d2a390428095b9"},
-	&StaticFile{"/js/chunk-vendors.3f054da5.js", 139427, "d004b96351883062178f479d06dd376a"},
-	&StaticFile{"/js/moment.min.js", 51679, "8999b8b5d07e9c6077ac5ac6bc942968"},
+	&StaticFile{"/", 886, "3a571469b58349f869846a51327e7cff", "1cf9cd9ee906633a6182818b1e4802a7db407a42a5c037107311ade4eed77f52"},
+	&StaticFile{"/css/app.033eaee3.css", 11992, "7bf63f337dc2e96d62aefa4c7c482732", "cc6f9c1a15b7b680bf2286d99159098abe6525d41c7c380b44c32195c9f3f4e2"},
+	&StaticFile{"/favicon.ico", 894, "74ba79ca3f41bb01fe12454f4f13bd96", "90c01188c50a23d89739b7471a673e366a06807ab702e83e609ded214fa4e48c"},
+	&StaticFile{"/img/isucoin_logo.png", 8988, "549012f31fcf8a328bedf6b8cab2b1af", "1ca625b7404e6f14f6038ab36c93d7bbe8eb0fb2c1cd3ba94f69b38bd8082fe9"},
+	&StaticFile{"/js/Chart.Financial.js", 14403, "765490c323c5073857bf15309133edee", "da00982c9ff1fc7c68fa36c2b02f5e3bbbf8ec8a5787f2c7411ffaf678e06d92"},
+	&StaticFile{"/js/Chart.min.js", 159638, "f6c8efa65711e0cbbc99ba72997ecd0e", "09f704443e0ebf8fa529b59b62a5c3e9a14cf4ce7580de06504b4386458004ca"},
+	&StaticFile{"/js/app.2be81752.js", 19425, "ece197c60a70f36b87d2a390428095b9", "f1e627fe641d041ddc061cf814d2b346a992976b5eba9b1568b36cbc454b8541"},
+	&StaticFile{"/js/chunk-vendors.3f054da5.js", 139427, "d004b96351883062178f479d06dd376a", "78c3c257b7c561d7e2ceae754e2ecee75c5c083d0a6bf8ab84b0018ec4433b0a"},
+	&StaticFile{"/js/moment.min.js", 51679, "8999b8b5d07e9c6077ac5ac6bc942968", "0aeb4ecf1091b9c52c9fa0ba4dc118b1abafbd88a51278935e574f6baff0bb49"},
 }
@@ -0,0 +1,74 @@
+package bench
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const StreamInterval = 1 * time.Second // 状態を配信する間隔
+
+type Snapshot struct {
+	Time        time.Time `json:"time"`
+	Score       int64     `json:"score"`
+	Level       uint      `json:"level"`
+	Users       int       `json:"users"`
+	ActiveUsers int       `json:"active_users"`
+	Errors      int       `json:"errors"`
+	Shares      int       `json:"shares"` // それまでにSNSでシェアされた成約の数
+}
+
+func (c *Manager) Snapshot() Snapshot {
+	return Snapshot{
+		Time:        time.Now(),
+		Score:       c.GetScore(),
+		Level:       c.GetLevel(),
+		Users:       c.AllUsers(),
+		ActiveUsers: c.ActiveUsers(),
+		Errors:      c.ErrorCount(),
+		Shares:      c.shares.count(),
+	}
+}
+
+// StreamHandler は接続されている間 StreamInterval ごとにSnapshotをWebSocketで送り続ける
+func (c *Manager) StreamHandler() http.Handler {
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     func(*http.Request) bool { return true },
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("[INFO] stream upgrade failed. %s", err)
+			return
+		}
+		defer conn.Close()
+
+		// closeを検知するために読み捨てる
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ticker := time.NewTicker(StreamInterval)
+		defer ticker.Stop()
+		for {
+			if err := conn.WriteJSON(c.Snapshot()); err != nil {
+				return
+			}
+			select {
+			case <-closed:
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
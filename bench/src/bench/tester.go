@@ -2,9 +2,23 @@ package bench
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"log/slog"
+	"math"
 	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"bench/isubank"
@@ -15,33 +29,445 @@ import (
 
 type PreTester struct {
 	appep   string
+	conf    ClientConfig
 	isulog  *isulog.Isulog
 	isubank *isubank.Isubank
+	checks  checkSuite
+}
+
+func (t *PreTester) newClient(bankid, name, password string) (*Client, error) {
+	c, err := NewClient(t.appep, bankid, name, password, t.conf.timeout(), t.conf.retireTimeout())
+	if err != nil {
+		return nil, err
+	}
+	c.applyConfig(t.conf)
+	return c, nil
+}
+
+// testCertificate はアプリが返す証明書チェーンとホスト名を検証する
+func (t *PreTester) testCertificate() error {
+	u, err := url.Parse(t.appep)
+	if err != nil {
+		return errors.Wrap(err, "appep parse failed")
+	}
+	if u.Scheme != "https" {
+		return nil
+	}
+	if t.conf.InsecureSkipVerify {
+		log.Printf("[INFO] skip certificate test (insecure-skip-verify)")
+		return nil
+	}
+	conf := &tls.Config{ServerName: u.Hostname()}
+	if t.conf.tls != nil {
+		conf.RootCAs = t.conf.tls.RootCAs
+		conf.Certificates = t.conf.tls.Certificates
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "443")
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: t.conf.timeout()}, "tcp", host, conf)
+	if err != nil {
+		return errors.Wrap(err, "TLS証明書の検証に失敗しました")
+	}
+	defer conn.Close()
+	for _, cert := range conn.ConnectionState().PeerCertificates {
+		if time.Now().After(cert.NotAfter) {
+			return errors.Errorf("TLS証明書の有効期限が切れています [%s]", cert.Subject.CommonName)
+		}
+	}
+	return nil
+}
+
+// testKeepAlive は同じClientで続けてリクエストしたときにコネクションが使い回されるかを確かめる
+// レスポンスごとにコネクションを閉じるアプリはエラーにする
+func (t *PreTester) testKeepAlive(ctx context.Context) error {
+	if t.conf.DisableKeepAlives {
+		log.Printf("[INFO] skip keep-alive test (disable-keep-alives)")
+		return nil
+	}
+	c, err := t.newClient("", "", "")
+	if err != nil {
+		return errors.Wrap(err, "create new client failed")
+	}
+	defer c.closeIdleConnections()
+	path := StaticFiles[0].Path
+	var reused int
+	for i := 0; i < KeepAliveTestRequests; i++ {
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) {
+				if info.Reused {
+					reused++
+				}
+			},
+		}
+		res, err := c.get(httptrace.WithClientTrace(ctx, trace), path, url.Values{})
+		if err != nil {
+			return errors.Wrapf(err, "GET %s request failed", path)
+		}
+		// 最後まで読まないと使い回されない
+		if _, err := io.Copy(ioutil.Discard, res.Body); err != nil {
+			res.Body.Close()
+			return errors.Wrapf(err, "GET %s body read failed", path)
+		}
+		res.Body.Close()
+	}
+	if reused == 0 {
+		return errors.Errorf("GET %s コネクションが使い回されていません. keep-aliveが無効になっている可能性があります", path)
+	}
+	return nil
+}
+
+// testStaticFiles はフロントエンドのファイルをすべて取得し、SHA-256がベンチの持っている値と一致するかを確かめる
+func (t *PreTester) testStaticFiles(ctx context.Context) error {
+	c, err := t.newClient("", "", "")
+	if err != nil {
+		return errors.Wrap(err, "create new client failed")
+	}
+	for _, sf := range StaticFiles {
+		res, err := c.get(ctx, sf.Path, url.Values{})
+		if err != nil {
+			return errors.Wrapf(err, "GET %s request failed", sf.Path)
+		}
+		b, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return errors.Wrapf(err, "GET %s body read failed", sf.Path)
+		}
+		if res.StatusCode != 200 {
+			return errorWithStatus(errors.Errorf("GET %s 静的ファイルが取得できません", sf.Path), res.StatusCode, string(b))
+		}
+		sum := sha256.Sum256(b)
+		if hash := hex.EncodeToString(sum[:]); hash != sf.SHA256 {
+			return errors.Errorf("GET %s 静的ファイルが変更されています [size:%d, sha256:%s]", sf.Path, len(b), hash)
+		}
+	}
+	return nil
+}
+
+// expectStatus は偽造したリクエストが期待したstatusで拒否されたかを調べる
+// codes を指定しなければ4xx, 5xxのどれでもよい
+func expectStatus(err error, name string, codes ...int) error {
+	if err == nil {
+		return errors.Errorf("%s 不正なリクエストが成功しました", name)
+	}
+	if e, ok := errors.Cause(err).(*ErrorWithStatus); ok {
+		if len(codes) == 0 && e.StatusCode >= 400 {
+			return nil
+		}
+		for _, code := range codes {
+			if e.StatusCode == code {
+				return nil
+			}
+		}
+		return errors.Errorf("%s 不正なリクエストに対するstatuscodeが正しくありません [%d]", name, e.StatusCode)
+	}
+	return errors.Wrapf(err, "%s に失敗しました", name)
+}
+
+// testForgery はログインしていない、またはセッションを偽造したリクエストで状態が変わらないことを確かめる
+// アプリはCSRFトークンを使っていないので、セッションの検証がされているかをみる
+func (t *PreTester) testForgery(ctx context.Context) error {
+	guest, err := t.newClient("", "", "")
+	if err != nil {
+		return errors.Wrap(err, "create new client failed")
+	}
+	_, err = guest.AddOrder(ctx, TradeTypeBuy, 1, 1)
+	if err := expectStatus(err, "POST /orders (no session)", 401); err != nil {
+		return err
+	}
+	_, err = guest.GetOrders(ctx)
+	if err := expectStatus(err, "GET /orders (no session)", 401); err != nil {
+		return err
+	}
+	if err := expectStatus(guest.DeleteOrders(ctx, 1), "DELETE /order/1 (no session)", 401); err != nil {
+		return err
+	}
+
+	forged, err := t.newClient("", "", "")
+	if err != nil {
+		return errors.Wrap(err, "create new client failed")
+	}
+	// 署名の正しくないセッション
+	forged.hc.Jar.SetCookies(forged.base, []*http.Cookie{
+		{Name: "isucoin_session", Value: "forged-session-value", Path: "/"},
+	})
+	_, err = forged.AddOrder(ctx, TradeTypeBuy, 1, 1)
+	if err := expectStatus(err, "POST /orders (forged session)"); err != nil {
+		return err
+	}
+
+	// 他人の注文はキャンセルできない
+	owner, other := testUsers[10+rand.Intn(10)], testUsers[20+rand.Intn(10)]
+	oc, err := t.newClient(owner.BankID, owner.Name, owner.Pass)
+	if err != nil {
+		return errors.Wrap(err, "create new client failed")
+	}
+	if err := oc.Signin(ctx); err != nil {
+		return errors.Wrapf(err, "Signin(bank:%s,name:%s)", owner.BankID, owner.Name)
+	}
+	orders, err := oc.GetOrders(ctx)
+	if err != nil {
+		return err
+	}
+	var target int64
+	for _, o := range orders {
+		if o.ClosedAt == nil {
+			target = o.ID
+			break
+		}
+	}
+	if target == 0 {
+		log.Printf("[INFO] skip cross user cancel test. no open order [user:%d]", oc.UserID())
+		return nil
+	}
+	xc, err := t.newClient(other.BankID, other.Name, other.Pass)
+	if err != nil {
+		return errors.Wrap(err, "create new client failed")
+	}
+	if err := xc.Signin(ctx); err != nil {
+		return errors.Wrapf(err, "Signin(bank:%s,name:%s)", other.BankID, other.Name)
+	}
+	return expectStatus(xc.DeleteOrders(ctx, target), fmt.Sprintf("DELETE /order/%d (other user)", target), 404)
+}
+
+// invalidOrders は POST /orders に送る不正な値と、その説明
+// int64 を溢れる値は参照実装がパースのエラーを捨てて受け付けてしまうので送らない
+// status は参照実装がどれも ParameterInvalid として返すもの. 0 なら決まっていないので 4xx であればよい
+var invalidOrders = []struct {
+	name   string
+	values url.Values
+	status int
+}{
+	{"amount=0", url.Values{"type": {TradeTypeBuy}, "amount": {"0"}, "price": {"100"}}, 400},
+	{"amount<0", url.Values{"type": {TradeTypeSell}, "amount": {"-1"}, "price": {"100"}}, 400},
+	{"price=0", url.Values{"type": {TradeTypeBuy}, "amount": {"1"}, "price": {"0"}}, 400},
+	{"price<0", url.Values{"type": {TradeTypeSell}, "amount": {"1"}, "price": {"-100"}}, 400},
+	{"amount is not number", url.Values{"type": {TradeTypeBuy}, "amount": {"abc"}, "price": {"100"}}, 0},
+	{"amount=-0", url.Values{"type": {TradeTypeSell}, "amount": {"-0"}, "price": {"100"}}, 400},
+	{"amount is min int64", url.Values{"type": {TradeTypeSell}, "amount": {"-9223372036854775808"}, "price": {"100"}}, 400},
+	{"unknown type", url.Values{"type": {"hold"}, "amount": {"1"}, "price": {"100"}}, 400},
+	{"no type", url.Values{"amount": {"1"}, "price": {"100"}}, 0},
+	{"no amount", url.Values{"type": {TradeTypeBuy}, "price": {"100"}}, 0},
+	{"no price", url.Values{"type": {TradeTypeSell}, "amount": {"1"}}, 0},
+}
+
+// testOrderContract は不正な注文が決まった statuscode で拒否されるかを確かめる
+// エラーのレスポンスの形は実装によって違う (perl はHTMLを返す) ので中身までは見ない
+func (t *PreTester) testOrderContract(ctx context.Context) error {
+	tu := testUsers[30+rand.Intn(10)]
+	c, err := t.newClient(tu.BankID, tu.Name, tu.Pass)
+	if err != nil {
+		return errors.Wrap(err, "create new client failed")
+	}
+	if err := c.Signin(ctx); err != nil {
+		return errors.Wrapf(err, "Signin(bank:%s,name:%s)", tu.BankID, tu.Name)
+	}
+	for _, o := range invalidOrders {
+		name := fmt.Sprintf("POST /orders (%s)", o.name)
+		_, err := c.addOrder(ctx, o.values)
+		if o.status != 0 {
+			if err := expectStatus(err, name, o.status); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := expectStatus(err, name); err != nil {
+			return err
+		}
+		if e := errors.Cause(err).(*ErrorWithStatus); e.StatusCode >= 500 {
+			return errors.Errorf("%s 不正なリクエストに対するstatuscodeが正しくありません [%d]", name, e.StatusCode)
+		}
+	}
+	return nil
+}
+
+// sqlInjectionPayloads は SQL injection を試す典型的な入力
+var sqlInjectionPayloads = []string{
+	`' OR '1'='1`,
+	`' OR 1=1 -- `,
+	`" OR 1=1 -- `,
+	`') OR ('1'='1`,
+	`' OR 1=1#`,
+	`'; DROP TABLE user; -- `,
+	`' UNION SELECT 1, 2, 3, 4, 5 -- `,
+	`1 OR 1=1`,
+	`1; DELETE FROM orders`,
+	`\'`,
+}
+
+// expectSafe は注入を試したリクエストが普通の不正な入力として4xxで拒否されたかを調べる
+// 成功すれば脆弱性として扱う. 5xx は数値の項目を変換できなかっただけのこともあるので脆弱性にはせず、普通のエラーにする
+func expectSafe(err error, name string) error {
+	if err == nil {
+		return vulnErrorf("%s SQL injection の可能性があります. 不正な入力でリクエストが成功しました", name)
+	}
+	if e, ok := errors.Cause(err).(*ErrorWithStatus); ok {
+		if e.StatusCode >= 500 {
+			return errors.Errorf("%s 不正な入力でサーバーエラーになりました [%d]", name, e.StatusCode)
+		}
+		if e.StatusCode >= 400 {
+			return nil
+		}
+	}
+	return errors.Wrapf(err, "%s に失敗しました", name)
+}
+
+// testSQLInjection は SQL injection を試す入力が普通の不正な入力として扱われることを確かめる
+// ログインできたり、リクエストが成功したりすれば脆弱性とみなす
+func (t *PreTester) testSQLInjection(ctx context.Context) error {
+	tu := testUsers[40+rand.Intn(10)]
+	for _, p := range sqlInjectionPayloads {
+		// 存在するbank_idの後ろにつけてパスワードの条件を消そうとする
+		for _, bankid := range []string{p, tu.BankID + p} {
+			c, err := t.newClient(bankid, tu.Name, p)
+			if err != nil {
+				return errors.Wrap(err, "create new client failed")
+			}
+			if err := expectSafe(c.Signin(ctx), fmt.Sprintf("POST /signin (bank_id:%q)", bankid)); err != nil {
+				return err
+			}
+		}
+		c, err := t.newClient(p, p, p)
+		if err != nil {
+			return errors.Wrap(err, "create new client failed")
+		}
+		if err := expectSafe(c.Signup(ctx), fmt.Sprintf("POST /signup (bank_id:%q)", p)); err != nil {
+			return err
+		}
+	}
+
+	// 名前に埋め込んでもそのまま保存される
+	bankid := fmt.Sprintf("sqli%d@isucon.net", time.Now().Unix())
+	if err := t.isubank.NewBankID(bankid); err != nil {
+		return errors.Wrap(err, "new bank_id failed")
+	}
+	c, err := t.newClient(bankid, sqlInjectionPayloads[rand.Intn(len(sqlInjectionPayloads))], "1234567890sqli")
+	if err != nil {
+		return errors.Wrap(err, "create new client failed")
+	}
+	if err := c.Signup(ctx); err != nil {
+		return err
+	}
+	if err := c.Signin(ctx); err != nil {
+		return err
+	}
+	for _, p := range sqlInjectionPayloads {
+		for _, v := range []url.Values{
+			{"type": {p}, "amount": {"1"}, "price": {"100"}},
+			{"type": {TradeTypeBuy}, "amount": {p}, "price": {"100"}},
+			{"type": {TradeTypeSell}, "amount": {"1"}, "price": {p}},
+		} {
+			_, err := c.addOrder(ctx, v)
+			if err := expectSafe(err, fmt.Sprintf("POST /orders (%s)", v.Encode())); err != nil {
+				return err
+			}
+		}
+		path := "/order/" + url.PathEscape(p)
+		res, err := c.del(ctx, path, url.Values{})
+		if err != nil {
+			return errors.Wrapf(err, "DELETE %s request failed", path)
+		}
+		res.Body.Close()
+		if res.StatusCode < 400 {
+			return vulnErrorf("DELETE %s SQL injection の可能性があります [%d]", path, res.StatusCode)
+		}
+		if res.StatusCode >= 500 {
+			return errors.Errorf("DELETE %s 不正な入力でサーバーエラーになりました [%d]", path, res.StatusCode)
+		}
+		// cursor は数値でなければ無視される
+		res, err = c.get(ctx, "/info", url.Values{"cursor": {p}})
+		if err != nil {
+			return errors.Wrap(err, "GET /info request failed")
+		}
+		res.Body.Close()
+		if res.StatusCode >= 500 {
+			return errors.Errorf("GET /info?cursor=%s 不正な入力でサーバーエラーになりました [%d]", url.QueryEscape(p), res.StatusCode)
+		}
+	}
+	return nil
+}
+
+// xssPayloads は名前に埋め込むスクリプト
+var xssPayloads = []string{
+	`<script>alert(1)</script>`,
+	`"><img src=x onerror=alert(1)>`,
+	`</script><svg onload=alert(1)>`,
+	`'-alert(1)-'`,
+	`<iframe src="javascript:alert(1)"></iframe>`,
+}
+
+// testStoredXSS はスクリプトを含む名前がそのままの文字列としてJSONで返されることを確かめる
+// HTMLは静的ファイルで名前を埋め込まないので、名前を返すAPIのContent-Typeと値を見る
+// text/html で返したり、名前を書き換えて返したりすればXSSになりうる
+func (t *PreTester) testStoredXSS(ctx context.Context) error {
+	bankid := fmt.Sprintf("xss%d@isucon.net", time.Now().Unix())
+	if err := t.isubank.NewBankID(bankid); err != nil {
+		return errors.Wrap(err, "new bank_id failed")
+	}
+	// Signin, GetOrders は返ってきた名前が一致するかを確かめる
+	c, err := t.newClient(bankid, xssPayloads[rand.Intn(len(xssPayloads))], "1234567890xss")
+	if err != nil {
+		return errors.Wrap(err, "create new client failed")
+	}
+	if err := c.Signup(ctx); err != nil {
+		return err
+	}
+	if err := c.Signin(ctx); err != nil {
+		return err
+	}
+	// 成約しないように高い売り注文を出してすぐに取り消す
+	order, err := c.AddOrder(ctx, TradeTypeSell, 1, 99999999)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.get(ctx, "/orders", url.Values{})
+	if err != nil {
+		return errors.Wrap(err, "GET /orders request failed")
+	}
+	res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		return vulnErrorf("GET /orders XSS の可能性があります. 名前を含むレスポンスのContent-Typeが正しくありません [%s]", ct)
+	}
+	if _, err := c.GetOrders(ctx); err != nil {
+		return err
+	}
+	return c.DeleteOrders(ctx, order.ID)
 }
 
 func (t *PreTester) Run(ctx context.Context) error {
 	now := time.Now()
-	eg := new(errgroup.Group)
 
 	account1 := fmt.Sprintf("asuzuki%d@isucon.net", now.Unix())
 	account2 := fmt.Sprintf("tmorris%d@isucon.net", now.Unix())
 	name1, name2 := "鈴木 明", "トニー モリス"
 
-	c1, err := NewClient(t.appep, account1, name1, "1234567890abc", ClientTimeout, RetireTimeout)
+	c1, err := t.newClient(account1, name1, "1234567890abc")
 	if err != nil {
 		return errors.Wrap(err, "create new client failed")
 	}
-	c2, err := NewClient(t.appep, account2, name2, "234567890abcd", ClientTimeout, RetireTimeout)
+	c2, err := t.newClient(account2, name2, "234567890abcd")
 	if err != nil {
 		return errors.Wrap(err, "create new client failed")
 	}
 
-	eg.Go(func() error {
+	// 依存していないチェック同士は並列に実行する
+	g := t.checks.graph()
+	g.add("certificate", nil, func() error {
+		log.Printf("[INFO] run certificate test")
+		return t.testCertificate()
+	})
+	g.add("guest", []string{"certificate"}, func() error {
 		log.Printf("[INFO] run guest test")
 		// Top
 		if err := c2.Top(ctx); err != nil {
 			return err
 		}
+		// リロードしたときの304
+		if _, err := c2.Revalidate(ctx); err != nil {
+			return err
+		}
 		// 非ログイン /info
 		info, err := c2.Info(ctx, 0)
 		if err != nil {
@@ -69,13 +495,37 @@ func (t *PreTester) Run(ctx context.Context) error {
 		}
 		return nil
 	})
-	eg.Go(func() error {
+	g.add("static files", []string{"certificate"}, func() error {
+		log.Printf("[INFO] run static file test")
+		return t.testStaticFiles(ctx)
+	})
+	g.add("keep-alive", []string{"certificate"}, func() error {
+		log.Printf("[INFO] run keep-alive test")
+		return t.testKeepAlive(ctx)
+	})
+	g.add("forgery", []string{"certificate"}, func() error {
+		log.Printf("[INFO] run forgery test")
+		return t.testForgery(ctx)
+	})
+	g.add("order contract", []string{"certificate"}, func() error {
+		log.Printf("[INFO] run order contract test")
+		return t.testOrderContract(ctx)
+	})
+	g.add("sql injection", []string{"certificate"}, func() error {
+		log.Printf("[INFO] run sql injection test")
+		return t.testSQLInjection(ctx)
+	})
+	g.add("stored xss", []string{"certificate"}, func() error {
+		log.Printf("[INFO] run stored xss test")
+		return t.testStoredXSS(ctx)
+	})
+	g.add("no account", []string{"certificate"}, func() error {
 		log.Printf("[INFO] run no acount test")
 		err := c1.Signin(ctx)
 		if err == nil {
 			return errors.New("POST /signin 存在しないアカウントでログインに成功しました")
 		}
-		if e, ok := err.(*ErrorWithStatus); ok {
+		if e, ok := errors.Cause(err).(*ErrorWithStatus); ok {
 			if e.StatusCode != 404 {
 				return errors.Errorf("POST /signin 失敗時のstatuscodeが正しくありません [%d]", e.StatusCode)
 			}
@@ -84,10 +534,10 @@ func (t *PreTester) Run(ctx context.Context) error {
 		}
 		return nil
 	})
-	eg.Go(func() error {
+	g.add("exists user", []string{"certificate"}, func() error {
 		log.Printf("[INFO] run exists user test")
 		gd := testUsers[rand.Intn(10)]
-		gc, err := NewClient(t.appep, gd.BankID, gd.Name, gd.Pass, ClientTimeout, RetireTimeout)
+		gc, err := t.newClient(gd.BankID, gd.Name, gd.Pass)
 		if err != nil {
 			return errors.Wrap(err, "create new client failed")
 		}
@@ -120,14 +570,14 @@ func (t *PreTester) Run(ctx context.Context) error {
 		return nil
 	})
 
-	eg.Go(func() error {
+	g.add("bank id not exist", []string{"certificate"}, func() error {
 		log.Printf("[INFO] run bunk id not exist test")
 		// BANK IDが存在しない
 		err := c1.Signup(ctx)
 		if err == nil {
 			return errors.New("POST /signup 銀行に存在しないアカウントサインアップに成功しました。アカウントチェックを指定ない可能性があります")
 		}
-		if e, ok := err.(*ErrorWithStatus); ok {
+		if e, ok := errors.Cause(err).(*ErrorWithStatus); ok {
 			if e.StatusCode != 404 {
 				return errors.Errorf("POST /signup statuscodeが正しくありません [%d]", e.StatusCode)
 			}
@@ -137,17 +587,17 @@ func (t *PreTester) Run(ctx context.Context) error {
 		return nil
 	})
 
-	if err := eg.Wait(); err != nil {
-		return err
-	}
-
-	for _, id := range []string{account1, account2} {
-		if err := t.isubank.NewBankID(id); err != nil {
-			return errors.Wrap(err, "new bank_id failed")
+	// 銀行IDがない状態のチェックが終わってから登録する
+	g.add("register bank ids", []string{"no account", "bank id not exist"}, func() error {
+		for _, id := range []string{account1, account2} {
+			if err := t.isubank.NewBankID(id); err != nil {
+				return errors.Wrap(err, "new bank_id failed")
+			}
 		}
-	}
+		return nil
+	})
 
-	{
+	g.add("signup and signin", []string{"register bank ids", "guest"}, func() error {
 		log.Printf("[INFO] run signup and signin")
 		eg := new(errgroup.Group)
 		for _, c0 := range []*Client{c1, c2} {
@@ -174,11 +624,12 @@ func (t *PreTester) Run(ctx context.Context) error {
 		if err := eg.Wait(); err != nil {
 			return err
 		}
-	}
+		return nil
+	})
 
-	{
+	g.add("conflict", []string{"signup and signin"}, func() error {
 		log.Printf("[INFO] run conflict test")
-		c1x, err := NewClient(t.appep, account1, "鈴木 昭夫", "13467890abc", ClientTimeout, RetireTimeout)
+		c1x, err := t.newClient(account1, "鈴木 昭夫", "13467890abc")
 		if err != nil {
 			return errors.Wrap(err, "create new client failed")
 		}
@@ -186,32 +637,34 @@ func (t *PreTester) Run(ctx context.Context) error {
 		if err == nil {
 			return errors.New("POST /signup 重複アカウントでのサインアップに成功しました")
 		}
-		if e, ok := err.(*ErrorWithStatus); ok {
+		if e, ok := errors.Cause(err).(*ErrorWithStatus); ok {
 			if e.StatusCode != 409 {
 				return errors.Errorf("POST /signup statuscodeが正しくありません [%d]", e.StatusCode)
 			}
 		} else {
 			return errors.Wrap(err, "POST /signup に失敗しました")
 		}
-	}
+		return nil
+	})
 
-	{
+	g.add("buy order no money", []string{"signup and signin"}, func() error {
 		log.Printf("[INFO] run buy order no money")
 		order, err := c1.AddOrder(ctx, TradeTypeBuy, 1, 2000)
 		if err == nil {
 			return errors.Errorf("POST /orders 銀行に残高が足りない買い注文に成功しました [order_id:%d]", order.ID)
 		}
-		if e, ok := err.(*ErrorWithStatus); ok {
+		if e, ok := errors.Cause(err).(*ErrorWithStatus); ok {
 			if e.StatusCode != 400 {
 				return errors.Errorf("POST /orders statuscodeが正しくありません [%d]", e.StatusCode)
 			}
 		} else {
 			return errors.Wrap(err, "POST /orders に失敗しました")
 		}
-	}
+		return nil
+	})
 
 	// 売り注文は成功する
-	{
+	g.add("sell order", []string{"signup and signin"}, func() error {
 		log.Printf("[INFO] run sell order")
 		o, err := c1.AddOrder(ctx, TradeTypeSell, 1, 1000)
 		if err != nil {
@@ -248,9 +701,11 @@ func (t *PreTester) Run(ctx context.Context) error {
 		if g, w := len(orders), 0; g != w {
 			return errors.Errorf("GET /orders 件数が正しくありません[got:%d, want:%d]", g, w)
 		}
-	}
+		return nil
+	})
 
-	{
+	// 他のチェックで出した注文と約定しないように、注文を出すチェックが終わってから始める
+	g.add("trade matching", []string{"buy order no money", "sell order", "forgery", "order contract", "sql injection", "stored xss"}, func() error {
 		log.Printf("[INFO] run trade matching")
 		// 注文をして成立させる
 		// 注文(敢えて並列にしない)
@@ -326,12 +781,12 @@ func (t *PreTester) Run(ctx context.Context) error {
 			}
 			bought := orders[1].Trade.Price * 2
 			time.Sleep(300 * time.Millisecond)
-			rest, err := t.isubank.GetCredit(account1)
+			rest, err := t.isubank.GetCredit(ctx, account1)
 			if err != nil {
 				return err
 			}
 			if rest+bought != 36000 {
-				return errors.Errorf("銀行残高があいません [%d]", rest)
+				return bankErrorf("銀行残高があいません [%d]", rest)
 			}
 			log.Printf("[INFO] 残高チェック OK(c1)")
 
@@ -342,7 +797,7 @@ func (t *PreTester) Run(ctx context.Context) error {
 					case <-timeout:
 						return errors.Errorf("ログが送信されていません(c1)")
 					default:
-						logs, err := t.isulog.GetUserLogs(c1.UserID())
+						logs, err := t.isulog.GetUserLogs(ctx, c1.UserID())
 						if err != nil {
 							return errors.Wrap(err, "isulog get user logs failed")
 						}
@@ -431,12 +886,12 @@ func (t *PreTester) Run(ctx context.Context) error {
 			}
 			bought := orders[1].Trade.Price + orders[2].Trade.Price
 			time.Sleep(300 * time.Millisecond)
-			rest, err := t.isubank.GetCredit(account2)
+			rest, err := t.isubank.GetCredit(ctx, account2)
 			if err != nil {
 				return err
 			}
 			if rest != bought {
-				return errors.Errorf("銀行残高があいません [%d]", rest)
+				return bankErrorf("銀行残高があいません [%d]", rest)
 			}
 			log.Printf("[INFO] 残高チェック OK(c2)")
 
@@ -450,7 +905,7 @@ func (t *PreTester) Run(ctx context.Context) error {
 						log.Printf("[DEBUG] logs % #v", logs)
 						return errors.Errorf("ログが送信されていません(c2)")
 					default:
-						logs, err = t.isulog.GetUserLogs(c2.UserID())
+						logs, err = t.isulog.GetUserLogs(ctx, c2.UserID())
 						if err != nil {
 							return errors.Wrap(err, "isulog get user logs failed")
 						}
@@ -496,9 +951,10 @@ func (t *PreTester) Run(ctx context.Context) error {
 			return err
 		}
 		log.Printf("[INFO] 取引テストFinish")
-	}
+		return nil
+	})
 
-	return nil
+	return g.run()
 }
 
 type testUser interface {
@@ -512,14 +968,24 @@ type testUser interface {
 }
 
 type PostTester struct {
-	appep   string
-	isulog  *isulog.Isulog
-	isubank *isubank.Isubank
-	users   []testUser
-	tested  []testUser
+	appep    string
+	logDelay time.Duration // isulogへの反映の遅延をどこまで許すか
+	isulog   *isulog.Isulog
+	isubank  *isubank.Isubank
+	users    []testUser
+	tested   []testUser
+	checks   checkSuite
+	fault    isubank.Fault // 負荷走行中にisubankに注入した障害
+	chaosEnd time.Time     // 外部サービスの障害が最後に終わった時刻
+	ids      *idRegistry   // 負荷走行中に見た注文と成約のID
+	slog     *slog.Logger  // 障害注入後の巻き戻しチェックの結果 (component: bankfault)
+
+	userCount int // 残高とログを確かめるユーザー数. 最初と最後に取引したユーザーと無作為な1人より少なくはしない
+	workers   int // 同時に確かめるユーザー数
 }
 
 func (t *PostTester) Run(ctx context.Context) error {
+	loadEnd := time.Now()
 	users := make([]testUser, 0, len(t.users))
 	for _, tu := range t.users {
 		if tu.UserID() > 0 && !tu.Ignore() {
@@ -530,7 +996,7 @@ func (t *PostTester) Run(ctx context.Context) error {
 		return errors.Errorf("ユーザーが全滅しています")
 	}
 	var trade *Trade
-	{
+	if err := t.checks.run("select users", func() error {
 		first, latest, random := users[0], users[len(users)-1], users[rand.Intn(len(users))]
 		for len(users) >= 3 && (first.UserID() == random.UserID() || latest.UserID() == random.UserID()) {
 			random = users[rand.Intn(len(users)-2)+1]
@@ -548,22 +1014,29 @@ func (t *PostTester) Run(ctx context.Context) error {
 		if trade == nil {
 			return errors.Errorf("取引に成功したユーザーが全滅しているか、一人もいません")
 		}
+		if !t.chaosEnd.IsZero() && trade.CreatedAt.Before(t.chaosEnd) {
+			return errors.Errorf("外部サービスの障害から復旧した後に成立した取引がありません")
+		}
 		t.tested = []testUser{first, latest, random}
+		// 確かめる人数が多ければ残りは無作為に選ぶ
+		t.tested = append(t.tested, sampleUsers(users, t.tested, t.userCount-len(t.tested))...)
+		return nil
+	}); err != nil {
+		return err
 	}
-	eg := new(errgroup.Group)
-	for _, tu := range t.tested {
-		user := tu
-		eg.Go(func() error {
+	if err := t.eachUser(t.tested, func(user testUser) error {
+		return t.checks.run(fmt.Sprintf("cancel orders [user:%d]", user.UserID()), func() error {
 			if err := user.FetchOrders(ctx); err != nil {
-				return errors.Wrapf(err, "注文情報の取得に失敗しました [user:%d]", user.UserID)
+				return errors.Wrapf(err, "注文情報の取得に失敗しました [user:%d]", user.UserID())
 			}
+			eg := new(errgroup.Group)
 			for _, order := range user.Orders() {
 				if order.ClosedAt == nil {
 					// 未成約の注文はキャンセルしておく
 					o := order
 					eg.Go(func() error {
 						err := user.Client().DeleteOrders(ctx, o.ID)
-						if er, ok := err.(*ErrorWithStatus); ok && er.StatusCode == 404 {
+						if er, ok := errors.Cause(err).(*ErrorWithStatus); ok && er.StatusCode == 404 {
 							err = nil
 						}
 						if o.ClosedAt == nil {
@@ -574,25 +1047,28 @@ func (t *PostTester) Run(ctx context.Context) error {
 					})
 				}
 			}
-			return nil
+			return eg.Wait()
 		})
-	}
-	if err := eg.Wait(); err != nil {
+	}); err != nil {
 		return err
 	}
 
-	deadline := time.Now().Add(LogAllowedDelay)
+	// 残り時間が少なければ ctx の期限までに諦める
+	deadline := time.Now().Add(t.logDelay)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
 
-	eg = new(errgroup.Group)
+	eg := new(errgroup.Group)
 
-	eg.Go(func() error {
+	eg.Go(t.checks.wrap("trade logs", func() error {
 		timeout := time.After(deadline.Sub(time.Now()))
 		for {
 			select {
 			case <-timeout:
 				return errors.Errorf("ログが欠損しています [trade:%d]", trade.ID)
 			default:
-				logs, err := t.isulog.GetTradeLogs(trade.ID)
+				logs, err := t.isulog.GetTradeLogs(ctx, trade.ID)
 				if err != nil {
 					return errors.Wrap(err, "isulog get trade logs failed")
 				}
@@ -617,117 +1093,501 @@ func (t *PostTester) Run(ctx context.Context) error {
 					return nil
 				}
 			}
-			time.Sleep(PollingInterval)
+			if err := pollWait(ctx, PollingInterval); err != nil {
+				return err
+			}
 		}
+	}))
+	eg.Go(func() error {
+		return t.eachUser(t.tested, func(user testUser) error {
+			return t.checks.run(fmt.Sprintf("credit and logs [user:%d]", user.UserID()), func() error {
+				return t.testCreditAndLogs(ctx, user, deadline)
+			})
+		})
 	})
-	for _, tu := range t.tested {
+	if t.fault.Failed+t.fault.TimedOut > 0 {
+		eg.Go(t.checks.wrap("bank rollback", func() error {
+			return t.testRollback(ctx, deadline)
+		}))
+	}
+	eg.Go(t.checks.wrap("candlesticks", func() error {
+		return t.testCandlesticks(ctx)
+	}))
+	eg.Go(t.checks.wrap("info cursor", func() error {
+		return t.testInfoCursor(ctx)
+	}))
+	if t.ids != nil {
+		eg.Go(t.checks.wrap("unique ids", t.ids.verify))
+		eg.Go(t.checks.wrap("price-time priority", t.ids.verifyPriority))
+		eg.Go(t.checks.wrap("trade liveness", func() error {
+			info, err := t.tested[0].Client().Info(ctx, 0)
+			if err != nil {
+				return errors.Wrap(err, "チャートの取得に失敗しました")
+			}
+			return t.ids.verifyLiveness(info.ChartBySec, t.chaosEnd, loadEnd)
+		}))
+	}
 
-		user := tu
-		eg.Go(func() error {
-			timeout := time.After(deadline.Sub(time.Now()))
-			var credit int64
-			for credit != user.Credit() {
-				select {
-				case <-timeout:
-					if credit == 0 {
-						return errors.Errorf("処理がおそすぎてチェックの準備が整いませんでした[user:%d]", user.UserID())
-					}
-					log.Printf("[DEBUG] 銀行残高があいません [user:%d,bank:%s,bankCredit:%d,benchCredit:%d]", user.UserID(), user.BankID(), credit, user.Credit())
-					return errors.Errorf("銀行残高があいません[user:%d]", user.UserID())
-				default:
-					var err error
-					credit, err = t.isubank.GetCredit(user.BankID())
-					if err != nil {
-						return errors.Wrap(err, "ISUBANK APIとの通信に失敗しました")
-					}
-					if credit == user.Credit() {
-						log.Printf("[INFO] 残高チェックOK (point1) [user:%d]", user.UserID())
-						break
-					}
-					if err = user.FetchOrders(ctx); err != nil {
-						return err
-					}
-					if credit == user.Credit() {
-						log.Printf("[INFO] 残高チェックOK (point2) [user:%d]", user.UserID())
-						break
-					}
-					time.Sleep(time.Millisecond * 500)
-				}
+	return eg.Wait()
+}
+
+// sampleUsers は users のうち selected にいないユーザーを無作為に n 人まで選ぶ
+func sampleUsers(users, selected []testUser, n int) []testUser {
+	if n <= 0 {
+		return nil
+	}
+	seen := make(map[int64]bool, len(selected))
+	for _, u := range selected {
+		seen[u.UserID()] = true
+	}
+	rest := make([]testUser, 0, len(users))
+	for _, u := range users {
+		if !seen[u.UserID()] {
+			rest = append(rest, u)
+		}
+	}
+	rand.Shuffle(len(rest), func(i, j int) { rest[i], rest[j] = rest[j], rest[i] })
+	if len(rest) > n {
+		rest = rest[:n]
+	}
+	return rest
+}
+
+// eachUser は users それぞれについて f を同時に workers 人まで実行する
+// 途中で失敗しても全員を確かめ、失敗したユーザーの数と最初のユーザーのエラーを返す
+func (t *PostTester) eachUser(users []testUser, f func(testUser) error) error {
+	workers := t.workers
+	if workers < 1 {
+		workers = 1
+	}
+	errs := make([]error, len(users))
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i, u := range users {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, u testUser) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = f(u)
+		}(i, u)
+	}
+	wg.Wait()
+	var first error
+	failed := 0
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if first == nil {
+			first = err
+		}
+		failed++
+	}
+	if failed > 1 {
+		return errors.Wrapf(first, "%d人のユーザーで失敗しました", failed)
+	}
+	return first
+}
+
+// testCreditAndLogs は user の銀行残高と入出金履歴, isulogのログがベンチの把握している注文と一致するかを確かめる
+func (t *PostTester) testCreditAndLogs(ctx context.Context, user testUser, deadline time.Time) error {
+	timeout := time.After(deadline.Sub(time.Now()))
+	var credit int64
+	for credit != user.Credit() {
+		select {
+		case <-timeout:
+			if credit == 0 {
+				return errors.Errorf("処理がおそすぎてチェックの準備が整いませんでした[user:%d]", user.UserID())
 			}
-			var buy, sell, buyt, sellt, buyd, selld int
-			for _, order := range user.Orders() {
-				switch order.Type {
-				case TradeTypeBuy:
-					buy++
-					if order.TradeID > 0 {
-						buyt++
-					} else if order.Removed() {
-						buyd++
-					}
-				case TradeTypeSell:
-					sell++
-					if order.TradeID > 0 {
-						sellt++
-					} else if order.Removed() {
-						selld++
-					}
-				}
+			log.Printf("[DEBUG] 銀行残高があいません [user:%d,bank:%s,bankCredit:%d,benchCredit:%d]", user.UserID(), user.BankID(), credit, user.Credit())
+			return bankErrorf("銀行残高があいません[user:%d]", user.UserID())
+		default:
+			var err error
+			credit, err = t.isubank.GetCredit(ctx, user.BankID())
+			if err != nil {
+				return errors.Wrap(err, "ISUBANK APIとの通信に失敗しました")
 			}
-			for {
-				select {
-				case <-timeout:
-					return errors.Errorf("ログが欠損しています [user:%d]", user.UserID())
-				default:
-					logs, err := t.isulog.GetUserLogs(user.UserID())
-					if err != nil {
-						return errors.Wrap(err, "isulog get user logs failed")
-					}
-					ok := func() bool {
-						if c := countLog(logs, isulog.TagSignup); c == 0 {
-							log.Printf("[INFO] not match log type: %s, nothing", isulog.TagSignup)
-							return false
-						}
-						if c := countLog(logs, isulog.TagSignin); c == 0 {
-							log.Printf("[INFO] not match log type: %s, nothing", isulog.TagSignin)
-							return false
-						}
-						if c := countLog(logs, isulog.TagBuyOrder); c < buy {
-							log.Printf("[INFO] not match log type: %s, %d < %d", isulog.TagBuyOrder, c, buy)
-							return false
-						}
-						if c := countLog(logs, isulog.TagBuyTrade); c < buyt {
-							log.Printf("[INFO] not match log type: %s, %d < %d", isulog.TagBuyTrade, c, buyt)
-							return false
-						}
-						if c := countLog(logs, isulog.TagBuyDelete); c < buyd {
-							log.Printf("[INFO] not match log type: %s, %d < %d", isulog.TagBuyDelete, c, buyd)
-							return false
-						}
-						if c := countLog(logs, isulog.TagSellOrder); c < sell {
-							log.Printf("[INFO] not match log type: %s, %d < %d", isulog.TagSellOrder, c, sell)
-							return false
-						}
-						if c := countLog(logs, isulog.TagSellTrade); c < sellt {
-							log.Printf("[INFO] not match log type: %s, %d < %d", isulog.TagSellTrade, c, sellt)
-							return false
-						}
-						if c := countLog(logs, isulog.TagSellDelete); c < selld {
-							log.Printf("[INFO] not match log type: %s, %d < %d", isulog.TagSellDelete, c, selld)
-							return false
-						}
-						return true
-					}()
-					if ok {
-						log.Printf("[INFO] ユーザーログチェックOK [user:%d]", user.UserID())
-						return nil
-					}
+			if credit == user.Credit() {
+				log.Printf("[INFO] 残高チェックOK (point1) [user:%d]", user.UserID())
+				break
+			}
+			if err = user.FetchOrders(ctx); err != nil {
+				return err
+			}
+			if credit == user.Credit() {
+				log.Printf("[INFO] 残高チェックOK (point2) [user:%d]", user.UserID())
+				break
+			}
+			if err = pollWait(ctx, time.Millisecond*500); err != nil {
+				return err
+			}
+		}
+	}
+	if err := t.reconcileBank(ctx, user); err != nil {
+		return err
+	}
+	var missing []string
+	for {
+		select {
+		case <-timeout:
+			return errors.Errorf("ログが欠損しています [user:%d, missing:%s]", user.UserID(), summarizeMissing(missing))
+		default:
+			logs, err := t.isulog.GetUserLogs(ctx, user.UserID())
+			if err != nil {
+				return errors.Wrap(err, "isulog get user logs failed")
+			}
+			missing = missingLogs(user.Orders(), logs)
+			if len(missing) == 0 {
+				if forged := forgedLogs(user, logs); len(forged) > 0 {
+					return errors.Errorf("ベンチが行っていない操作のログがあります [user:%d, logs:%s]", user.UserID(), summarizeMissing(forged))
+				}
+				if err := t.testLogAppID(ctx, user); err != nil {
+					return err
 				}
-				time.Sleep(PollingInterval)
+				log.Printf("[INFO] ユーザーログチェックOK [user:%d]", user.UserID())
+				return nil
 			}
-		})
+		}
+		if err := pollWait(ctx, PollingInterval); err != nil {
+			return err
+		}
 	}
+}
 
-	return eg.Wait()
+// pollWait は次に問い合わせるまで d だけ待つ. その間に ctx が終わればエラーを返す
+func pollWait(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "事後テストが時間内に終わりませんでした")
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// testCandlesticks はベンチが把握している成約からローソク足を計算し直し、/info のチャートと比べる
+// 初期データや退役したユーザーの成約はベンチからは見えないので、
+// 把握している成約がその足の高値と安値の範囲に収まっているかを確かめる
+func (t *PostTester) testCandlesticks(ctx context.Context) error {
+	trades := map[int64]*Trade{}
+	for _, user := range t.users {
+		for _, order := range user.Orders() {
+			if order.Trade != nil {
+				trades[order.Trade.ID] = order.Trade
+			}
+		}
+	}
+	info, err := t.tested[0].Client().Info(ctx, 0)
+	if err != nil {
+		return errors.Wrap(err, "チャートの取得に失敗しました")
+	}
+	if err := testChartsWithTrades(info, trades); err != nil {
+		return err
+	}
+	log.Printf("[INFO] チャートチェックOK [trades:%d]", len(trades))
+	return nil
+}
+
+// testInfoCursor は /info の cursor に 0, 途中の成約, 最新の成約, 存在しない未来の成約を渡して、
+// cursor より後の成約だけが返り、チャートが cursor なしの結果と一致することを確かめる
+// 負荷走行は終わっているので、その間に新しい成約はない前提
+func (t *PostTester) testInfoCursor(ctx context.Context) error {
+	c := t.tested[0].Client()
+	full, err := c.Info(ctx, 0)
+	if err != nil {
+		return err
+	}
+	tradeIDs := make([]int64, 0, len(full.TradedOrders))
+	for _, o := range full.TradedOrders {
+		if o.Trade != nil {
+			tradeIDs = append(tradeIDs, o.Trade.ID)
+		}
+	}
+	sort.Slice(tradeIDs, func(i, j int) bool { return tradeIDs[i] < tradeIDs[j] })
+	cursors := []int64{full.Cursor, math.MaxInt64 / 2}
+	if len(tradeIDs) > 1 {
+		cursors = append(cursors, tradeIDs[len(tradeIDs)/2])
+	}
+	for _, cursor := range cursors {
+		path := fmt.Sprintf("/info?cursor=%d", cursor)
+		info, err := c.Info(ctx, cursor)
+		if err != nil {
+			return err
+		}
+		if info.Cursor < full.Cursor {
+			return errors.Errorf("GET %s cursor が戻っています [cursor:%d, expected:%d]", path, info.Cursor, full.Cursor)
+		}
+		got := map[int64]bool{}
+		for _, o := range info.TradedOrders {
+			if o.Trade == nil || o.Trade.ID <= cursor {
+				return errors.Errorf("GET %s cursor 以前の成約が含まれています [order:%d]", path, o.ID)
+			}
+			got[o.ID] = true
+		}
+		for _, o := range full.TradedOrders {
+			if o.Trade != nil && o.Trade.ID > cursor && !got[o.ID] {
+				return errors.Errorf("GET %s cursor より後の成約が含まれていません [order:%d, trade:%d]", path, o.ID, o.Trade.ID)
+			}
+		}
+		if err := sameCandles(path, full.ChartBySec, info.ChartBySec); err != nil {
+			return err
+		}
+	}
+	log.Printf("[INFO] cursorチェックOK [cursors:%v]", cursors)
+	return nil
+}
+
+// sameCandles は part の足が full の同じ時刻の足と一致するか調べる
+func sameCandles(path string, full, part []CandlestickData) error {
+	byTime := make(map[int64]CandlestickData, len(full))
+	for _, c := range full {
+		byTime[c.Time.Unix()] = c
+	}
+	for _, c := range part {
+		fc, ok := byTime[c.Time.Unix()]
+		if !ok || fc.Open != c.Open || fc.Close != c.Close || fc.High != c.High || fc.Low != c.Low {
+			return errors.Errorf("GET %s chart_by_sec が cursor なしのときと一致しません [time:%s]", path, c.Time)
+		}
+	}
+	return nil
+}
+
+func testCharts(info *InfoResponse) error {
+	return testChartsWithTrades(info, nil)
+}
+
+func testChartsWithTrades(info *InfoResponse, trades map[int64]*Trade) error {
+	charts := []struct {
+		name   string
+		unit   time.Duration
+		candle []CandlestickData
+	}{
+		{"chart_by_sec", time.Second, info.ChartBySec},
+		{"chart_by_min", time.Minute, info.ChartByMin},
+		{"chart_by_hour", time.Hour, info.ChartByHour},
+	}
+	for _, chart := range charts {
+		if err := testCandlestick(chart.name, chart.unit, chart.candle, trades); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func testCandlestick(name string, unit time.Duration, candles []CandlestickData, trades map[int64]*Trade) error {
+	if len(candles) == 0 {
+		return nil
+	}
+	byTime := make(map[int64]CandlestickData, len(candles))
+	for i, c := range candles {
+		if !c.Time.Truncate(unit).Equal(c.Time) {
+			return errors.Errorf("GET /info %s の時刻が単位で区切られていません [time:%s]", name, c.Time)
+		}
+		if i > 0 && !candles[i-1].Time.Before(c.Time) {
+			return errors.Errorf("GET /info %s が時刻順になっていません [time:%s]", name, c.Time)
+		}
+		if c.Low > c.High || c.Open < c.Low || c.Open > c.High || c.Close < c.Low || c.Close > c.High {
+			return errors.Errorf("GET /info %s の値が不正です [time:%s, open:%d, close:%d, high:%d, low:%d]", name, c.Time, c.Open, c.Close, c.High, c.Low)
+		}
+		byTime[c.Time.Unix()] = c
+	}
+	first := candles[0].Time
+	for _, trade := range trades {
+		at := trade.CreatedAt.Truncate(unit)
+		if at.Before(first) {
+			// チャートの範囲外
+			continue
+		}
+		c, ok := byTime[at.Unix()]
+		if !ok {
+			return errors.Errorf("GET /info %s に成約が反映されていません [trade:%d, time:%s]", name, trade.ID, at)
+		}
+		if trade.Price < c.Low || trade.Price > c.High {
+			return errors.Errorf("GET /info %s の集計が正しくありません [trade:%d, price:%d, time:%s, high:%d, low:%d]", name, trade.ID, trade.Price, at, c.High, c.Low)
+		}
+	}
+	return nil
+}
+
+// reconcileBank は銀行の入出金履歴とアプリが返した成約を突き合わせ、
+// 成約にない入出金 (お金が湧いたり消えたりしていないか) を調べる
+func (t *PostTester) reconcileBank(ctx context.Context, user testUser) error {
+	history, err := t.isubank.GetCreditHistory(ctx, user.BankID())
+	if err != nil {
+		return errors.Wrap(err, "ISUBANK APIとの通信に失敗しました")
+	}
+	expected := []int64{}
+	for _, order := range user.Orders() {
+		if order.Trade == nil {
+			continue
+		}
+		switch order.Type {
+		case TradeTypeSell:
+			expected = append(expected, order.Amount*order.Trade.Price)
+		case TradeTypeBuy:
+			expected = append(expected, -order.Amount*order.Trade.Price)
+		}
+	}
+	actual := []int64{}
+	for _, h := range history {
+		if h.Note != isubank.AddCreditNote {
+			actual = append(actual, h.Amount)
+		}
+	}
+	sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+	sort.Slice(actual, func(i, j int) bool { return actual[i] < actual[j] })
+	if len(expected) != len(actual) {
+		log.Printf("[DEBUG] 入出金履歴の件数があいません [user:%d, bank:%s, trades:%d, history:%d]", user.UserID(), user.BankID(), len(expected), len(actual))
+		return bankErrorf("銀行の入出金履歴が成約と一致しません[user:%d]", user.UserID())
+	}
+	for i := range expected {
+		if expected[i] != actual[i] {
+			log.Printf("[DEBUG] 入出金履歴の金額があいません [user:%d, bank:%s, trade:%d, history:%d]", user.UserID(), user.BankID(), expected[i], actual[i])
+			return bankErrorf("銀行の入出金履歴が成約と一致しません[user:%d]", user.UserID())
+		}
+	}
+	log.Printf("[INFO] 入出金履歴チェックOK [user:%d]", user.UserID())
+	return nil
+}
+
+// missingLogs はユーザーの操作のうちisulogに記録されていないものを "tag(order_id:1)" の形で返す
+// アプリがどの買い注文で buy.error を出したかはベンチからは分からないので見ない
+func missingLogs(orders []*Order, logs []*isulog.Log) []string {
+	logged := make(map[string]bool, len(logs))
+	for _, l := range logs {
+		var orderID int64
+		switch l.Tag {
+		case isulog.TagBuyOrder:
+			orderID = l.BuyOrder.OrderID
+		case isulog.TagSellOrder:
+			orderID = l.SellOrder.OrderID
+		case isulog.TagBuyTrade:
+			orderID = l.BuyTrade.OrderID
+		case isulog.TagSellTrade:
+			orderID = l.SellTrade.OrderID
+		case isulog.TagBuyDelete:
+			orderID = l.BuyDelete.OrderID
+		case isulog.TagSellDelete:
+			orderID = l.SellDelete.OrderID
+		}
+		logged[logKey(l.Tag, orderID)] = true
+	}
+	missing := []string{}
+	for _, tag := range []string{isulog.TagSignup, isulog.TagSignin} {
+		if !logged[logKey(tag, 0)] {
+			missing = append(missing, tag)
+		}
+	}
+	for _, o := range orders {
+		var tag string
+		switch {
+		case o.TradeID > 0:
+			tag = o.Type + ".trade"
+		case o.Removed():
+			tag = o.Type + ".delete"
+		}
+		if k := logKey(o.Type+".order", o.ID); !logged[k] {
+			missing = append(missing, k)
+		}
+		if tag != "" && !logged[logKey(tag, o.ID)] {
+			missing = append(missing, logKey(tag, o.ID))
+		}
+	}
+	return missing
+}
+
+// forgedLogs は user のログのうちベンチが行った操作と一致しないものを返す
+// user_id が違うもの、知らない注文のもの、成約やキャンセルしていない注文の成約やキャンセルのもの
+func forgedLogs(user testUser, logs []*isulog.Log) []string {
+	orders := make(map[int64]*Order, len(user.Orders()))
+	for _, o := range user.Orders() {
+		orders[o.ID] = o
+	}
+	uid := user.UserID()
+	forged := []string{}
+	check := func(tag string, userID, orderID int64, ok func(o *Order) bool) {
+		if userID != uid {
+			forged = append(forged, fmt.Sprintf("%s[user_id:%d]", logKey(tag, orderID), userID))
+			return
+		}
+		if o, found := orders[orderID]; !found || !ok(o) {
+			forged = append(forged, logKey(tag, orderID))
+		}
+	}
+	isType := func(typ string) func(o *Order) bool {
+		return func(o *Order) bool { return o.Type == typ }
+	}
+	traded := func(typ string, tradeID int64) func(o *Order) bool {
+		return func(o *Order) bool { return o.Type == typ && o.TradeID == tradeID }
+	}
+	removed := func(typ string) func(o *Order) bool {
+		return func(o *Order) bool { return o.Type == typ && o.Removed() }
+	}
+	signups := 0
+	for _, l := range logs {
+		switch l.Tag {
+		case isulog.TagSignup:
+			signups++
+			if l.Signup.UserID != uid || l.Signup.BankID != user.BankID() {
+				forged = append(forged, fmt.Sprintf("%s[user_id:%d, bank_id:%s]", l.Tag, l.Signup.UserID, l.Signup.BankID))
+			}
+		case isulog.TagSignin:
+			if l.Signin.UserID != uid {
+				forged = append(forged, fmt.Sprintf("%s[user_id:%d]", l.Tag, l.Signin.UserID))
+			}
+		case isulog.TagBuyError:
+			if l.BuyError.UserID != uid {
+				forged = append(forged, fmt.Sprintf("%s[user_id:%d]", l.Tag, l.BuyError.UserID))
+			}
+		case isulog.TagBuyOrder:
+			check(l.Tag, l.BuyOrder.UserID, l.BuyOrder.OrderID, isType(TradeTypeBuy))
+		case isulog.TagSellOrder:
+			check(l.Tag, l.SellOrder.UserID, l.SellOrder.OrderID, isType(TradeTypeSell))
+		case isulog.TagBuyTrade:
+			check(l.Tag, l.BuyTrade.UserID, l.BuyTrade.OrderID, traded(TradeTypeBuy, l.BuyTrade.TradeID))
+		case isulog.TagSellTrade:
+			check(l.Tag, l.SellTrade.UserID, l.SellTrade.OrderID, traded(TradeTypeSell, l.SellTrade.TradeID))
+		case isulog.TagBuyDelete:
+			check(l.Tag, l.BuyDelete.UserID, l.BuyDelete.OrderID, removed(TradeTypeBuy))
+		case isulog.TagSellDelete:
+			check(l.Tag, l.SellDelete.UserID, l.SellDelete.OrderID, removed(TradeTypeSell))
+		}
+	}
+	if signups > 1 {
+		forged = append(forged, fmt.Sprintf("%s[%d件]", isulog.TagSignup, signups))
+	}
+	return forged
+}
+
+// testLogAppID はisubankのapp_idでログが送られていないかを調べる
+// isulogのapp_idを取り違えているとベンチからはログが見えないので欠損と区別できるようにする
+func (t *PostTester) testLogAppID(ctx context.Context, user testUser) error {
+	logs, err := t.isulog.WithAppID(t.isubank.AppID()).GetUserLogs(ctx, user.UserID())
+	if err != nil {
+		if _, ok := err.(*isulog.InvalidLogError); !ok {
+			return errors.Wrap(err, "isulog get user logs failed")
+		}
+	}
+	if len(logs) > 0 || err != nil {
+		return errors.Errorf("isubankのapp_idでisulogにログが送られています [user:%d]", user.UserID())
+	}
+	return nil
+}
+
+func logKey(tag string, orderID int64) string {
+	if orderID == 0 {
+		return tag
+	}
+	return fmt.Sprintf("%s(order_id:%d)", tag, orderID)
+}
+
+// summarizeMissing はエラーメッセージが長くなりすぎないように先頭だけを残す
+func summarizeMissing(missing []string) string {
+	const max = 10
+	if len(missing) <= max {
+		return strings.Join(missing, ", ")
+	}
+	return fmt.Sprintf("%s ...他%d件", strings.Join(missing[:max], ", "), len(missing)-max)
 }
 
 func filterLogs(logs []*isulog.Log, tag string) []*isulog.Log {
@@ -0,0 +1,159 @@
+package bench
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const TraceMaxEvents = 200000 // 記録するリクエストの最大数
+
+// TraceEvent はClientが送った1つのリクエスト
+type TraceEvent struct {
+	BankID string    `json:"bank_id"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end,omitempty"`
+	Status int       `json:"status,omitempty"`
+	Error  string    `json:"error,omitempty"`
+
+	LongPoll bool `json:"long_poll,omitempty"` // アプリに待たせた GET /info
+}
+
+// TraceHook はClientのリクエストの開始と終了を受け取る
+type TraceHook interface {
+	OnStart(ev TraceEvent)
+	OnFinish(ev TraceEvent)
+	OnError(ev TraceEvent, err error)
+}
+
+// activityLog はユーザーごとのリクエストを時系列で記録する
+// 負荷走行後にユーザー間で偏りなく処理されていたかを調べる用
+type activityLog struct {
+	mu      sync.Mutex
+	events  []TraceEvent
+	dropped int
+}
+
+func newActivityLog() *activityLog {
+	return &activityLog{events: make([]TraceEvent, 0, 10000)}
+}
+
+func (a *activityLog) OnStart(ev TraceEvent) {}
+
+func (a *activityLog) OnFinish(ev TraceEvent) {
+	a.add(ev)
+}
+
+func (a *activityLog) OnError(ev TraceEvent, err error) {
+	ev.Error = err.Error()
+	a.add(ev)
+}
+
+func (a *activityLog) add(ev TraceEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.events) >= TraceMaxEvents {
+		a.dropped++
+		return
+	}
+	a.events = append(a.events, ev)
+}
+
+// WriteTo は記録したリクエストを1行1件のJSONで書き出す
+func (a *activityLog) WriteTo(w io.Writer) (int64, error) {
+	a.mu.Lock()
+	events := make([]TraceEvent, len(a.events))
+	copy(events, a.events)
+	a.mu.Unlock()
+	cw := &countWriter{w: w}
+	enc := json.NewEncoder(cw)
+	for _, ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, nil
+}
+
+// traceHooks は複数の TraceHook に順に渡す
+type traceHooks []TraceHook
+
+func (hs traceHooks) OnStart(ev TraceEvent) {
+	for _, h := range hs {
+		h.OnStart(ev)
+	}
+}
+
+func (hs traceHooks) OnFinish(ev TraceEvent) {
+	for _, h := range hs {
+		h.OnFinish(ev)
+	}
+}
+
+func (hs traceHooks) OnError(ev TraceEvent, err error) {
+	for _, h := range hs {
+		h.OnError(ev, err)
+	}
+}
+
+// requestLog はリクエストを rate の割合で抜き出してログに出す
+// GetLogs で返すログには含めないので、多めに出しても結果のログは溢れない
+type requestLog struct {
+	rate   float64
+	logger *log.Logger
+}
+
+func (r *requestLog) OnStart(ev TraceEvent) {}
+
+func (r *requestLog) OnFinish(ev TraceEvent) {
+	if rand.Float64() < r.rate {
+		r.logger.Printf("request: %s %s %d %.3fms [user:%s]", ev.Method, ev.Path, ev.Status, msec(ev.End.Sub(ev.Start)), ev.BankID)
+	}
+}
+
+func (r *requestLog) OnError(ev TraceEvent, err error) {
+	if rand.Float64() < r.rate {
+		r.logger.Printf("request: %s %s %d %.3fms [user:%s] err: %s", ev.Method, ev.Path, ev.Status, msec(ev.End.Sub(ev.Start)), ev.BankID, err)
+	}
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// EnableTrace は以降に作るユーザーのリクエストを記録する
+func (c *Manager) EnableTrace() {
+	c.activity = newActivityLog()
+}
+
+// EnableRequestLog は以降に作るユーザーのリクエストを rate の割合でログに出す
+func (c *Manager) EnableRequestLog(rate float64) {
+	c.reqlog = &requestLog{rate: rate, logger: c.reqLogger}
+}
+
+// WriteTrace は記録したリクエストを書き出す. EnableTrace していなければ何もしない
+func (c *Manager) WriteTrace(w io.Writer) error {
+	if c.activity == nil {
+		return nil
+	}
+	_, err := c.activity.WriteTo(w)
+	c.activity.mu.Lock()
+	dropped := c.activity.dropped
+	c.activity.mu.Unlock()
+	if dropped > 0 {
+		c.Logger().Printf("trace: %d requests were not recorded (max %d)", dropped, TraceMaxEvents)
+	}
+	return err
+}
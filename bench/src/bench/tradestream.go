@@ -0,0 +1,146 @@
+package bench
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+const TradeStreamGrace = 5 * time.Second // /info に出てからWebSocketで通知されるまで待つ時間
+
+// TradeWatcher はアプリがWebSocketで配信する成約を受け取り、/info の内容と突き合わせる
+// 通知は必須ではないので、接続できなかった場合は何も検証しない
+type TradeWatcher struct {
+	smchan chan ScoreMsg
+
+	mu        sync.Mutex
+	connected bool
+	pushed    map[int64]Trade     // 通知された成約
+	pending   map[int64]time.Time // /info に出たがまだ通知されていない成約
+	confirmed map[int64]bool      // /info と一致が確認できた成約
+	missed    map[int64]bool      // 通知漏れとして報告済みの成約
+}
+
+func NewTradeWatcher(smchan chan ScoreMsg) *TradeWatcher {
+	return &TradeWatcher{
+		smchan:    smchan,
+		pushed:    make(map[int64]Trade, 1000),
+		pending:   make(map[int64]time.Time, 100),
+		confirmed: make(map[int64]bool, 1000),
+		missed:    make(map[int64]bool, 10),
+	}
+}
+
+func (w *TradeWatcher) Connected() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.connected
+}
+
+// Watch は path のWebSocketに接続して ctx が終わるまで成約を受け取り続ける
+func (w *TradeWatcher) Watch(ctx context.Context, c *Client, path string) error {
+	u := *c.base
+	u.Path = path
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	dialer := &websocket.Dialer{
+		Jar:              c.hc.Jar,
+		HandshakeTimeout: c.hc.Timeout,
+	}
+	if t, ok := c.hc.Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = t.TLSClientConfig
+	}
+	header := http.Header{}
+	header.Set("User-Agent", UserAgent)
+	conn, _, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		return errors.Wrapf(err, "GET %s websocket dial failed", path)
+	}
+	defer conn.Close()
+
+	w.mu.Lock()
+	w.connected = true
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		w.connected = false
+		w.mu.Unlock()
+	}()
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	for {
+		var trade Trade
+		if err := conn.ReadJSON(&trade); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return errors.Wrapf(err, "GET %s websocket read failed", path)
+		}
+		if trade.ID == 0 {
+			continue
+		}
+		w.mu.Lock()
+		w.pushed[trade.ID] = trade
+		delete(w.pending, trade.ID)
+		w.mu.Unlock()
+	}
+}
+
+// check は /info の traded_orders が通知された成約と一致しているか確かめる
+// 初めて一致が確認できた成約ごとにスコアを送る
+func (w *TradeWatcher) check(path string, orders []Order) error {
+	if w == nil || len(orders) == 0 {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.connected {
+		return nil
+	}
+	now := time.Now()
+	for _, o := range orders {
+		if o.Trade == nil {
+			continue
+		}
+		id := o.Trade.ID
+		pt, ok := w.pushed[id]
+		if !ok {
+			first, seen := w.pending[id]
+			if !seen {
+				w.pending[id] = now
+			} else if now.Sub(first) > TradeStreamGrace && !w.missed[id] {
+				w.missed[id] = true
+				return errors.Errorf("GET %s 成約がWebSocketで通知されていません [trade_id:%d]", path, id)
+			}
+			continue
+		}
+		if pt.Price != o.Trade.Price || pt.Amount != o.Trade.Amount {
+			return errors.Errorf("GET %s WebSocketで通知された成約が一致しません [trade_id:%d, price:%d, amount:%d, pushed price:%d, pushed amount:%d]",
+				path, id, o.Trade.Price, o.Trade.Amount, pt.Price, pt.Amount)
+		}
+		if !w.confirmed[id] {
+			w.confirmed[id] = true
+			select {
+			case w.smchan <- ScoreMsg{st: ScoreTypeTradePush}:
+			default:
+				log.Printf("[WARN] score queue is full. drop trade push score")
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	TUIInterval = 1 * time.Second // 画面を更新する間隔
+	TUIHistory  = 60              // スパークラインに表示する秒数
+)
+
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline は values を最大値を基準にしたブロック文字の列にする
+func sparkline(values []float64) string {
+	var max float64
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	var sb strings.Builder
+	for _, v := range values {
+		i := 0
+		if max > 0 {
+			i = int(v / max * float64(len(sparkBars)-1))
+		}
+		sb.WriteRune(sparkBars[i])
+	}
+	return sb.String()
+}
+
+// RunTUI は ctx が終わるまで TUIInterval ごとに状態を w に描き直す
+func (c *Manager) RunTUI(ctx context.Context, w io.Writer) {
+	latencies := make([]float64, 0, TUIHistory)
+	prevCount, prevElapsed := c.metrics.totals()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(TUIInterval):
+		}
+		count, elapsed := c.metrics.totals()
+		var avg float64
+		if n := count - prevCount; n > 0 {
+			avg = (elapsed - prevElapsed).Seconds() / float64(n)
+		}
+		prevCount, prevElapsed = count, elapsed
+		if len(latencies) == TUIHistory {
+			latencies = latencies[1:]
+		}
+		latencies = append(latencies, avg)
+
+		s := c.Snapshot()
+		status := c.Phase()
+		if c.Paused() {
+			status += " (paused)"
+		}
+		// 画面を消してから左上に描く
+		fmt.Fprint(w, "\x1b[2J\x1b[H")
+		fmt.Fprintf(w, "isucon8 bench   %s\n\n", status)
+		fmt.Fprintf(w, "  score   : %d\n", s.Score)
+		fmt.Fprintf(w, "  level   : %d\n", s.Level)
+		fmt.Fprintf(w, "  users   : %d / %d\n", s.ActiveUsers, s.Users)
+		fmt.Fprintf(w, "  errors  : %d\n", s.Errors)
+		fmt.Fprintf(w, "  latency : %.3fs\n\n", avg)
+		fmt.Fprintf(w, "  %s\n", sparkline(latencies))
+	}
+}
@@ -0,0 +1,306 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Workload は負荷走行中のユーザーの注文とキャンセルを記録したもの
+// -replay で同じ時刻に同じ注文を出し直せるので、アプリの修正前後を同じ負荷で比べられる
+// 取引をしないユーザー (総当たり, /info を見るだけのユーザー) は記録しない
+type Workload struct {
+	Users []*WorkloadUser `json:"users"`
+}
+
+type WorkloadUser struct {
+	At      int64            `json:"at"`                // 負荷走行の開始からのミリ秒
+	BankID  string           `json:"bank_id,omitempty"` // 既存ユーザーのみ
+	Name    string           `json:"name,omitempty"`    //
+	Pass    string           `json:"pass,omitempty"`    //
+	Credit  int64            `json:"credit"`
+	Isu     int64            `json:"isu"`
+	Actions []WorkloadAction `json:"actions"`
+}
+
+type WorkloadAction struct {
+	At     int64  `json:"at"`   // 負荷走行の開始からのミリ秒
+	Type   string `json:"type"` // buy, sell, cancel
+	Amount int64  `json:"amount,omitempty"`
+	Price  int64  `json:"price,omitempty"`
+	Order  int    `json:"order,omitempty"` // cancel する注文を出した actions の添字
+}
+
+const WorkloadCancel = "cancel"
+
+func LoadWorkload(path string) (*Workload, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "workload file open failed")
+	}
+	defer f.Close()
+	wl := &Workload{}
+	if err = json.NewDecoder(f).Decode(wl); err != nil {
+		return nil, errors.Wrap(err, "workload file decode failed")
+	}
+	if err = wl.validate(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(wl.Users, func(i, j int) bool { return wl.Users[i].At < wl.Users[j].At })
+	return wl, nil
+}
+
+func (wl *Workload) validate() error {
+	if len(wl.Users) == 0 {
+		return errors.Errorf("workload has no users")
+	}
+	for i, u := range wl.Users {
+		if u.At < 0 || u.Credit < 0 || u.Isu < 0 {
+			return errors.Errorf("workload user %d has negative value", i)
+		}
+		for j, a := range u.Actions {
+			switch a.Type {
+			case TradeTypeBuy, TradeTypeSell:
+				if a.Amount <= 0 || a.Price <= 0 {
+					return errors.Errorf("workload user %d action %d has invalid amount or price", i, j)
+				}
+			case WorkloadCancel:
+				if a.Order < 0 || a.Order >= j || u.Actions[a.Order].Type == WorkloadCancel {
+					return errors.Errorf("workload user %d action %d cancels unknown order %d", i, j, a.Order)
+				}
+			default:
+				return errors.Errorf("workload user %d action %d has unknown type %s", i, j, a.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// workloadRecorder は負荷走行中のユーザーの注文とキャンセルを記録する
+type workloadRecorder struct {
+	mu    sync.Mutex
+	start time.Time
+	users []*WorkloadUser
+}
+
+func (w *workloadRecorder) begin() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.start = time.Now()
+}
+
+func (w *workloadRecorder) since() int64 {
+	return int64(time.Since(w.start) / time.Millisecond)
+}
+
+// attach は s が取引するユーザーであれば以降の注文とキャンセルを記録する
+func (w *workloadRecorder) attach(s Scenario) {
+	ws, ok := s.(interface {
+		recordWorkload(*workloadRecorder, *WorkloadUser)
+	})
+	if !ok {
+		return
+	}
+	w.mu.Lock()
+	u := &WorkloadUser{At: w.since(), Actions: []WorkloadAction{}}
+	w.users = append(w.users, u)
+	w.mu.Unlock()
+	ws.recordWorkload(w, u)
+}
+
+// add は u の操作を記録して、その添字を返す
+func (w *workloadRecorder) add(u *WorkloadUser, a WorkloadAction) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	a.At = w.since()
+	u.Actions = append(u.Actions, a)
+	return len(u.Actions) - 1
+}
+
+func (w *workloadRecorder) WriteTo(out io.Writer) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	cw := &countWriter{w: out}
+	err := json.NewEncoder(cw).Encode(&Workload{Users: w.users})
+	return cw.n, err
+}
+
+// EnableWorkloadRecord は負荷走行中のユーザーの注文とキャンセルを記録する
+func (c *Manager) EnableWorkloadRecord() {
+	c.workload = &workloadRecorder{}
+}
+
+// WriteWorkload は記録した負荷を書き出す. EnableWorkloadRecord していなければ何もしない
+func (c *Manager) WriteWorkload(w io.Writer) error {
+	if c.workload == nil {
+		return nil
+	}
+	_, err := c.workload.WriteTo(w)
+	return err
+}
+
+// Replay は負荷走行で scoreによるlevelupや Plan の代わりに wl のユーザーと注文を再現する
+func (c *Manager) Replay(wl *Workload) {
+	c.replay = wl
+	c.Logger().Printf("記録した負荷を再生します [users:%d]", len(wl.Users))
+}
+
+// runReplay は記録したユーザーを記録した時刻に追加する
+func (c *Manager) runReplay(ctx context.Context, smchan chan ScoreMsg) {
+	start := time.Now()
+	c.idpool.reserve(len(c.replay.Users))
+	for i, u := range c.replay.Users {
+		select {
+		case <-ctx.Done():
+			handleContextErr(ctx.Err())
+			return
+		case <-time.After(time.Until(start.Add(time.Duration(u.At) * time.Millisecond))):
+		}
+		if c.admitScenarios(1) == 0 {
+			continue
+		}
+		go func(n int32, u *WorkloadUser) {
+			defer c.doneStarting()
+			c.startReplayUser(ctx, smchan, n, u, start)
+		}(int32(i+1), u)
+	}
+}
+
+func (c *Manager) startReplayUser(ctx context.Context, smchan chan ScoreMsg, n int32, u *WorkloadUser, start time.Time) {
+	var cl *Client
+	var err error
+	if u.BankID != "" {
+		cl, err = c.newClient(u.BankID, u.Name, u.Pass)
+	} else {
+		cl, err = c.NewUserClient(n, u.Credit)
+	}
+	if err != nil {
+		log.Printf("[WARN] replay user create failed. err: %s", err)
+		return
+	}
+	scenario := newReplayScenario(cl, u, start)
+	c.joinMarket(scenario)
+	if err := scenario.Start(ctx, smchan); err != nil {
+		switch errors.Cause(err) {
+		case context.DeadlineExceeded, context.Canceled:
+		default:
+			log.Printf("[INFO] scenario.Start user:%s, failed. %s", scenario.BankID(), err)
+		}
+		return
+	}
+	c.addScenario(scenario)
+}
+
+// recordWorkload は以降の注文とキャンセルを u に記録させる
+func (s *normalScenario) recordWorkload(w *workloadRecorder, u *WorkloadUser) {
+	u.Credit, u.Isu = s.defaultCredit, s.defaultIsu
+	if s.existed {
+		u.BankID, u.Name, u.Pass = s.c.bankid, s.c.name, s.c.pass
+	}
+	s.ordersLock.Lock()
+	defer s.ordersLock.Unlock()
+	s.workload = w
+	s.workloadUser = u
+	s.workloadOrders = map[int64]int{}
+}
+
+// replayScenario は記録した注文とキャンセルを記録した時刻にそのまま出す
+// /info は normalScenario と同じように見続ける
+type replayScenario struct {
+	*normalScenario
+	user  *WorkloadUser
+	start time.Time
+}
+
+func newReplayScenario(c *Client, u *WorkloadUser, start time.Time) *replayScenario {
+	s := newNormalScenario(c, u.Credit, u.Isu, 1, false)
+	if u.BankID != "" {
+		s.existed = true
+		s.ignoretest = true
+	}
+	return &replayScenario{normalScenario: s, user: u, start: start}
+}
+
+func (s *replayScenario) Start(ctx context.Context, smchan chan ScoreMsg) error {
+	if err := s.enter(ctx, smchan); err != nil {
+		return err
+	}
+
+	go s.runReplay(ctx, smchan)
+
+	go s.runInfoLoop(ctx, smchan)
+
+	return nil
+}
+
+func (s *replayScenario) runReplay(ctx context.Context, smchan chan ScoreMsg) {
+	// actions の添字から再生して出した注文
+	placed := make(map[int]*Order, len(s.user.Actions))
+	for i, a := range s.user.Actions {
+		at := s.start.Add(time.Duration(a.At) * time.Millisecond)
+		for wait := time.Until(at); wait > 0; wait = time.Until(at) {
+			select {
+			case <-ctx.Done():
+				handleContextErr(ctx.Err())
+				return
+			case <-s.actionchan:
+				// runInfoLoop が詰まらないように読み捨てる
+			case <-time.After(wait):
+			}
+		}
+		if s.c.IsRetired() {
+			return
+		}
+		st, err := s.replayAction(ctx, i, a, placed)
+		if st == 0 {
+			continue
+		}
+		smchan <- ScoreMsg{st: st, err: err}
+		if err != nil {
+			if _, ok := errors.Cause(err).(*ErrElapsedTimeOverRetire); ok {
+				return
+			}
+			continue
+		}
+		if err = s.reportTraded(ctx, smchan); err != nil {
+			if _, ok := errors.Cause(err).(*ErrElapsedTimeOverRetire); ok {
+				return
+			}
+		}
+	}
+	// 再生し終わったら /info を見るだけになる
+	for {
+		select {
+		case <-ctx.Done():
+			handleContextErr(ctx.Err())
+			return
+		case <-s.actionchan:
+		}
+	}
+}
+
+func (s *replayScenario) replayAction(ctx context.Context, i int, a WorkloadAction, placed map[int]*Order) (ScoreType, error) {
+	s.ordersLock.Lock()
+	defer s.ordersLock.Unlock()
+	if a.Type == WorkloadCancel {
+		o := placed[a.Order]
+		if o == nil || o.ClosedAt != nil {
+			// 記録したときと違って注文できていないか、もう成約している
+			return 0, nil
+		}
+		return s.cancelOrder(ctx, o)
+	}
+	n := len(s.orders)
+	st, err := s.placeOrder(ctx, a.Type, a.Amount, a.Price)
+	if len(s.orders) > n {
+		placed[i] = s.orders[n]
+	}
+	return st, err
+}
@@ -0,0 +1,54 @@
+package bench
+
+import (
+	"strings"
+	"testing"
+)
+
+func validWorkload() *Workload {
+	return &Workload{Users: []*WorkloadUser{
+		{At: 0, Credit: 10000, Isu: 10, Actions: []WorkloadAction{
+			{At: 100, Type: TradeTypeBuy, Amount: 1, Price: 100},
+			{At: 200, Type: TradeTypeSell, Amount: 2, Price: 120},
+			{At: 300, Type: WorkloadCancel, Order: 1},
+		}},
+		{At: 500, BankID: "existing"},
+	}}
+}
+
+func TestWorkloadValidate(t *testing.T) {
+	if err := validWorkload().validate(); err != nil {
+		t.Errorf("valid workload failed: %s", err)
+	}
+
+	specs := []struct {
+		title  string
+		modify func(*Workload)
+		err    string
+	}{
+		{"no users", func(wl *Workload) { wl.Users = nil }, "no users"},
+		{"negative at", func(wl *Workload) { wl.Users[1].At = -1 }, "user 1 has negative value"},
+		{"negative credit", func(wl *Workload) { wl.Users[0].Credit = -1 }, "user 0 has negative value"},
+		{"zero amount", func(wl *Workload) { wl.Users[0].Actions[0].Amount = 0 }, "action 0 has invalid amount or price"},
+		{"zero price", func(wl *Workload) { wl.Users[0].Actions[1].Price = 0 }, "action 1 has invalid amount or price"},
+		{"cancel later order", func(wl *Workload) { wl.Users[0].Actions[2].Order = 2 }, "cancels unknown order 2"},
+		{"cancel negative order", func(wl *Workload) { wl.Users[0].Actions[2].Order = -1 }, "cancels unknown order -1"},
+		{"cancel cancel", func(wl *Workload) {
+			u := wl.Users[0]
+			u.Actions = append(u.Actions, WorkloadAction{At: 400, Type: WorkloadCancel, Order: 2})
+		}, "action 3 cancels unknown order 2"},
+		{"unknown type", func(wl *Workload) { wl.Users[0].Actions[0].Type = "hold" }, "unknown type hold"},
+	}
+	for _, s := range specs {
+		wl := validWorkload()
+		s.modify(wl)
+		err := wl.validate()
+		if err == nil {
+			t.Errorf("%s: invalid workload was valid", s.title)
+			continue
+		}
+		if !strings.Contains(err.Error(), s.err) {
+			t.Errorf("%s: unexpected error: got:%s expected:%s", s.title, err, s.err)
+		}
+	}
+}
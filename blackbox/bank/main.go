@@ -7,6 +7,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math/rand"
 
 	//	"encoding/json"
 	//	"flag"
@@ -32,6 +33,13 @@ const (
 var cacheBankID = make(map[string]int64, 1000)
 var cacheBankIDMutex sync.RWMutex
 
+// check, reserve, commit, cancel に追加する遅延
+// 遅い外部の銀行を相手にしたときの動作を確かめる用
+var (
+	extraLatency  time.Duration
+	latencyJitter time.Duration
+)
+
 func main() {
 	var (
 		port   = flag.Int("port", 5515, "bank app running port")
@@ -40,9 +48,16 @@ func main() {
 		dbuser = flag.String("dbuser", "root", "database user")
 		dbpass = flag.String("dbpass", "", "database pass")
 		dbname = flag.String("dbname", "isubank", "database name")
+
+		latency = flag.Duration("latency", 0, "extra latency of check/reserve/commit/cancel")
+		jitter  = flag.Duration("jitter", 0, "random extra latency up to this value")
 	)
 
 	flag.Parse()
+	extraLatency, latencyJitter = *latency, *jitter
+	if extraLatency > 0 || latencyJitter > 0 {
+		log.Printf("[INFO] latency injection enabled. latency: %s, jitter: %s", extraLatency, latencyJitter)
+	}
 
 	addr := fmt.Sprintf(":%d", *port)
 	dbup := *dbuser
@@ -77,10 +92,12 @@ func NewServer(db *sql.DB) http.Handler {
 	server.HandleFunc("/register", h.Register)
 	server.HandleFunc("/add_credit", h.AddCredit)
 	server.HandleFunc("/credit", h.GetCredit)
+	server.HandleFunc("/credit_history", h.GetCreditHistory)
 	server.HandleFunc("/initialize", h.Initialize)
 	server.HandleFunc("/check", sleepHandle(h.Check, 50*time.Millisecond))
-	server.HandleFunc("/reserve", sleepHandle(h.Reserve, 70*time.Millisecond))
-	server.HandleFunc("/commit", sleepHandle(h.Commit, 300*time.Millisecond))
+	server.HandleFunc("/reserve", sleepHandle(faultHandle(h.Reserve), 70*time.Millisecond))
+	server.HandleFunc("/commit", sleepHandle(faultHandle(h.Commit), 300*time.Millisecond))
+	server.HandleFunc("/faults", h.Faults)
 	server.HandleFunc("/cancel", sleepHandle(h.Cancel, 80*time.Millisecond))
 
 	// default 404
@@ -108,11 +125,86 @@ func authHandler(f http.Handler) http.Handler {
 
 func sleepHandle(f http.HandlerFunc, sleep time.Duration) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		time.Sleep(sleep)
+		time.Sleep(sleep + injectedLatency())
 		f.ServeHTTP(w, r)
 	})
 }
 
+func injectedLatency() time.Duration {
+	d := extraLatency
+	if latencyJitter > 0 {
+		d += time.Duration(rand.Int63n(int64(latencyJitter)))
+	}
+	return d
+}
+
+// Fault はアプリごとに reserve, commit へ注入する障害の設定と注入した回数
+type Fault struct {
+	FailRate    int   `json:"fail_rate"`    // エラーを返す割合(%)
+	TimeoutRate int   `json:"timeout_rate"` // 応答せずに接続を切る割合(%)
+	Timeout     int64 `json:"timeout"`      // 接続を切るまでの時間(ms)
+	Latency     int64 `json:"latency"`      // すべての呼び出しに追加する遅延(ms)
+
+	Failed   int64 `json:"failed"`
+	TimedOut int64 `json:"timed_out"`
+}
+
+var faults = map[string]*Fault{}
+var faultsMutex sync.Mutex
+
+// injectFault は注入する障害を決めて回数を数える. 0: なし, 1: エラー, 2: タイムアウト
+// あわせて追加する遅延を返す
+func injectFault(appid string) (int, time.Duration, time.Duration) {
+	faultsMutex.Lock()
+	defer faultsMutex.Unlock()
+	f, ok := faults[appid]
+	if !ok {
+		return 0, 0, 0
+	}
+	latency := time.Duration(f.Latency) * time.Millisecond
+	n := rand.Intn(100)
+	switch {
+	case n < f.FailRate:
+		f.Failed++
+		return 1, 0, latency
+	case n < f.FailRate+f.TimeoutRate:
+		f.TimedOut++
+		return 2, time.Duration(f.Timeout) * time.Millisecond, latency
+	}
+	return 0, 0, latency
+}
+
+func faultHandle(f http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appid, err := appID(r)
+		if err != nil {
+			f.ServeHTTP(w, r)
+			return
+		}
+		kind, timeout, latency := injectFault(appid)
+		time.Sleep(latency)
+		switch kind {
+		case 1:
+			Error(w, "injected failure", http.StatusInternalServerError)
+		case 2:
+			time.Sleep(timeout)
+			hj, ok := w.(http.Hijacker)
+			if !ok {
+				Error(w, "injected timeout", http.StatusGatewayTimeout)
+				return
+			}
+			conn, _, err := hj.Hijack()
+			if err != nil {
+				Error(w, "injected timeout", http.StatusGatewayTimeout)
+				return
+			}
+			conn.Close()
+		default:
+			f.ServeHTTP(w, r)
+		}
+	})
+}
+
 func appID(r *http.Request) (string, error) {
 	v := r.Context().Value(AppIDCtxKey)
 	if v == nil {
@@ -235,6 +327,88 @@ func (s *Handler) GetCredit(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, fmt.Sprintf(`{"credit":%d}`, credit))
 }
 
+// GetCreditHistory は Get /credit_history を処理
+// ユーザーの確定済みの入出金履歴をこっそり確認できます
+func (s *Handler) GetCreditHistory(w http.ResponseWriter, r *http.Request) {
+	bankID := r.URL.Query().Get("bank_id")
+	userID := s.filterBankID(w, bankID)
+	if userID <= 0 {
+		return
+	}
+	type History struct {
+		Amount    int64     `json:"amount"`
+		Note      string    `json:"note"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	rows, err := s.db.Query(`SELECT amount, note, created_at FROM credit WHERE user_id = ? ORDER BY id`, userID)
+	if err != nil {
+		Error(w, fmt.Sprintf("select credit history failed. err:%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	history := []History{}
+	for rows.Next() {
+		var h History
+		if err := rows.Scan(&h.Amount, &h.Note, &h.CreatedAt); err != nil {
+			Error(w, fmt.Sprintf("select credit history failed. err:%s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		history = append(history, h)
+	}
+	if err := rows.Err(); err != nil {
+		Error(w, fmt.Sprintf("select credit history failed. err:%s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(map[string]interface{}{"history": history})
+}
+
+// Faults は GET, POST /faults を処理
+// POST で reserve, commit に注入する障害を設定し、GET で設定と注入した回数を返します
+// 設定を変えても注入した回数は引き継ぎます. すべて0にすると障害の注入をやめます
+func (s *Handler) Faults(w http.ResponseWriter, r *http.Request) {
+	appid, err := appID(r)
+	if err != nil {
+		Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	faultsMutex.Lock()
+	defer faultsMutex.Unlock()
+	switch r.Method {
+	case "GET":
+	case "POST":
+		req := &Fault{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			Error(w, "can't parse body", http.StatusBadRequest)
+			return
+		}
+		if req.FailRate < 0 || req.TimeoutRate < 0 || req.FailRate+req.TimeoutRate > 100 || req.Timeout < 0 {
+			Error(w, "fault rate is out of range", http.StatusBadRequest)
+			return
+		}
+		if req.Latency < 0 {
+			Error(w, "latency is out of range", http.StatusBadRequest)
+			return
+		}
+		f, ok := faults[appid]
+		if !ok {
+			f = &Fault{}
+			faults[appid] = f
+		}
+		f.FailRate, f.TimeoutRate, f.Timeout, f.Latency = req.FailRate, req.TimeoutRate, req.Timeout, req.Latency
+		log.Printf("[INFO] fault injection changed. app: %s, fail: %d%%, timeout: %d%% (%dms), latency: %dms", appid, f.FailRate, f.TimeoutRate, f.Timeout, f.Latency)
+	default:
+		Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f, ok := faults[appid]
+	if !ok {
+		f = &Fault{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(f)
+}
+
 // Check は POST /check を処理
 // 確定済み要求金額を保有しているかどうかを確認します
 func (s *Handler) Check(w http.ResponseWriter, r *http.Request) {
@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
@@ -59,8 +60,10 @@ func NewServer() http.Handler {
 		waiting: make(map[string]*int64, 1000),
 	}
 
-	server.HandleFunc("/send", h.Send)
-	server.HandleFunc("/send_bulk", h.SendBulk)
+	server.HandleFunc("/send", faultHandle(h.Send))
+	server.HandleFunc("/send_bulk", faultHandle(h.SendBulk))
+	server.HandleFunc("/faults", h.Faults)
+	server.HandleFunc("/stats", h.Stats)
 	server.HandleFunc("/logs", h.Logs)
 	server.HandleFunc("/initialize", h.Initialize)
 
@@ -99,6 +102,75 @@ func appID(r *http.Request) (string, error) {
 	return id, nil
 }
 
+// Fault はアプリごとに send, send_bulk へ注入する障害の設定と注入した回数
+type Fault struct {
+	FailRate int   `json:"fail_rate"` // ログを保存せずに503を返す割合(%)
+	Latency  int64 `json:"latency"`   // 追加する遅延(ms)
+
+	Failed int64 `json:"failed"`
+}
+
+var faults = map[string]*Fault{}
+var faultsMutex sync.Mutex
+
+func injectFault(appid string) (bool, time.Duration) {
+	faultsMutex.Lock()
+	defer faultsMutex.Unlock()
+	f, ok := faults[appid]
+	if !ok {
+		return false, 0
+	}
+	latency := time.Duration(f.Latency) * time.Millisecond
+	if rand.Intn(100) < f.FailRate {
+		f.Failed++
+		return true, latency
+	}
+	return false, latency
+}
+
+func faultHandle(f http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appid, err := appID(r)
+		if err != nil {
+			f.ServeHTTP(w, r)
+			return
+		}
+		fail, latency := injectFault(appid)
+		time.Sleep(latency)
+		if fail {
+			Error(w, "service unavailable (injected)", http.StatusServiceUnavailable)
+			return
+		}
+		f.ServeHTTP(w, r)
+	})
+}
+
+// Stats はアプリごとに send, send_bulk で受け取った回数とログの数
+type Stats struct {
+	Send     int64 `json:"send"`
+	SendBulk int64 `json:"send_bulk"`
+	BulkLogs int64 `json:"bulk_logs"`
+}
+
+var stats = map[string]*Stats{}
+var statsMutex sync.Mutex
+
+func countSend(appid string, bulk int) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	st, ok := stats[appid]
+	if !ok {
+		st = &Stats{}
+		stats[appid] = st
+	}
+	if bulk < 0 {
+		st.Send++
+		return
+	}
+	st.SendBulk++
+	st.BulkLogs += int64(bulk)
+}
+
 type badRequestErr struct {
 	s string
 }
@@ -224,6 +296,7 @@ func (s *Handler) Send(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	logStorage.Append(appid, l)
+	countSend(appid, -1)
 	time.Sleep(sendDelay)
 	Success(w)
 }
@@ -261,6 +334,7 @@ func (s *Handler) SendBulk(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	logStorage.AppendBulk(appid, logs)
+	countSend(appid, len(logs))
 	time.Sleep(sendDelay)
 	Success(w)
 }
@@ -298,6 +372,69 @@ func (s *Handler) Logs(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(logs)
 }
 
+// Faults は GET, POST /faults を処理
+// POST で send, send_bulk に注入する障害を設定し、GET で設定と注入した回数を返します
+func (s *Handler) Faults(w http.ResponseWriter, r *http.Request) {
+	appid, err := appID(r)
+	if err != nil {
+		Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	faultsMutex.Lock()
+	defer faultsMutex.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		req := Fault{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			Error(w, fmt.Sprintf("can't parse body. err:%s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		if req.FailRate < 0 || req.FailRate > 100 || req.Latency < 0 {
+			Error(w, "fault is out of range", http.StatusBadRequest)
+			return
+		}
+		f, ok := faults[appid]
+		if !ok {
+			f = &Fault{}
+			faults[appid] = f
+		}
+		f.FailRate, f.Latency = req.FailRate, req.Latency
+		log.Printf("[INFO] fault injection changed. app: %s, fail: %d%%, latency: %dms", appid, f.FailRate, f.Latency)
+	default:
+		Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	f, ok := faults[appid]
+	if !ok {
+		f = &Fault{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(f)
+}
+
+// Stats は GET /stats を処理
+// send, send_bulk を呼ばれた回数と send_bulk で受け取ったログの数を返します
+func (s *Handler) Stats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	appid, err := appID(r)
+	if err != nil {
+		Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	st, ok := stats[appid]
+	if !ok {
+		st = &Stats{}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(st)
+}
+
 func (s *Handler) Initialize(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		Error(w, "", http.StatusMethodNotAllowed)
@@ -307,6 +444,9 @@ func (s *Handler) Initialize(w http.ResponseWriter, r *http.Request) {
 	mu.Lock()
 	logStorage = NewStorage()
 	mu.Unlock()
+	statsMutex.Lock()
+	stats = map[string]*Stats{}
+	statsMutex.Unlock()
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	fmt.Fprintln(w, `{"ok":true}`)
@@ -234,3 +234,50 @@ func TestLogsUserIDTradeID(t *testing.T) {
 		t.Error("unexpected logs len")
 	}
 }
+
+func TestFaults(t *testing.T) {
+	specs := []Spec{
+		{"/faults out of range", "POST", "/faults", "CCC", "application/json", []byte(`{"fail_rate":101}`), 400},
+		{"/faults enable", "POST", "/faults", "CCC", "application/json", []byte(`{"fail_rate":100}`), 200},
+		{"/send unavailable", "POST", "/send", "CCC", "application/json", []byte(`{"tag":"xxx","time":"2018-09-20T11:22:33Z","data":{"user_id":124,"trade_id":999,"x":"y"}}`), 503},
+		{"/send other app", "POST", "/send", "DDD", "application/json", []byte(`{"tag":"xxx","time":"2018-09-20T11:22:33Z","data":{"user_id":124,"trade_id":999,"x":"y"}}`), 200},
+		{"/faults disable", "POST", "/faults", "CCC", "application/json", []byte(`{"fail_rate":0}`), 200},
+		{"/send recovered", "POST", "/send", "CCC", "application/json", []byte(`{"tag":"xxx","time":"2018-09-20T11:22:33Z","data":{"user_id":124,"trade_id":999,"x":"y"}}`), 200},
+	}
+	for _, spec := range specs {
+		spec.Run(t, ts.URL)
+	}
+	b, err := Spec{Title: "/faults", Method: "GET", Path: "/faults", AppID: "CCC", StatusCode: 200}.Run(t, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f main.Fault
+	if err := json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.Failed != 1 || f.FailRate != 0 {
+		t.Errorf("unexpected fault: %+v", f)
+	}
+}
+
+func TestStats(t *testing.T) {
+	specs := []Spec{
+		{"/send", "POST", "/send", "EEE", "application/json", []byte(`{"tag":"xxx","time":"2018-09-20T11:22:33Z","data":{"user_id":124,"trade_id":999,"x":"y"}}`), 200},
+		{"/send_bulk", "POST", "/send_bulk", "EEE", "application/json", []byte(`[{"tag":"xxx","time":"2018-09-20T11:22:33Z","data":{"user_id":124,"trade_id":999,"x":"y"}},{"tag":"xxx","time":"2018-09-20T11:22:33Z","data":{"user_id":125,"trade_id":333,"x":"y"}}]`), 200},
+		{"/send_bulk no tag", "POST", "/send_bulk", "EEE", "application/json", []byte(`[{"tag":"","time":"2018-09-20T11:22:33Z","data":{"user_id":124,"trade_id":999,"x":"y"}}]`), 400},
+	}
+	for _, spec := range specs {
+		spec.Run(t, ts.URL)
+	}
+	b, err := Spec{Title: "/stats", Method: "GET", Path: "/stats", AppID: "EEE", StatusCode: 200}.Run(t, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st main.Stats
+	if err := json.Unmarshal(b, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Send != 1 || st.SendBulk != 1 || st.BulkLogs != 2 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
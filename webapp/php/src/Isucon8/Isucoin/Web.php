@@ -222,11 +222,16 @@ $app->post('/orders', function (Request $request, Response $response): Response
     }
     $amount = $request->getParsedBodyParam('amount');
     $price = $request->getParsedBodyParam('price');
+    $type = $request->getParsedBodyParam('type');
+    // 数値でない値をそのまま AddOrder に渡すと TypeError で 500 になるので先に弾く
+    if (!is_numeric($amount) || !is_numeric($price) || !is_string($type)) {
+        return resError($response, (new ParameterInvalidException())->getMessage(), 400);
+    }
 
     $tx = $this->dbh->beginTxn();
     $order = null;
     try {
-        $order = AddOrder($tx, $request->getParsedBodyParam('type'), $user['id'], $amount, $price);
+        $order = AddOrder($tx, $type, $user['id'], $amount, $price);
         $tx->commit();
     } catch(ParameterInvalidException | CreditInsufficientException $e) {
         $tx->rollback();
@@ -262,14 +262,17 @@ def add_order():
     if user is None:
         return error_json(401, "Not authenticated")
 
-    amount = int(flask.request.form["amount"])
-    price = int(flask.request.form["price"])
-    type = flask.request.form["type"]
+    try:
+        amount = int(flask.request.form["amount"])
+        price = int(flask.request.form["price"])
+        type = flask.request.form["type"]
+    except (KeyError, ValueError):
+        return error_json(400, model.ParameterInvalid.msg)
 
     try:
         with transaction() as db:
             order = model.add_order(db, type, user.id, amount, price)
-    except model.CreditInsufficient as e:
+    except (model.ParameterInvalid, model.CreditInsufficient) as e:
         return error_json(400, e.msg)
 
     db = get_dbconn()
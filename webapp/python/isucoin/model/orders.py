@@ -19,6 +19,10 @@ class CreditInsufficient(Exception):
     msg = "銀行の残高が足りません"
 
 
+class ParameterInvalid(Exception):
+    msg = "parameter invalid"
+
+
 @dataclass
 class Order:
     id: int
@@ -127,7 +131,7 @@ def fetch_order_relation(db, order: Order):
 
 def add_order(db, ot: str, user_id: int, amount: int, price: int) -> Order:
     if amount <= 0 or price <= 0:
-        raise ValueError
+        raise ParameterInvalid
     user = users.get_user_by_id_with_lock(db, user_id)
 
     bank = settings.get_isubank(db)
@@ -146,7 +150,7 @@ def add_order(db, ot: str, user_id: int, amount: int, price: int) -> Order:
     elif ot == "sell":
         pass
     else:
-        raise ValueError
+        raise ParameterInvalid
 
     cur = db.cursor()
     cur.execute(
@@ -177,8 +177,9 @@ module Isucoin
 
     post '/orders', login_required: true do
       user = user_by_request()
-      amount = params[:amount]&.to_i
-      price = params[:price]&.to_i
+      # 無ければ 0 として ParameterInvalid にする
+      amount = params[:amount].to_i
+      price = params[:price].to_i
 
       begin
         rollback = false